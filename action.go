@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -20,11 +21,13 @@ type Action struct {
 	name          string
 	subsidiary    string
 	metaData      map[string]string
-	timeout       time.Duration
+	timeout       int64 // time.Duration, 原子读写以支持配置热更新
 	instancesPool sync.Pool
 	newHandle     func() interface{}
 	input         map[string]ActionField
 	output        map[string]ActionField
+	authz         AuthzRequirement
+	ipFilter      *IPFilter
 }
 
 type ActionField struct {
@@ -46,7 +49,7 @@ func (act *Action) MetaData() map[string]string {
 	return act.metaData
 }
 func (act *Action) Timeout() time.Duration {
-	return act.timeout
+	return time.Duration(atomic.LoadInt64(&act.timeout))
 }
 func (act *Action) Instance() *ProcessorWrap {
 	return act.newHandle().(*ProcessorWrap)
@@ -67,7 +70,7 @@ type Processor interface {
 
 func SetActionTimeout(name string, timeout time.Duration) {
 	if v, ok := actions[name]; ok {
-		v.timeout = timeout
+		atomic.StoreInt64(&v.timeout, int64(timeout))
 	}
 }
 
@@ -89,6 +92,8 @@ func RegisterAction(name string, metaData map[string]string, new func() interfac
 		newHandle:     new,
 		input:         parseParameter(new().(*ProcessorWrap), "Input"),
 		output:        parseParameter(new().(*ProcessorWrap), "Output"),
+		authz:         parseAuthzRequirement(metaData),
+		ipFilter:      parseActionIPFilter(name, metaData),
 	}
 }
 
@@ -109,12 +114,21 @@ func RunProcessor(s unsafe.Pointer, n uintptr, p Processor, ctx *Context) (strin
 
 // CallAction 消化其他错误，返回框架层面错误及其他panic
 func CallAction(gctx context.Context, s *Session, request *Request) (err error) {
+	return callAction(gctx, s, request, nil)
+}
+
+// RejectRequest 不执行action, 以reason作为错误响应请求, 仍经过hook.OnResponse及OnFinish
+func RejectRequest(gctx context.Context, s *Session, request *Request, reason error) (err error) {
+	return callAction(gctx, s, request, reason)
+}
+
+func callAction(gctx context.Context, s *Session, request *Request, reject error) (err error) {
 	var act *Action
 	var timeout time.Duration = ActionDefaultTimeout
 	hook := s.Server.Hook()
 
-	if act = actions[request.ActionName]; act != nil && act.timeout > 0 {
-		timeout = act.timeout
+	if act = actions[request.ActionName]; act != nil && act.Timeout() > 0 {
+		timeout = act.Timeout()
 	}
 	ctx := NewPlayContext(gctx, s, request, timeout)
 
@@ -140,8 +154,29 @@ func CallAction(gctx context.Context, s *Session, request *Request) (err error)
 		}()
 	}()
 
-	if ctx.err = hook.OnRequest(ctx); ctx.Err() == nil {
-		run(act, ctx)
+	if ctx.err = reject; ctx.err == nil {
+		ctx.err = checkActionIP(act, ctx)
+	}
+	if ctx.err == nil {
+		ctx.err = authenticate(ctx)
+	}
+	if ctx.err == nil {
+		ctx.err = hook.OnRequest(ctx)
+	}
+	if ctx.err == nil {
+		ctx.err = authorize(act, ctx)
+	}
+	if ctx.Err() == nil {
+		if release, err := acquireQuota(act, ctx); err != nil {
+			ctx.err = err
+		} else {
+			defer release()
+			callIdempotent(act, ctx, func() {
+				if !enqueueJob(act, ctx) {
+					run(act, ctx)
+				}
+			})
+		}
 	}
 
 	if !ctx.ActionRequest.NonRespond {
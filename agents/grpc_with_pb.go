@@ -0,0 +1,217 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/packers"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GrpcWithPb 通过grpc调用服务, action为 package.Service.Method,
+// 请求及响应结构按json tag与proto字段名对应
+var GrpcWithPb = &grpcWithPb{router: make(map[string]string)}
+
+type grpcWithPb struct {
+	router          map[string]string
+	fileDescriptors []protoreflect.FileDescriptor
+	tlsConfig       *tls.Config
+	mutex           sync.Mutex
+	h2cClient       *http.Client
+	tlsClient       *http.Client
+}
+
+// SetRouter host为 http://ip:port (h2c) 或 https://ip:port
+func (a *grpcWithPb) SetRouter(servie string, host string) {
+	a.router[servie] = host
+}
+
+// SetFileDescriptors 未设置时从protoregistry.GlobalFiles查找service定义
+func (a *grpcWithPb) SetFileDescriptors(fileDescriptors ...protoreflect.FileDescriptor) {
+	a.fileDescriptors = append(a.fileDescriptors, fileDescriptors...)
+}
+
+func (a *grpcWithPb) SetTlsConfig(cfg *tls.Config) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tlsConfig, a.tlsClient = cfg, nil
+}
+
+func (a *grpcWithPb) getClient(host string) *http.Client {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if strings.HasPrefix(host, "https://") {
+		if a.tlsClient == nil {
+			a.tlsClient = &http.Client{Transport: &http2.Transport{TLSClientConfig: a.tlsConfig}}
+		}
+		return a.tlsClient
+	}
+	if a.h2cClient == nil {
+		a.h2cClient = &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}}
+	}
+	return a.h2cClient
+}
+
+func (a *grpcWithPb) getMethod(action string) (protoreflect.MethodDescriptor, error) {
+	index := strings.LastIndex(action, ".")
+	if index <= 0 {
+		return nil, errors.New("invalid grpc action:" + action)
+	}
+	service, method := protoreflect.FullName(action[:index]), protoreflect.Name(action[index+1:])
+	for _, fd := range a.fileDescriptors {
+		if fd.Package() != service.Parent() {
+			continue
+		}
+		if sd := fd.Services().ByName(service.Name()); sd != nil {
+			if md := sd.Methods().ByName(method); md != nil {
+				return md, nil
+			}
+		}
+	}
+	if d, err := protoregistry.GlobalFiles.FindDescriptorByName(service); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			if md := sd.Methods().ByName(method); md != nil {
+				return md, nil
+			}
+		}
+	}
+	return nil, errors.New("grpc method descriptor not found:" + action)
+}
+
+func (a *grpcWithPb) Request(ctx context.Context, service string, action string, body []byte) ([]byte, error) {
+	var err error
+	var host string
+	var resp *http.Response
+	if host = a.router[service]; host == "" {
+		return nil, errors.New("service:" + service + " router not found")
+	}
+
+	index := strings.LastIndex(action, ".")
+	url := host + "/" + action[:index] + "/" + action[index+1:]
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(packers.EncodeGrpcFrame(body)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", packers.EncodeGrpcTimeout(time.Until(deadline)))
+	}
+	if pctx, ok := ctx.(*play.Context); ok {
+		packers.SetGrpcMetadata(req.Header, pctx.Metadata)
+	}
+
+	if resp, err = a.getClient(host).Do(req); err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("http status error:" + resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// trailers-only响应时状态在header中
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return nil, errors.New("grpc status " + status + ": " + packers.DecodeGrpcMessage(message))
+	}
+	return packers.ReadGrpcFrame(bytes.NewReader(data), 0)
+}
+
+func (a *grpcWithPb) Marshal(ctx context.Context, service string, action string, i interface{}) ([]byte, error) {
+	method, err := a.getMethod(action)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(method.Input())
+	if err = (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(message)
+}
+
+func (a *grpcWithPb) Unmarshal(ctx context.Context, service string, action string, data []byte, i interface{}) error {
+	method, err := a.getMethod(action)
+	if err != nil {
+		return err
+	}
+
+	message := dynamicpb.NewMessage(method.Output())
+	if err = proto.Unmarshal(data, message); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(protobufToMap(message)); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, i)
+}
+
+// protobufToMap 以proto字段名为key转换为map, int64保持数值类型
+func protobufToMap(message protoreflect.Message) map[string]interface{} {
+	var m = make(map[string]interface{})
+	message.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			var list = make([]interface{}, 0, v.List().Len())
+			for i := 0; i < v.List().Len(); i++ {
+				list = append(list, protobufValue(fd, v.List().Get(i)))
+			}
+			m[string(fd.Name())] = list
+		case fd.IsMap():
+			var mm = make(map[string]interface{}, v.Map().Len())
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				mm[k.String()] = protobufValue(fd.MapValue(), mv)
+				return true
+			})
+			m[string(fd.Name())] = mm
+		default:
+			m[string(fd.Name())] = protobufValue(fd, v)
+		}
+		return true
+	})
+	return m
+}
+
+func protobufValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protobufToMap(v.Message())
+	case protoreflect.EnumKind:
+		return int32(v.Enum())
+	}
+	return v.Interface()
+}
@@ -0,0 +1,94 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+)
+
+var Http3WithJson = &http3WithJson{router: make(map[string]string)}
+
+type http3WithJson struct {
+	router    map[string]string
+	tlsConfig *tls.Config
+	mutex     sync.Mutex
+	client    *http.Client
+}
+
+func (a *http3WithJson) SetRouter(servie string, host string) {
+	a.router[servie] = host
+}
+
+// SetTlsConfig 设置后重建连接, 未设置时不校验服务端证书
+func (a *http3WithJson) SetTlsConfig(cfg *tls.Config) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.client != nil {
+		a.client.Transport.(*http3.RoundTripper).Close()
+		a.client = nil
+	}
+	a.tlsConfig = cfg
+}
+
+func (a *http3WithJson) getClient() *http.Client {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.client == nil {
+		cfg := a.tlsConfig
+		if cfg == nil {
+			cfg = &tls.Config{InsecureSkipVerify: true}
+		}
+		a.client = &http.Client{Transport: &http3.RoundTripper{TLSClientConfig: cfg}}
+	}
+	return a.client
+}
+
+func (a *http3WithJson) Request(ctx context.Context, service string, action string, body []byte) ([]byte, error) {
+	var err error
+	var host string
+	var resp *http.Response
+	if host = a.router[service]; host == "" {
+		return nil, errors.New("service:" + service + " router not found")
+	}
+
+	url := host + "/" + strings.ReplaceAll(action, ".", "/")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if resp, err = a.getClient().Do(req); err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("http status error:" + resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (a *http3WithJson) Marshal(ctx context.Context, service string, action string, i interface{}) ([]byte, error) {
+	var err error
+	var body []byte
+
+	if body, err = json.Marshal(i); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (a *http3WithJson) Unmarshal(ctx context.Context, service string, action string, data []byte, i interface{}) error {
+	return json.Unmarshal(data, i)
+}
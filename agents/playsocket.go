@@ -2,15 +2,45 @@ package agents
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/leochen2038/play/codec/protos/golang/json"
 	"github.com/leochen2038/play/codec/protos/pproto"
 )
 
+// PlaySocketMaxIdle 每个地址保留的空闲连接数, 空闲连接仍会接收服务端推送
+var PlaySocketMaxIdle = 16
+
 type PlaySocket struct {
 	routerHandle func(ctx context.Context, service, action string) string
+	tlsConfig    *tls.Config
+	mutex        sync.Mutex
+	idle         map[string][]*socketConn
+}
+
+// socketConn 持久连接, 读取goroutine将推送消息分发给处理函数, 响应交给等待中的请求
+type socketConn struct {
+	net.Conn
+	responses chan pproto.PlayProtocolResponse
+	done      chan struct{}
+	err       error
+}
+
+// SetTlsConfig 使用tls连接服务端, config中设置Certificates或GetClientCertificate即开启mTLS; 已建立的连接将被关闭
+func (a *PlaySocket) SetTlsConfig(config *tls.Config) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.tlsConfig = config
+	for _, list := range a.idle {
+		for _, conn := range list {
+			_ = conn.Close()
+		}
+	}
+	a.idle = nil
 }
 
 func (a *PlaySocket) SetRouterHandle(handle func(ctx context.Context, service, action string) string) {
@@ -18,31 +48,122 @@ func (a *PlaySocket) SetRouterHandle(handle func(ctx context.Context, service, a
 }
 
 func (a *PlaySocket) Request(ctx context.Context, service string, action string, body []byte) ([]byte, error) {
-	var d net.Dialer
-	conn, err := d.DialContext(ctx, "tcp", a.routerHandle(ctx, service, action))
+	var address = a.routerHandle(ctx, service, action)
+	conn, err := a.getConn(ctx, address)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-	if _, err := conn.Write(body); err != nil {
+	if _, err = conn.Write(body); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	select {
+	case protocol := <-conn.responses:
+		a.putConn(address, conn)
+		return protocol.Body, nil
+	case <-conn.done:
+		select {
+		case protocol := <-conn.responses:
+			return protocol.Body, nil
+		default:
+		}
+		log.Println("[play server]", conn.err, "on", conn.RemoteAddr().String())
+		return nil, conn.err
+	case <-ctx.Done():
+		// 响应无法再对应请求, 连接不可复用
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+}
+
+func (a *PlaySocket) getConn(ctx context.Context, address string) (*socketConn, error) {
+	a.mutex.Lock()
+	for list := a.idle[address]; len(list) > 0; list = a.idle[address] {
+		conn := list[len(list)-1]
+		a.idle[address] = list[:len(list)-1]
+		if !conn.broken() {
+			a.mutex.Unlock()
+			return conn, nil
+		}
+	}
+	var config = a.tlsConfig
+	a.mutex.Unlock()
+
+	var err error
+	var nconn net.Conn
+	if config != nil {
+		d := tls.Dialer{Config: config}
+		nconn, err = d.DialContext(ctx, "tcp", address)
+	} else {
+		var d net.Dialer
+		nconn, err = d.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
 		return nil, err
 	}
+	conn := &socketConn{Conn: nconn, responses: make(chan pproto.PlayProtocolResponse, 1), done: make(chan struct{})}
+	go conn.readLoop()
+	return conn, nil
+}
+
+func (a *PlaySocket) putConn(address string, conn *socketConn) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.idle == nil {
+		a.idle = make(map[string][]*socketConn)
+	}
+	if len(a.idle[address]) >= PlaySocketMaxIdle {
+		_ = conn.Close()
+		return
+	}
+	a.idle[address] = append(a.idle[address], conn)
+}
+
+// readLoop 连接在池中空闲时同样能收到推送, 推送按顺序分发
+func (conn *socketConn) readLoop() {
 	var buffer = make([]byte, 4096)
 	var surplus []byte
+	var err error
+
+	defer func() {
+		conn.err = err
+		close(conn.done)
+		_ = conn.Close()
+	}()
 	for {
-		n, err := conn.Read(buffer)
-		if err != nil {
-			log.Println("[play server]", err, "on", conn.RemoteAddr().String())
-			return nil, err
+		protocol, dataSize, perr := pproto.UnmarshalProtocolResponse(surplus)
+		if err = perr; err != nil {
+			return
 		}
-		protocol, dataSize, err := pproto.UnmarshalProtocolResponse(append(surplus, buffer[:n]...))
-		if err != nil {
-			log.Println("[play server]", err, "on", conn.RemoteAddr().String())
-			return nil, err
+		if dataSize == 0 {
+			var n int
+			if n, err = conn.Read(buffer); err != nil {
+				return
+			}
+			surplus = append(surplus, buffer[:n]...)
+			continue
 		}
-		if dataSize > 0 {
-			return protocol.Body, nil
+		surplus = surplus[dataSize:]
+		if protocol.IsPush() {
+			dispatchPush(protocol)
+			continue
 		}
+		select {
+		case conn.responses <- protocol:
+		default:
+			err = errors.New("unexpected response " + protocol.Header.TraceId)
+			return
+		}
+	}
+}
+
+func (conn *socketConn) broken() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+		return false
 	}
 }
 
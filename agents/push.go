@@ -0,0 +1,31 @@
+package agents
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/leochen2038/play/codec/protos/pproto"
+)
+
+var pushHandlers sync.Map
+
+// SetPushHandler 注册服务端推送消息的处理函数
+func SetPushHandler(action string, handler func(response pproto.PlayProtocolResponse)) {
+	pushHandlers.Store(action, handler)
+}
+
+func dispatchPush(response pproto.PlayProtocolResponse) {
+	handler, ok := pushHandlers.Load(response.Header.Action)
+	if !ok {
+		log.Println("[play agent] push handler not found:", response.Header.Action)
+		return
+	}
+
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			log.Printf("[play agent] panic on push handler %s: %v\n%s", response.Header.Action, panicInfo, debug.Stack())
+		}
+	}()
+	handler.(func(response pproto.PlayProtocolResponse))(response)
+}
@@ -72,7 +72,36 @@ func content(addr string, nextprotos []string, config *quic.Config) (quic.Connec
 		InsecureSkipVerify: true,
 		NextProtos:         nextprotos,
 	}
-	return quic.DialAddr(addr, tlsConf, config)
+	conn, err := quic.DialAddr(addr, tlsConf, config)
+	if err == nil {
+		go acceptPush(conn)
+	}
+	return conn, err
+}
+
+// acceptPush 接收服务端主动打开的单向stream, 每个stream承载一条推送消息
+func acceptPush(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go func(stream quic.ReceiveStream) {
+			defer stream.CancelRead(0)
+			var heaer = make([]byte, 8)
+			if _, err := io.ReadFull(stream, heaer); err != nil {
+				return
+			}
+			buffer := make([]byte, _bytesToUint32(heaer[4:8])+8)
+			copy(buffer, heaer)
+			if _, err := io.ReadFull(stream, buffer[8:]); err != nil {
+				return
+			}
+			if response, _, err := pproto.UnmarshalProtocolResponse(buffer); err == nil && response.IsPush() {
+				dispatchPush(response)
+			}
+		}(stream)
+	}
 }
 
 func (a *quicPProtoAgent) Request(ctx context.Context, service string, action string, body []byte) ([]byte, error) {
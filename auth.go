@@ -0,0 +1,149 @@
+package play
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnauthenticatedCode 认证失败时play.Err的错误码
+var UnauthenticatedCode = 401
+
+// Principal 认证后的调用方身份
+type Principal struct {
+	Id       string                 `json:"id"`
+	Type     string                 `json:"type"` // jwt, hmac, apikey
+	CallerId int                    `json:"caller_id,omitempty"`
+	Roles    []string               `json:"roles,omitempty"`
+	Scopes   []string               `json:"scopes,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return p != nil && containsString(p.Roles, role)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return p != nil && containsString(p.Scopes, scope)
+}
+
+// Authenticator 请求未携带该方式的凭证时返回(nil, nil)交由下一个authenticator;
+// 携带了凭证但校验失败时返回错误
+type Authenticator interface {
+	Authenticate(ctx *Context) (*Principal, error)
+}
+
+var (
+	authMutex      sync.RWMutex
+	authenticators []Authenticator
+)
+
+// SetAuthenticators 设置认证链, 在hook.OnRequest之前按顺序执行, 第一个返回身份的结果写入Context.Principal;
+// 均未携带凭证时为匿名请求, Principal为nil
+func SetAuthenticators(list ...Authenticator) {
+	authMutex.Lock()
+	defer authMutex.Unlock()
+	authenticators = list
+}
+
+// UnauthenticatedErr 认证失败的标准错误
+func UnauthenticatedErr(reason string) Err {
+	return Err{err: errors.New("unauthenticated: " + reason), tip: "unauthenticated", code: UnauthenticatedCode, time: time.Now(), attach: map[string]interface{}{}}
+}
+
+// authenticate batch子请求及异步任务沿用发起方的身份
+func authenticate(ctx *Context) error {
+	if c, ok := ctx.Session.Server.(*captureServer); ok {
+		ctx.Principal = c.principal
+		return nil
+	}
+
+	authMutex.RLock()
+	list := authenticators
+	authMutex.RUnlock()
+	for _, a := range list {
+		principal, err := a.Authenticate(ctx)
+		if err != nil {
+			if _, ok := err.(Err); !ok {
+				err = UnauthenticatedErr(err.Error())
+			}
+			return err
+		}
+		if principal != nil {
+			ctx.Principal = principal
+			if principal.CallerId != 0 {
+				ctx.ActionRequest.CallerId = principal.CallerId
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Credential 读取请求携带的凭证, 依次查找metadata(pproto、grpc, key为小写)及http header
+func (c *Context) Credential(name string) string {
+	if v := c.Metadata[strings.ToLower(name)]; v != "" {
+		return v
+	}
+	if r := c.Session.Conn.Http.Request; r != nil {
+		return r.Header.Get(name)
+	}
+	return ""
+}
+
+// ApiKey 配置的api key对应的调用方
+type ApiKey struct {
+	Name     string
+	CallerId int
+	Roles    []string
+	Scopes   []string
+}
+
+// ApiKeyAuthenticator 从X-Api-Key读取key, 认证后以配置的CallerId作为请求的CallerId
+type ApiKeyAuthenticator struct {
+	header string
+	mutex  sync.RWMutex
+	keys   map[string]ApiKey
+}
+
+func NewApiKeyAuthenticator(keys map[string]ApiKey) *ApiKeyAuthenticator {
+	a := &ApiKeyAuthenticator{header: "X-Api-Key"}
+	a.SetKeys(keys)
+	return a
+}
+
+func (a *ApiKeyAuthenticator) WithHeader(name string) *ApiKeyAuthenticator {
+	a.header = name
+	return a
+}
+
+// SetKeys 替换全部key, 可用于配置热更新
+func (a *ApiKeyAuthenticator) SetKeys(keys map[string]ApiKey) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.keys = keys
+}
+
+func (a *ApiKeyAuthenticator) Authenticate(ctx *Context) (*Principal, error) {
+	key := ctx.Credential(a.header)
+	if key == "" {
+		return nil, nil
+	}
+	a.mutex.RLock()
+	v, ok := a.keys[key]
+	a.mutex.RUnlock()
+	if !ok {
+		return nil, UnauthenticatedErr("invalid api key")
+	}
+	return &Principal{Id: v.Name, Type: "apikey", CallerId: v.CallerId, Roles: v.Roles, Scopes: v.Scopes}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package play
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/logger"
+)
+
+// JwtAuthenticator 校验Authorization: Bearer中的jwt, 支持HS/RS/ES 256/384/512;
+// 密钥从jwks文件加载, 文件修改后自动重新加载
+type JwtAuthenticator struct {
+	file      string
+	issuer    string
+	audience  string
+	leeway    time.Duration
+	mutex     sync.RWMutex
+	keys      []jwk
+	modTime   int64
+	checkTime time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+	key interface{}
+}
+
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+func NewJwtAuthenticator(jwksFile string) (*JwtAuthenticator, error) {
+	a := &JwtAuthenticator{file: jwksFile, leeway: time.Minute}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *JwtAuthenticator) WithIssuer(issuer string) *JwtAuthenticator {
+	a.issuer = issuer
+	return a
+}
+
+func (a *JwtAuthenticator) WithAudience(audience string) *JwtAuthenticator {
+	a.audience = audience
+	return a
+}
+
+// WithLeeway exp及nbf允许的时钟偏差, 默认1分钟
+func (a *JwtAuthenticator) WithLeeway(leeway time.Duration) *JwtAuthenticator {
+	a.leeway = leeway
+	return a
+}
+
+func (a *JwtAuthenticator) Reload() error {
+	data, err := ioutil.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &jwks); err != nil {
+		return errors.New("parse jwks " + a.file + " error: " + err.Error())
+	}
+	for i := range jwks.Keys {
+		if jwks.Keys[i].key, err = jwks.Keys[i].parse(); err != nil {
+			return errors.New("parse jwks " + a.file + " key " + jwks.Keys[i].Kid + " error: " + err.Error())
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.keys, a.modTime, a.checkTime = jwks.Keys, fileModTime(a.file), time.Now()
+	return nil
+}
+
+func (a *JwtAuthenticator) Authenticate(ctx *Context) (*Principal, error) {
+	auth := ctx.Credential("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return nil, nil
+	}
+	claims, err := a.Verify(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return nil, UnauthenticatedErr(err.Error())
+	}
+
+	principal := &Principal{Type: "jwt", Claims: claims}
+	principal.Id, _ = claims["sub"].(string)
+	principal.Roles = jwtStrings(claims["roles"])
+	if principal.Scopes = jwtStrings(claims["scope"]); principal.Scopes == nil {
+		principal.Scopes = jwtStrings(claims["scp"])
+	}
+	return principal, nil
+}
+
+// Verify 校验签名及exp、nbf、iss、aud, 返回claims
+func (a *JwtAuthenticator) Verify(token string) (map[string]interface{}, error) {
+	a.checkReload()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := jwtDecode(parts[0], &header); err != nil {
+		return nil, errors.New("malformed jwt header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed jwt signature")
+	}
+	if err = a.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err = jwtDecode(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed jwt claims")
+	}
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.leeway)) {
+		return nil, errors.New("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("jwt not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return nil, errors.New("jwt issuer mismatch")
+	}
+	if a.audience != "" && !containsString(jwtStrings(claims["aud"]), a.audience) {
+		return nil, errors.New("jwt audience mismatch")
+	}
+	return claims, nil
+}
+
+func (a *JwtAuthenticator) verifySignature(alg string, kid string, input []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return errors.New("unsupported jwt alg " + alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return errors.New("unsupported jwt alg " + alg)
+	}
+
+	a.mutex.RLock()
+	keys := a.keys
+	a.mutex.RUnlock()
+	for _, k := range keys {
+		if (kid != "" && k.Kid != kid) || (k.Alg != "" && k.Alg != alg) {
+			continue
+		}
+		h := hash.New()
+		h.Write(input)
+		switch key := k.key.(type) {
+		case []byte:
+			if alg[:2] == "HS" {
+				mac := hmac.New(hash.New, key)
+				mac.Write(input)
+				if hmac.Equal(mac.Sum(nil), signature) {
+					return nil
+				}
+			}
+		case *rsa.PublicKey:
+			if alg[:2] == "RS" && rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if alg[:2] == "ES" && len(signature) == 2*size {
+				r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, h.Sum(nil), r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("jwt signature invalid")
+}
+
+// checkReload 每10秒最多检查一次jwks文件是否修改
+func (a *JwtAuthenticator) checkReload() {
+	a.mutex.RLock()
+	check := time.Since(a.checkTime) > 10*time.Second
+	a.mutex.RUnlock()
+	if !check {
+		return
+	}
+
+	a.mutex.Lock()
+	a.checkTime = time.Now()
+	changed := fileModTime(a.file) != a.modTime
+	a.mutex.Unlock()
+	if changed {
+		if err := a.Reload(); err != nil {
+			logger.System("reload jwks error", "file", a.file, "error", err.Error())
+		}
+	}
+}
+
+func (k *jwk) parse() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "oct":
+		return decode(k.K)
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, errors.New("unsupported kty " + k.Kty)
+}
+
+func jwtDecode(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwtStrings claim为数组或以空格分隔的字符串
+func jwtStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var list = make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
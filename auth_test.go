@@ -0,0 +1,307 @@
+package play
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leochen2038/play/codec/binders"
+)
+
+var testHsKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestJwtVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := newTestJwtAuthenticator(t, ecKey).WithIssuer("play").WithAudience("api").WithLeeway(5 * time.Second)
+
+	now := time.Now().Unix()
+	valid := m{"sub": "u1", "iss": "play", "aud": []string{"web", "api"}, "exp": now + 60, "roles": []string{"admin"}, "scope": "read write"}
+	with := func(k string, v interface{}) m {
+		claims := m{}
+		for key, value := range valid {
+			claims[key] = value
+		}
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	var cases = []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"hs256", signHs(t, m{"alg": "HS256", "kid": "hs"}, valid, testHsKey), ""},
+		{"es256", signEs(t, m{"alg": "ES256", "kid": "ec"}, valid, ecKey), ""},
+		{"es256 without kid", signEs(t, m{"alg": "ES256"}, valid, ecKey), ""},
+		{"no exp", signHs(t, m{"alg": "HS256"}, with("exp", nil), testHsKey), ""},
+		{"expired", signHs(t, m{"alg": "HS256"}, with("exp", now-10), testHsKey), "jwt expired"},
+		{"expired within leeway", signHs(t, m{"alg": "HS256"}, with("exp", now-2), testHsKey), ""},
+		{"not valid yet", signHs(t, m{"alg": "HS256"}, with("nbf", now+60), testHsKey), "jwt not valid yet"},
+		{"issuer mismatch", signHs(t, m{"alg": "HS256"}, with("iss", "other"), testHsKey), "jwt issuer mismatch"},
+		{"audience mismatch", signHs(t, m{"alg": "HS256"}, with("aud", "web"), testHsKey), "jwt audience mismatch"},
+		{"wrong hs secret", signHs(t, m{"alg": "HS256"}, valid, []byte("wrong")), "jwt signature invalid"},
+		{"wrong ec key", signEs(t, m{"alg": "ES256"}, valid, otherKey), "jwt signature invalid"},
+		{"unknown kid", signHs(t, m{"alg": "HS256", "kid": "nope"}, valid, testHsKey), "jwt signature invalid"},
+		{"key alg mismatch", signHs(t, m{"alg": "HS384", "kid": "hs"}, valid, testHsKey), "jwt signature invalid"},
+		{"hs signed with ec public key", signHs(t, m{"alg": "HS256", "kid": "ec"}, valid, elliptic.Marshal(elliptic.P256(), ecKey.X, ecKey.Y)), "jwt signature invalid"},
+		{"alg none", jwtSegment(t, m{"alg": "none"}) + "." + jwtSegment(t, valid) + ".", "unsupported jwt alg none"},
+		{"alg unknown", signHs(t, m{"alg": "HS999"}, valid, testHsKey), "unsupported jwt alg HS999"},
+		{"tampered claims", tamper(signHs(t, m{"alg": "HS256"}, valid, testHsKey), with("sub", "admin")), "jwt signature invalid"},
+		{"two segments", "a.b", "malformed jwt"},
+		{"bad header", "!!." + jwtSegment(t, valid) + ".sig", "malformed jwt header"},
+		{"bad signature encoding", jwtSegment(t, m{"alg": "HS256"}) + "." + jwtSegment(t, valid) + ".!!", "malformed jwt signature"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims, err := a.Verify(c.token)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("Verify error: %v", err)
+				}
+				if claims["sub"] != "u1" {
+					t.Errorf("Verify claims = %v", claims)
+				}
+				return
+			}
+			if err == nil || err.Error() != c.err {
+				t.Errorf("Verify error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestJwtAuthenticate(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := newTestJwtAuthenticator(t, ecKey)
+	token := signHs(t, m{"alg": "HS256"}, m{"sub": "u1", "roles": []string{"admin"}, "scp": []string{"read"}, "exp": time.Now().Unix() + 60}, testHsKey)
+
+	var cases = []struct {
+		name          string
+		authorization string
+		want          *Principal
+		err           string
+	}{
+		{"no credential", "", nil, ""},
+		{"other scheme", "Basic dTpw", nil, ""},
+		{"bearer", "Bearer " + token, &Principal{Id: "u1", Type: "jwt", Roles: []string{"admin"}, Scopes: []string{"read"}}, ""},
+		{"lowercase bearer", "bearer " + token, &Principal{Id: "u1", Type: "jwt", Roles: []string{"admin"}, Scopes: []string{"read"}}, ""},
+		{"invalid token", "Bearer " + token + "x", nil, "unauthenticated: jwt signature invalid"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := newTestAuthContext(map[string]string{"authorization": c.authorization}, nil)
+			principal, err := a.Authenticate(ctx)
+			if c.err != "" {
+				if e, ok := err.(Err); !ok || e.Code() != UnauthenticatedCode || e.Error() != c.err {
+					t.Errorf("Authenticate error = %v, want %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate error: %v", err)
+			}
+			if principal != nil {
+				principal.Claims = nil
+			}
+			if !reflect.DeepEqual(principal, c.want) {
+				t.Errorf("Authenticate = %+v, want %+v", principal, c.want)
+			}
+		})
+	}
+}
+
+func TestNewJwtAuthenticatorError(t *testing.T) {
+	dir := t.TempDir()
+	var cases = map[string]string{
+		"bad json":        `{"keys":`,
+		"unsupported kty": `{"keys":[{"kty":"OKP","kid":"k"}]}`,
+		"unsupported crv": `{"keys":[{"kty":"EC","crv":"P-224","x":"AA","y":"AA"}]}`,
+		"bad oct key":     `{"keys":[{"kty":"oct","k":"!!"}]}`,
+	}
+	for name, jwks := range cases {
+		file := filepath.Join(dir, strings.Replace(name, " ", "_", -1)+".json")
+		if err := ioutil.WriteFile(file, []byte(jwks), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewJwtAuthenticator(file); err == nil || !strings.Contains(err.Error(), "parse jwks") {
+			t.Errorf("%s: NewJwtAuthenticator error = %v", name, err)
+		}
+	}
+	if _, err := NewJwtAuthenticator(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("NewJwtAuthenticator missing file: want error")
+	}
+}
+
+func TestHmacAuthenticate(t *testing.T) {
+	a := NewHmacAuthenticator(map[string]HmacSecret{"k1": {Secret: []byte("s1"), CallerId: 7, Roles: []string{"svc"}}}).WithMaxSkew(time.Minute)
+	body := []byte(`{"uid":1}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	future := strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10)
+	credential := func(keyId, timestamp, nonce, signature string) map[string]string {
+		return map[string]string{"x-key-id": keyId, "x-timestamp": timestamp, "x-nonce": nonce, "x-signature": signature}
+	}
+
+	var cases = []struct {
+		name       string
+		credential map[string]string
+		body       []byte
+		err        string
+	}{
+		{"valid", credential("k1", now, "n1", HmacSign([]byte("s1"), "user.info", now, "n1", body)), body, ""},
+		{"replayed nonce", credential("k1", now, "n1", HmacSign([]byte("s1"), "user.info", now, "n1", body)), body, "nonce replayed"},
+		{"nonce required", credential("k1", now, "", "sig"), body, "nonce required"},
+		{"invalid timestamp", credential("k1", "yesterday", "n2", "sig"), body, "invalid timestamp"},
+		{"stale timestamp", credential("k1", stale, "n3", HmacSign([]byte("s1"), "user.info", stale, "n3", body)), body, "timestamp out of range"},
+		{"future timestamp", credential("k1", future, "n4", HmacSign([]byte("s1"), "user.info", future, "n4", body)), body, "timestamp out of range"},
+		{"unknown key", credential("k2", now, "n5", HmacSign([]byte("s1"), "user.info", now, "n5", body)), body, "invalid key id"},
+		{"wrong secret", credential("k1", now, "n6", HmacSign([]byte("s2"), "user.info", now, "n6", body)), body, "signature mismatch"},
+		{"other action", credential("k1", now, "n7", HmacSign([]byte("s1"), "user.delete", now, "n7", body)), body, "signature mismatch"},
+		{"tampered body", credential("k1", now, "n8", HmacSign([]byte("s1"), "user.info", now, "n8", body)), []byte(`{"uid":2}`), "signature mismatch"},
+		{"rejected nonce reusable", credential("k1", now, "n8", HmacSign([]byte("s1"), "user.info", now, "n8", body)), body, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			principal, err := a.Authenticate(newTestAuthContext(c.credential, c.body))
+			if c.err != "" {
+				if err == nil || err.Error() != "unauthenticated: "+c.err {
+					t.Errorf("Authenticate error = %v, want %q", err, c.err)
+				}
+				return
+			}
+			want := &Principal{Id: "k1", Type: "hmac", CallerId: 7, Roles: []string{"svc"}}
+			if err != nil || !reflect.DeepEqual(principal, want) {
+				t.Errorf("Authenticate = %+v, %v, want %+v", principal, err, want)
+			}
+		})
+	}
+
+	if principal, err := a.Authenticate(newTestAuthContext(nil, body)); principal != nil || err != nil {
+		t.Errorf("Authenticate without credential = %+v, %v", principal, err)
+	}
+}
+
+func TestAuthenticateChain(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	SetAuthenticators(NewApiKeyAuthenticator(map[string]ApiKey{"key1": {Name: "app", CallerId: 9, Scopes: []string{"read"}}}), newTestJwtAuthenticator(t, ecKey))
+	defer SetAuthenticators()
+
+	var cases = []struct {
+		name     string
+		header   map[string]string
+		want     *Principal
+		callerId int
+		err      string
+	}{
+		{"anonymous", nil, nil, 1, ""},
+		{"api key from http header", map[string]string{"X-Api-Key": "key1"}, &Principal{Id: "app", Type: "apikey", CallerId: 9, Scopes: []string{"read"}}, 9, ""},
+		{"invalid api key", map[string]string{"X-Api-Key": "key2"}, nil, 1, "unauthenticated: invalid api key"},
+		{"api key checked before jwt", map[string]string{"X-Api-Key": "key2", "Authorization": "Bearer x"}, nil, 1, "unauthenticated: invalid api key"},
+		{"falls through to jwt", map[string]string{"Authorization": "Bearer a.b"}, nil, 1, "unauthenticated: malformed jwt"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := newTestAuthContext(nil, nil)
+			ctx.ActionRequest.CallerId = 1
+			r := httptest.NewRequest("POST", "/user.info", nil)
+			for k, v := range c.header {
+				r.Header.Set(k, v)
+			}
+			ctx.Session.Conn.Http.Request = r
+
+			err := authenticate(ctx)
+			if c.err != "" {
+				if e, ok := err.(Err); !ok || e.Code() != UnauthenticatedCode || e.Error() != c.err {
+					t.Errorf("authenticate error = %v, want %q", err, c.err)
+				}
+			} else if err != nil {
+				t.Fatalf("authenticate error: %v", err)
+			}
+			if !reflect.DeepEqual(ctx.Principal, c.want) || ctx.ActionRequest.CallerId != c.callerId {
+				t.Errorf("authenticate = %+v caller %d, want %+v caller %d", ctx.Principal, ctx.ActionRequest.CallerId, c.want, c.callerId)
+			}
+		})
+	}
+}
+
+type m = map[string]interface{}
+
+func newTestAuthContext(metadata map[string]string, body []byte) *Context {
+	ctx := &Context{Session: &Session{Conn: new(Conn)}, Metadata: metadata, Input: NewInput(binders.GetBinderOfJson(body))}
+	ctx.ActionRequest.Name = "user.info"
+	return ctx
+}
+
+// newTestJwtAuthenticator jwks包含kid为hs的HS256密钥及kid为ec的ES256公钥
+func newTestJwtAuthenticator(t *testing.T, ecKey *ecdsa.PrivateKey) *JwtAuthenticator {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks := m{"keys": []m{
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": encode(testHsKey)},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+	}}
+	data, _ := json.Marshal(jwks)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewJwtAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func jwtSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHs(t *testing.T, header, claims m, key []byte) string {
+	input := jwtSegment(t, header) + "." + jwtSegment(t, claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signEs(t *testing.T, header, claims m, key *ecdsa.PrivateKey) string {
+	input := jwtSegment(t, header) + "." + jwtSegment(t, claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// tamper 替换claims并保留原签名
+func tamper(token string, claims m) string {
+	parts := strings.Split(token, ".")
+	data, _ := json.Marshal(claims)
+	return parts[0] + "." + base64.RawURLEncoding.EncodeToString(data) + "." + parts[2]
+}
@@ -0,0 +1,289 @@
+package play
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/logger"
+)
+
+const (
+	AUTHZ_MODE_ENFORCE = "enforce"
+	AUTHZ_MODE_AUDIT   = "audit" // 只记录拒绝的请求, 不拦截
+)
+
+var (
+	// ForbiddenCode 鉴权失败时play.Err的错误码
+	ForbiddenCode = 403
+	// AuthzMode 全局鉴权模式, 可在action的metaData中以authz覆盖
+	AuthzMode   = AUTHZ_MODE_ENFORCE
+	authzMutex  sync.RWMutex
+	authzPolicy = make(map[string][]AuthzPolicy)
+)
+
+// AuthzRequirement action在metaData中声明的权限要求:
+// auth: required 需要登录; roles 具备其一; scopes 全部具备; permissions 全部由策略授予; 多个值以逗号或空格分隔
+type AuthzRequirement struct {
+	Authenticated bool
+	Roles         []string
+	Scopes        []string
+	Permissions   []string
+}
+
+func (r AuthzRequirement) Empty() bool {
+	return !r.Authenticated && len(r.Roles) == 0 && len(r.Scopes) == 0 && len(r.Permissions) == 0
+}
+
+// AuthzPolicy 授予permission的策略, roles具备其一、scopes全部具备、subjects包含principal.Id且conditions全部成立时授予
+type AuthzPolicy struct {
+	Permission string           `json:"permission"`
+	Roles      []string         `json:"roles"`
+	Scopes     []string         `json:"scopes"`
+	Subjects   []string         `json:"subjects"`
+	Conditions []AuthzCondition `json:"conditions"`
+}
+
+// AuthzCondition 对请求Input字段的条件, op为 eq、ne、in、not_in、prefix;
+// value为字符串时可引用 $principal.id、$principal.caller_id、$claims.<name>
+type AuthzCondition struct {
+	Input string      `json:"input"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+func ForbiddenErr(reason string) Err {
+	return Err{err: errors.New("forbidden: " + reason), tip: "forbidden", code: ForbiddenCode, time: time.Now(), attach: map[string]interface{}{}}
+}
+
+// LoadAuthzPolicy 从json文件加载策略 {"policies": [...]}, 替换已有策略
+func LoadAuthzPolicy(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Policies []AuthzPolicy `json:"policies"`
+	}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return errors.New("parse authz policy " + filename + " error: " + err.Error())
+	}
+	if err = SetAuthzPolicies(file.Policies); err != nil {
+		return errors.New("parse authz policy " + filename + " error: " + err.Error())
+	}
+	return nil
+}
+
+// SetAuthzPolicies 校验全部策略后替换, 任一条件不合法时保留原有策略
+func SetAuthzPolicies(policies []AuthzPolicy) error {
+	var m = make(map[string][]AuthzPolicy, len(policies))
+	for _, p := range policies {
+		for _, c := range p.Conditions {
+			if err := c.validate(); err != nil {
+				return errors.New("permission " + p.Permission + " " + err.Error())
+			}
+		}
+		m[p.Permission] = append(m[p.Permission], p)
+	}
+	authzMutex.Lock()
+	defer authzMutex.Unlock()
+	authzPolicy = m
+	return nil
+}
+
+func (act *Action) Authorization() AuthzRequirement {
+	return act.authz
+}
+
+func parseAuthzRequirement(metaData map[string]string) AuthzRequirement {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	r := AuthzRequirement{
+		Roles:       split(metaData["roles"]),
+		Scopes:      split(metaData["scopes"]),
+		Permissions: split(metaData["permissions"]),
+	}
+	r.Authenticated = metaData["auth"] == "required" || len(r.Roles) > 0 || len(r.Scopes) > 0 || len(r.Permissions) > 0
+	return r
+}
+
+// authorize 在hook.OnRequest之后、action执行之前校验权限, audit模式下只记录结果
+func authorize(act *Action, ctx *Context) error {
+	if act == nil || act.authz.Empty() {
+		return nil
+	}
+	err := checkAuthz(act.authz, ctx)
+	if err == nil {
+		return nil
+	}
+
+	mode := AuthzMode
+	if v := act.metaData["authz"]; v != "" {
+		mode = v
+	}
+	var principal string
+	if ctx.Principal != nil {
+		principal = ctx.Principal.Type + ":" + ctx.Principal.Id
+	}
+	logger.System("authz denied", "action", act.name, "mode", mode, "principal", principal, "traceId", ctx.Trace.TraceId, "reason", err.Error())
+	if mode == AUTHZ_MODE_AUDIT {
+		return nil
+	}
+	return err
+}
+
+func checkAuthz(r AuthzRequirement, ctx *Context) error {
+	p := ctx.Principal
+	if p == nil {
+		return UnauthenticatedErr("login required")
+	}
+	if len(r.Roles) > 0 {
+		var ok bool
+		for _, role := range r.Roles {
+			if ok = p.HasRole(role); ok {
+				break
+			}
+		}
+		if !ok {
+			return ForbiddenErr("requires one of roles " + strings.Join(r.Roles, ","))
+		}
+	}
+	for _, scope := range r.Scopes {
+		if !p.HasScope(scope) {
+			return ForbiddenErr("requires scope " + scope)
+		}
+	}
+	for _, permission := range r.Permissions {
+		if !grantPermission(permission, ctx) {
+			return ForbiddenErr("requires permission " + permission)
+		}
+	}
+	return nil
+}
+
+func grantPermission(permission string, ctx *Context) bool {
+	authzMutex.RLock()
+	policies := authzPolicy[permission]
+	authzMutex.RUnlock()
+
+	p := ctx.Principal
+	for _, policy := range policies {
+		if len(policy.Subjects) > 0 && !containsString(policy.Subjects, p.Id) {
+			continue
+		}
+		if len(policy.Roles) > 0 {
+			var ok bool
+			for _, role := range policy.Roles {
+				if ok = p.HasRole(role); ok {
+					break
+				}
+			}
+			if !ok {
+				continue
+			}
+		}
+		var ok = true
+		for _, scope := range policy.Scopes {
+			if ok = p.HasScope(scope); !ok {
+				break
+			}
+		}
+		for i := 0; ok && i < len(policy.Conditions); i++ {
+			ok = policy.Conditions[i].match(ctx)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate eq、ne、prefix只接受单个值, in、not_in接受单个值或非空列表
+func (c AuthzCondition) validate() error {
+	list, isList := c.Value.([]interface{})
+	switch c.Op {
+	case "eq", "", "ne", "prefix":
+		if isList || c.Value == nil {
+			return fmt.Errorf("condition %s %s requires a single value", c.Input, c.Op)
+		}
+	case "in", "not_in":
+		if (isList && len(list) == 0) || c.Value == nil {
+			return fmt.Errorf("condition %s %s requires a value", c.Input, c.Op)
+		}
+	default:
+		return fmt.Errorf("condition %s unsupported op %s", c.Input, c.Op)
+	}
+	return nil
+}
+
+func (c AuthzCondition) match(ctx *Context) bool {
+	input := ctx.Input.Value(c.Input)
+	if input == nil {
+		return false
+	}
+	actual := authzString(input)
+
+	var values []string
+	if list, ok := c.Value.([]interface{}); ok {
+		for _, v := range list {
+			values = append(values, authzValue(v, ctx))
+		}
+	} else {
+		values = []string{authzValue(c.Value, ctx)}
+	}
+	if len(values) == 0 {
+		return false
+	}
+
+	switch c.Op {
+	case "eq", "":
+		return actual == values[0]
+	case "ne":
+		return actual != values[0]
+	case "in":
+		return containsString(values, actual)
+	case "not_in":
+		return !containsString(values, actual)
+	case "prefix":
+		return strings.HasPrefix(actual, values[0])
+	}
+	return false
+}
+
+func authzValue(v interface{}, ctx *Context) string {
+	s, ok := v.(string)
+	if !ok {
+		return authzString(v)
+	}
+	switch {
+	case s == "$principal.id":
+		return ctx.Principal.Id
+	case s == "$principal.caller_id":
+		return strconv.Itoa(ctx.Principal.CallerId)
+	case strings.HasPrefix(s, "$claims."):
+		if claim, ok := ctx.Principal.Claims[s[len("$claims."):]]; ok {
+			return authzString(claim)
+		}
+		return "\x00"
+	}
+	return s
+}
+
+// authzString json数字解码为float64, 按整数形式输出以便与字符串及caller_id比较
+func authzString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
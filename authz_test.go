@@ -0,0 +1,117 @@
+package play
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAuthz(t *testing.T) {
+	defer SetAuthzPolicies(nil)
+	err := SetAuthzPolicies([]AuthzPolicy{
+		{Permission: "order.read", Roles: []string{"admin"}},
+		{Permission: "order.read", Conditions: []AuthzCondition{{Input: "uid", Op: "eq", Value: "$principal.caller_id"}}},
+		{Permission: "order.write", Subjects: []string{"u1"}, Scopes: []string{"write"}},
+		{Permission: "tenant.read", Conditions: []AuthzCondition{{Input: "tenant", Value: "$claims.tenant"}, {Input: "org.id", Op: "eq", Value: "$claims.org_id"}}},
+		{Permission: "order.big", Conditions: []AuthzCondition{{Input: "uid", Op: "in", Value: []interface{}{float64(1e8), float64(1234567)}}}},
+		{Permission: "order.prefix", Conditions: []AuthzCondition{{Input: "uid", Op: "prefix", Value: float64(123)}}},
+		{Permission: "order.other", Conditions: []AuthzCondition{{Input: "uid", Op: "not_in", Value: []interface{}{"1", "2"}}, {Input: "tenant", Op: "ne", Value: "t-2"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &Principal{Id: "u1", CallerId: 1234567, Roles: []string{"user"}, Scopes: []string{"read"},
+		Claims: map[string]interface{}{"tenant": "t-1", "org_id": float64(100000000)}}
+	var cases = []struct {
+		name      string
+		principal *Principal
+		body      string
+		require   AuthzRequirement
+		err       string
+	}{
+		{"anonymous", nil, `{}`, AuthzRequirement{Authenticated: true}, "unauthenticated: login required"},
+		{"authenticated", user, `{}`, AuthzRequirement{Authenticated: true}, ""},
+		{"role", user, `{}`, AuthzRequirement{Roles: []string{"admin", "user"}}, ""},
+		{"missing role", user, `{}`, AuthzRequirement{Roles: []string{"admin"}}, "forbidden: requires one of roles admin"},
+		{"missing scope", user, `{}`, AuthzRequirement{Scopes: []string{"read", "write"}}, "forbidden: requires scope write"},
+		{"numeric id equals caller id", user, `{"uid":1234567}`, AuthzRequirement{Permissions: []string{"order.read"}}, ""},
+		{"numeric id differs from caller id", user, `{"uid":1234568}`, AuthzRequirement{Permissions: []string{"order.read"}}, "forbidden: requires permission order.read"},
+		{"string id equals caller id", user, `{"uid":"1234567"}`, AuthzRequirement{Permissions: []string{"order.read"}}, ""},
+		{"missing input", user, `{}`, AuthzRequirement{Permissions: []string{"order.read"}}, "forbidden: requires permission order.read"},
+		{"subject without scope", user, `{}`, AuthzRequirement{Permissions: []string{"order.write"}}, "forbidden: requires permission order.write"},
+		{"claims", user, `{"tenant":"t-1","org":{"id":100000000}}`, AuthzRequirement{Permissions: []string{"tenant.read"}}, ""},
+		{"claims mismatch", user, `{"tenant":"t-1","org":{"id":100000001}}`, AuthzRequirement{Permissions: []string{"tenant.read"}}, "forbidden: requires permission tenant.read"},
+		{"missing claim", &Principal{Id: "u2"}, `{"tenant":"t-1","org":{"id":100000000}}`, AuthzRequirement{Permissions: []string{"tenant.read"}}, "forbidden: requires permission tenant.read"},
+		{"in numeric list", user, `{"uid":100000000}`, AuthzRequirement{Permissions: []string{"order.big"}}, ""},
+		{"not in numeric list", user, `{"uid":100000001}`, AuthzRequirement{Permissions: []string{"order.big"}}, "forbidden: requires permission order.big"},
+		{"numeric prefix", user, `{"uid":1234567}`, AuthzRequirement{Permissions: []string{"order.prefix"}}, ""},
+		{"not_in and ne", user, `{"uid":3,"tenant":"t-1"}`, AuthzRequirement{Permissions: []string{"order.other"}}, ""},
+		{"ne fails", user, `{"uid":3,"tenant":"t-2"}`, AuthzRequirement{Permissions: []string{"order.other"}}, "forbidden: requires permission order.other"},
+		{"unknown permission", user, `{}`, AuthzRequirement{Permissions: []string{"nope"}}, "forbidden: requires permission nope"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := newTestAuthContext(nil, []byte(c.body))
+			ctx.Principal = c.principal
+			err := checkAuthz(c.require, ctx)
+			if c.err == "" && err != nil {
+				t.Errorf("checkAuthz error: %v", err)
+			} else if c.err != "" && (err == nil || err.Error() != c.err) {
+				t.Errorf("checkAuthz error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestAuthzConditionEmptyValue(t *testing.T) {
+	ctx := newTestAuthContext(nil, []byte(`{"uid":1}`))
+	ctx.Principal = &Principal{Id: "u1"}
+	for _, op := range []string{"eq", "ne", "prefix", "in"} {
+		if (AuthzCondition{Input: "uid", Op: op, Value: []interface{}{}}).match(ctx) {
+			t.Errorf("%s with empty list matched", op)
+		}
+	}
+}
+
+func TestSetAuthzPoliciesError(t *testing.T) {
+	defer SetAuthzPolicies(nil)
+	valid := []AuthzPolicy{{Permission: "p", Conditions: []AuthzCondition{{Input: "uid", Op: "in", Value: "1"}}}}
+	if err := SetAuthzPolicies(valid); err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name      string
+		condition AuthzCondition
+		err       string
+	}{
+		{"eq empty list", AuthzCondition{Input: "uid", Op: "eq", Value: []interface{}{}}, "permission q condition uid eq requires a single value"},
+		{"default op list", AuthzCondition{Input: "uid", Value: []interface{}{"1"}}, "requires a single value"},
+		{"ne list", AuthzCondition{Input: "uid", Op: "ne", Value: []interface{}{"1", "2"}}, "requires a single value"},
+		{"prefix null", AuthzCondition{Input: "uid", Op: "prefix"}, "requires a single value"},
+		{"in empty list", AuthzCondition{Input: "uid", Op: "in", Value: []interface{}{}}, "condition uid in requires a value"},
+		{"not_in null", AuthzCondition{Input: "uid", Op: "not_in"}, "condition uid not_in requires a value"},
+		{"unknown op", AuthzCondition{Input: "uid", Op: "gt", Value: "1"}, "condition uid unsupported op gt"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := SetAuthzPolicies([]AuthzPolicy{{Permission: "q", Conditions: []AuthzCondition{c.condition}}})
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("SetAuthzPolicies error = %v, want %q", err, c.err)
+			}
+			if authzPolicy["p"] == nil || authzPolicy["q"] != nil {
+				t.Errorf("policies replaced after error: %v", authzPolicy)
+			}
+		})
+	}
+
+	file := filepath.Join(t.TempDir(), "authz.json")
+	if err := ioutil.WriteFile(file, []byte(`{"policies":[{"permission":"q","conditions":[{"input":"uid","op":"eq","value":[]}]}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadAuthzPolicy(file); err == nil || !strings.Contains(err.Error(), "requires a single value") {
+		t.Errorf("LoadAuthzPolicy error = %v", err)
+	}
+}
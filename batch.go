@@ -0,0 +1,229 @@
+package play
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/leochen2038/play/codec/binders"
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/tidwall/gjson"
+)
+
+// BatchMaxItems 单次批量请求最多包含的action数量
+var BatchMaxItems = 20
+
+// RegisterBatchAction 注册内置的批量action, 各项action并发经CallAction执行(hook、超时与单独调用一致);
+// depends中的 "输入key": "项id.输出路径" 表示该项等待依赖项完成后, 以其输出作为输入
+func RegisterBatchAction(name string, timeout time.Duration) {
+	RegisterAction(name, map[string]string{"desc": "批量调用action"}, func() interface{} {
+		return NewProcessorWrap(&batchProcessor{name: name}, func(p Processor, ctx *Context) (string, error) {
+			b := p.(*batchProcessor)
+			return RunProcessor(unsafe.Pointer(&b.Input), unsafe.Sizeof(b.Input)+unsafe.Sizeof(b.Output), p, ctx)
+		}, nil)
+	})
+	SetActionTimeout(name, timeout)
+}
+
+type BatchItemResult struct {
+	Id     string                 `json:"id"`
+	Action string                 `json:"action"`
+	Rc     int                    `json:"rc"`
+	Msg    string                 `json:"msg,omitempty"`
+	Output map[string]interface{} `json:"output,omitempty"`
+}
+
+type batchProcessor struct {
+	Input struct {
+		Items []struct {
+			Id      string      `key:"id" note:"项id, 默认为序号"`
+			Action  string      `key:"action" required:"true" note:"action名称"`
+			Input   interface{} `key:"input" note:"action输入"`
+			Depends interface{} `key:"depends" note:"依赖, 输入key: 项id.输出路径"`
+		} `key:"items" required:"true" note:"批量调用的action"`
+	}
+	Output struct {
+		Items []BatchItemResult `key:"items" note:"各项结果, 顺序与请求一致"`
+	}
+	name string
+}
+
+type batchItem struct {
+	id      string
+	action  string
+	input   map[string]interface{}
+	depends map[string]string
+	result  BatchItemResult
+	output  []byte
+	err     error
+	done    chan struct{}
+}
+
+func (p *batchProcessor) Run(ctx *Context) (string, error) {
+	if len(p.Input.Items) > BatchMaxItems {
+		return "", errors.New("input: items exceeds " + strconv.Itoa(BatchMaxItems))
+	}
+
+	var items = make([]*batchItem, len(p.Input.Items))
+	var index = make(map[string]*batchItem, len(p.Input.Items))
+	for i, v := range p.Input.Items {
+		item := &batchItem{id: v.Id, action: v.Action, done: make(chan struct{})}
+		if item.id == "" {
+			item.id = strconv.Itoa(i)
+		}
+		if _, ok := index[item.id]; ok {
+			return "", errors.New("input: items duplicate id " + item.id)
+		}
+		item.input, _ = v.Input.(map[string]interface{})
+		if depends, ok := v.Depends.(map[string]interface{}); ok {
+			item.depends = make(map[string]string, len(depends))
+			for key, path := range depends {
+				item.depends[key], _ = path.(string)
+			}
+		}
+		items[i], index[item.id] = item, item
+	}
+
+	for _, item := range items {
+		if item.action == p.name {
+			item.err = errors.New("batch action can not be nested")
+		} else if err := checkBatchDepends(item, index, map[string]bool{}); err != nil {
+			item.err = err
+		}
+	}
+
+	for _, item := range items {
+		go func(item *batchItem) {
+			defer close(item.done)
+			if item.err == nil {
+				item.err = runBatchItem(ctx, item, index)
+			}
+		}(item)
+	}
+
+	p.Output.Items = make([]BatchItemResult, len(items))
+	for i, item := range items {
+		<-item.done
+		item.result.Id, item.result.Action = item.id, item.action
+		if item.err != nil {
+			item.result.Rc, item.result.Msg = 1, item.err.Error()
+			if e, ok := item.err.(Err); ok && e.Code() != 0 {
+				item.result.Rc = e.Code()
+			}
+		}
+		p.Output.Items[i] = item.result
+	}
+	return "", nil
+}
+
+// checkBatchDepends 依赖项不存在或存在循环依赖时不执行
+func checkBatchDepends(item *batchItem, index map[string]*batchItem, visiting map[string]bool) error {
+	if visiting[item.id] {
+		return errors.New("circular depends on " + item.id)
+	}
+	visiting[item.id] = true
+	defer delete(visiting, item.id)
+	for _, path := range item.depends {
+		id := strings.SplitN(path, ".", 2)[0]
+		dep, ok := index[id]
+		if !ok {
+			return errors.New("depends item " + id + " not found")
+		}
+		if err := checkBatchDepends(dep, index, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBatchItem(ctx *Context, item *batchItem, index map[string]*batchItem) error {
+	for key, path := range item.depends {
+		parts := strings.SplitN(path, ".", 2)
+		dep := index[parts[0]]
+		select {
+		case <-dep.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if dep.err != nil {
+			return errors.New("depends item " + dep.id + " failed: " + dep.err.Error())
+		}
+		if item.input == nil {
+			item.input = make(map[string]interface{}, len(item.depends))
+		}
+		if len(parts) == 1 {
+			item.input[key] = gjson.ParseBytes(dep.output).Value()
+		} else {
+			item.input[key] = gjson.GetBytes(dep.output, parts[1]).Value()
+		}
+	}
+
+	data, err := json.Marshal(item.input)
+	if err != nil {
+		return err
+	}
+	request := &Request{
+		Version:     ctx.Response.Version,
+		RenderName:  "json",
+		CallerId:    ctx.ActionRequest.CallerId,
+		TagId:       ctx.Trace.TagId,
+		TraceId:     ctx.Trace.TraceId,
+		SpanId:      ctx.Trace.ParentSpanId,
+		ActionName:  item.action,
+		Metadata:    ctx.Metadata,
+		InputBinder: binders.GetBinderOfJson(data),
+	}
+
+	capture := &captureServer{IServer: ctx.Session.Server, parent: ctx.Session, principal: ctx.Principal}
+	sess := NewSession(ctx, capture)
+	defer sess.Close()
+	conn := *ctx.Session.Conn
+	sess.Conn, sess.User, sess.Peer, sess.RemoteAddr = &conn, ctx.Session.User, ctx.Session.Peer, ctx.Session.RemoteAddr
+	if err = CallAction(ctx, sess, request); err != nil {
+		return err
+	}
+	if capture.res == nil {
+		return errors.New("action " + item.action + " no response")
+	}
+	if capture.res.Error != nil {
+		return capture.res.Error
+	}
+	item.result.Output = capture.res.Output.All()
+	item.output, err = json.Marshal(item.result.Output)
+	return err
+}
+
+// captureServer 代替原server收集action的响应, 其余行为(hook、Ctrl等)与原server一致;
+// 推送消息发往parent, 无parent时丢弃
+type captureServer struct {
+	IServer
+	parent    *Session
+	jobId     string     // 异步任务执行时为任务id, 此时不再入队
+	principal *Principal // 沿用发起方的认证身份
+	res       *Response
+}
+
+func (c *captureServer) Packer() IPacker {
+	return c
+}
+
+func (c *captureServer) Transport(*Conn, []byte) error {
+	return nil
+}
+
+func (c *captureServer) Receive(*Conn) (*Request, error) {
+	return nil, errors.New("capture server can not receive")
+}
+
+func (c *captureServer) Pack(conn *Conn, res *Response) ([]byte, error) {
+	if res.Action != "" {
+		if c.parent == nil {
+			return nil, nil
+		}
+		return nil, c.parent.Write(res)
+	}
+	c.res = res
+	return nil, nil
+}
@@ -0,0 +1,168 @@
+package play
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerIdentity mTLS连接中客户端证书的身份信息
+type PeerIdentity struct {
+	CommonName   string
+	Organization []string
+	DNSNames     []string
+	URIs         []string
+	SerialNumber string
+	Certificate  *x509.Certificate
+}
+
+// NewPeerIdentity 根据tls连接状态生成客户端身份, 未提供客户端证书时返回nil
+func NewPeerIdentity(state *tls.ConnectionState) *PeerIdentity {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	peer := &PeerIdentity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		SerialNumber: cert.SerialNumber.String(),
+		Certificate:  cert,
+	}
+	for _, uri := range cert.URIs {
+		peer.URIs = append(peer.URIs, uri.String())
+	}
+	return peer
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  int64
+	cert     *tls.Certificate
+}
+
+// CertStore 管理多张证书, 按SNI选择证书, 并定时从文件热加载
+type CertStore struct {
+	mu    sync.RWMutex
+	files []*certEntry
+}
+
+func NewCertStore() *CertStore {
+	return &CertStore{}
+}
+
+// AddFile 从文件加载证书, refresh大于0时按间隔检查文件变化并重新加载
+func (s *CertStore) AddFile(certFile, keyFile string, refresh time.Duration) error {
+	f := &certEntry{certFile: certFile, keyFile: keyFile}
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.files = append(s.files, f)
+	s.mu.Unlock()
+
+	if refresh > 0 {
+		s.startWatchFile(f, refresh)
+	}
+	return nil
+}
+
+func (s *CertStore) AddCertificate(cert tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = append(s.files, &certEntry{cert: parseLeaf(&cert)})
+}
+
+// GetCertificate 用于tls.Config.GetCertificate, 按ClientHello中的SNI选择证书, 无匹配时使用第一张
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.files) == 0 {
+		return nil, errors.New("cert store is empty")
+	}
+	if hello != nil && hello.ServerName != "" {
+		name := strings.ToLower(hello.ServerName)
+		for _, f := range s.files {
+			if f.cert.Leaf != nil && f.cert.Leaf.VerifyHostname(name) == nil {
+				return f.cert, nil
+			}
+		}
+	}
+	return s.files[0].cert, nil
+}
+
+// GetClientCertificate 用于tls.Config.GetClientCertificate, 客户端mTLS时返回第一张证书
+func (s *CertStore) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.files) == 0 {
+		return &tls.Certificate{}, nil
+	}
+	return s.files[0].cert, nil
+}
+
+func (s *CertStore) startWatchFile(f *certEntry, refresh time.Duration) {
+	go func() {
+		defer func() {
+			if panicInfo := recover(); panicInfo != nil {
+				fmt.Println("watch certificate file panic:", panicInfo)
+			}
+			time.Sleep(5 * time.Second)
+			s.startWatchFile(f, refresh)
+		}()
+
+		var ticker = time.NewTicker(refresh)
+		for range ticker.C {
+			if !f.changed() {
+				continue
+			}
+			nf := &certEntry{certFile: f.certFile, keyFile: f.keyFile}
+			if err := nf.load(); err != nil {
+				fmt.Println("reload certificate error:", err)
+				continue
+			}
+			s.mu.Lock()
+			f.cert, f.modTime = nf.cert, nf.modTime
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (f *certEntry) changed() bool {
+	return fileModTime(f.certFile) > f.modTime || fileModTime(f.keyFile) > f.modTime
+}
+
+func (f *certEntry) load() error {
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return err
+	}
+	f.cert = parseLeaf(&cert)
+	if f.modTime = fileModTime(f.certFile); fileModTime(f.keyFile) > f.modTime {
+		f.modTime = fileModTime(f.keyFile)
+	}
+	return nil
+}
+
+func parseLeaf(cert *tls.Certificate) *tls.Certificate {
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	return cert
+}
+
+func fileModTime(filename string) int64 {
+	if info, err := os.Stat(filename); err == nil {
+		return info.ModTime().UnixNano()
+	}
+	return 0
+}
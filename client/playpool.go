@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"sync"
@@ -13,6 +14,19 @@ var (
 
 var mu sync.RWMutex
 var list = make(map[string]*SocketPool, 64)
+var tlsConfigs = make(map[string]*tls.Config)
+
+// SetTlsConfig 使用tls连接address, 已建立的连接池将被关闭重建
+func SetTlsConfig(address string, config *tls.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	tlsConfigs[address] = config
+	if pool, ok := list[address]; ok {
+		delete(list, address)
+		_ = pool.Close()
+	}
+}
 
 func GetSocketPoolBy(address string) (pool *SocketPool) {
 	var ok bool
@@ -21,7 +35,11 @@ func GetSocketPoolBy(address string) (pool *SocketPool) {
 	defer mu.Unlock()
 
 	if pool, ok = list[address]; !ok {
+		var config = tlsConfigs[address]
 		pool = newPlaySocketPool(64, func() (net.Conn, error) {
+			if config != nil {
+				return tls.DialWithDialer(&net.Dialer{Timeout: 500 * time.Millisecond}, "tcp", address, config)
+			}
 			return net.DialTimeout("tcp", address, 500*time.Millisecond)
 		})
 		list[address] = pool
@@ -44,18 +62,26 @@ func (pool *SocketPool) GetConn() (*PlayConn, error) {
 		return nil, ErrClosed
 	}
 
-	select {
-	case conn := <-pool.connChans:
-		if conn == nil {
-			return nil, ErrClosed
-		}
-		return conn, nil
-	default:
-		nconn, err := pool.factory()
-		if err != nil {
-			return nil, err
+	for {
+		select {
+		case conn := <-pool.connChans:
+			if conn == nil {
+				return nil, ErrClosed
+			}
+			// 空闲期间被服务端关闭的连接直接丢弃
+			if conn.broken() {
+				conn.Unsable = true
+				_ = conn.Close()
+				continue
+			}
+			return conn, nil
+		default:
+			nconn, err := pool.factory()
+			if err != nil {
+				return nil, err
+			}
+			return newPlayConn(nconn, pool), nil
 		}
-		return &PlayConn{Conn: nconn, pool: pool}, nil
 	}
 }
 
@@ -90,8 +116,90 @@ func (pool *SocketPool) Close() error {
 
 type PlayConn struct {
 	net.Conn
-	pool    *SocketPool
-	Unsable bool
+	pool      *SocketPool
+	Unsable   bool
+	responses chan *PlayProtocol
+	done      chan struct{}
+	err       error
+}
+
+func newPlayConn(nconn net.Conn, pool *SocketPool) *PlayConn {
+	conn := &PlayConn{Conn: nconn, pool: pool, responses: make(chan *PlayProtocol, 1), done: make(chan struct{})}
+	go conn.readLoop()
+	return conn
+}
+
+// readLoop 每个连接一个读取goroutine, 推送消息按顺序分发给处理函数, 响应交给等待中的请求;
+// 连接在池中空闲时同样能收到推送
+func (conn *PlayConn) readLoop() {
+	var buffer = make([]byte, 4096)
+	var surplus []byte
+	var protocol *PlayProtocol
+	var err error
+
+	defer func() {
+		conn.err = err
+		close(conn.done)
+		_ = conn.Conn.Close()
+	}()
+	for {
+		if protocol, surplus, err = parseResponseProtocol(surplus); err != nil {
+			return
+		}
+		if protocol == nil {
+			var n int
+			if n, err = conn.Conn.Read(buffer); err != nil {
+				return
+			}
+			surplus = append(surplus, buffer[:n]...)
+			continue
+		}
+		if protocol.Action != "" {
+			dispatchPush(protocol)
+			continue
+		}
+		select {
+		case conn.responses <- protocol:
+		default:
+			// 上一个响应未被读取(请求已超时), 连接上的响应无法再对应
+			err = errors.New("unexpected response " + protocol.TraceId)
+			return
+		}
+	}
+}
+
+// readResponse 等待当前请求的响应, 超时后响应无法再对应请求, 连接不可复用
+func (conn *PlayConn) readResponse(timeout time.Duration) (*PlayProtocol, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case protocol := <-conn.responses:
+		return protocol, nil
+	case <-conn.done:
+		select {
+		case protocol := <-conn.responses:
+			return protocol, nil
+		default:
+		}
+		conn.Unsable = true
+		return nil, conn.err
+	case <-expired:
+		conn.Unsable = true
+		return nil, errors.New("read response timeout after " + timeout.String())
+	}
+}
+
+func (conn *PlayConn) broken() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+		return false
+	}
 }
 
 func (conn *PlayConn) Close() error {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"unsafe"
+
+	"github.com/leochen2038/play/codec/protos/pproto"
 )
 
 // request  protocol
@@ -40,8 +42,10 @@ type PlayProtocol struct {
 	SpanId   []byte
 	Conn     net.Conn
 	Respond  byte
-	Action   string
+	Action   string // 服务端推送消息的action, 普通响应为空
 	Message  []byte
+	Attach   map[string][]byte
+	Metadata map[string]string
 }
 
 func (p *PlayProtocol) ResponseByMessage(message []byte, rc int) error {
@@ -82,7 +86,19 @@ func (p *PlayProtocol) ResponseByMessage(message []byte, rc int) error {
 	}
 }
 
-func buildRequestBytes(version byte, tagId int, traceId string, spanId []byte, callerId int, action string, message []byte, respond bool) (buffer []byte, protocolSize int) {
+func buildRequestBytes(version byte, tagId int, traceId string, spanId []byte, callerId int, action string, message []byte, respond bool) (buffer []byte, protocolSize int, err error) {
+	if version >= 4 {
+		request := pproto.PlayProtocolRequest{Version: version, Action: action, NonRespond: !respond, Body: message}
+		request.Header.TagId = tagId
+		request.Header.TraceId = traceId
+		request.Header.SpanId = spanId
+		request.Header.CallerId = callerId
+		request.Header.AcceptCompress = pproto.AcceptCompress()
+		if buffer, err = pproto.MarshalProtocolRequest(request); err != nil {
+			return nil, 0, err
+		}
+		return buffer, len(buffer), nil
+	}
 	if version == 3 {
 		var actionLen = byte(len(action))
 		var responByte byte = 0
@@ -155,15 +171,27 @@ func parseResponseProtocol(buffer []byte) (*PlayProtocol, []byte, error) {
 	}
 
 	// 检查协议标本号
-	if buffer[8] != 3 && buffer[8] != 2 {
-		err := fmt.Errorf("[play server] error play socket protocol version must be 2 or 3")
+	if buffer[8] < 2 || buffer[8] > 5 {
+		err := fmt.Errorf("[play server] error play socket protocol version must be 2 to 5")
 		return nil, nil, err
 	}
 
 	protocol := &PlayProtocol{}
 	protocol.Version = buffer[8]
 
-	if protocol.Version == 3 {
+	if protocol.Version >= 4 {
+		response, _, err := pproto.UnmarshalProtocolResponse(buffer[:dataSize])
+		if err != nil {
+			return nil, nil, err
+		}
+		protocol.TagId = response.Header.TagId
+		protocol.TraceId = response.Header.TraceId
+		protocol.Rc = response.ResultCode
+		protocol.Message = response.Body
+		protocol.Attach = response.Attachment
+		protocol.Metadata = response.Header.Metadata
+		protocol.Action = response.Header.Action
+	} else if protocol.Version == 3 {
 		protocol.TagId = bytes2Int(buffer[9:13])
 		protocol.TraceId = string(buffer[13:45])
 		protocol.Rc = bytes2Int(buffer[45:49])
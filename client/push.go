@@ -0,0 +1,29 @@
+package client
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+var pushHandlers sync.Map
+
+// SetPushHandler 注册服务端推送消息的处理函数, 推送消息由连接的读取goroutine按顺序分发, 处理函数不应长时间阻塞
+func SetPushHandler(action string, handler func(protocol *PlayProtocol)) {
+	pushHandlers.Store(action, handler)
+}
+
+func dispatchPush(protocol *PlayProtocol) {
+	handler, ok := pushHandlers.Load(protocol.Action)
+	if !ok {
+		log.Println("[play client] push handler not found:", protocol.Action)
+		return
+	}
+
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			log.Printf("[play client] panic on push handler %s: %v\n%s", protocol.Action, panicInfo, debug.Stack())
+		}
+	}()
+	handler.(func(protocol *PlayProtocol))(protocol)
+}
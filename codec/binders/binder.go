@@ -21,6 +21,11 @@ type Binder interface {
 	Get(key string) interface{}
 }
 
+// RawBinder 可取得原始输入的binder, 用于请求签名校验
+type RawBinder interface {
+	Raw() []byte
+}
+
 func parseSliceKey(k string, c string) (string, error) {
 	var kl, cl = len(k), len(c)
 	if kl <= cl {
@@ -20,6 +20,10 @@ func (b *bytesBinder) Get(key string) interface{} {
 	return b.data
 }
 
+func (b *bytesBinder) Raw() []byte {
+	return b.data
+}
+
 func (b *bytesBinder) Bind(v reflect.Value, s reflect.StructField) error {
 	bind := s.Tag.Get("required")
 	if (v.Type().String() == "[]int8" || v.Type().String() == "[]byte") && len(b.data) > 0 {
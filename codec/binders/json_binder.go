@@ -11,11 +11,16 @@ import (
 )
 
 type jsonBinder struct {
+	raw  []byte
 	root gjson.Result
 }
 
 func GetBinderOfJson(data []byte) Binder {
-	return &jsonBinder{root: gjson.GetBytes(data, "@this")}
+	return &jsonBinder{raw: data, root: gjson.GetBytes(data, "@this")}
+}
+
+func (b *jsonBinder) Raw() []byte {
+	return b.raw
 }
 
 func (b *jsonBinder) Name() string {
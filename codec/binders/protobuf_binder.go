@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"google.golang.org/protobuf/reflect/protoreflect"
-	"google.golang.org/protobuf/types/dynamicpb"
 )
 
 type ProtobufBinder struct {
@@ -70,7 +69,7 @@ func (b ProtobufBinder) bindProtobuf(v reflect.Value, s reflect.StructField, sou
 		if s.Type.String() == "time.Time" {
 			return setProtobufVal(v, s, source.Get(item).String(), nil)
 		} else {
-			return b.bindStruct(v, dynamicpb.NewMessage(item.Message()), fullKey)
+			return b.bindStruct(v, source.Get(item).Message(), fullKey)
 		}
 	case reflect.Slice:
 		if s.Type.String() == "[]uint8" {
@@ -38,6 +38,11 @@ func (b urlValueBinder) Get(key string) interface{} {
 	return b.values.Get(key)
 }
 
+// Raw 按key排序编码的参数, 不包含上传的文件
+func (b urlValueBinder) Raw() []byte {
+	return []byte(b.values.Encode())
+}
+
 func (b urlValueBinder) Bind(v reflect.Value, s reflect.StructField) error {
 	return b.bindValue(v, s, "")
 }
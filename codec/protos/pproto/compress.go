@@ -0,0 +1,117 @@
+package pproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	COMPRESS_NONE   byte = 0
+	COMPRESS_GZIP   byte = 1
+	COMPRESS_ZSTD   byte = 2
+	COMPRESS_SNAPPY byte = 3
+)
+
+var (
+	// CompressThreshold body小于该长度时不压缩
+	CompressThreshold = 1024
+	// MaxDecompressSize 解压后body的最大长度
+	MaxDecompressSize int64 = 64 << 20
+
+	compressNames = map[byte]string{COMPRESS_GZIP: "gzip", COMPRESS_ZSTD: "zstd", COMPRESS_SNAPPY: "snappy"}
+	zstdEncoder   *zstd.Encoder
+	zstdDecoder   *zstd.Decoder
+)
+
+func init() {
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxDecompressSize)))
+}
+
+func CompressName(c byte) string {
+	return compressNames[c]
+}
+
+// NegotiateCompress 从对端声明可接受的压缩算法列表(逗号分隔, 按优先级)中选择第一个支持的算法
+func NegotiateCompress(accept string) byte {
+	for _, name := range strings.Split(accept, ",") {
+		name = strings.TrimSpace(name)
+		for c, v := range compressNames {
+			if v == name {
+				return c
+			}
+		}
+	}
+	return COMPRESS_NONE
+}
+
+// AcceptCompress 本端可解压的算法列表, 用于请求头中声明
+func AcceptCompress() string {
+	return "zstd,snappy,gzip"
+}
+
+func Compress(c byte, data []byte) ([]byte, error) {
+	switch c {
+	case COMPRESS_NONE:
+		return data, nil
+	case COMPRESS_GZIP:
+		var buffer bytes.Buffer
+		w := gzip.NewWriter(&buffer)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	case COMPRESS_ZSTD:
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case COMPRESS_SNAPPY:
+		return snappy.Encode(nil, data), nil
+	default:
+		return nil, errors.New("unsupported compress type")
+	}
+}
+
+func Decompress(c byte, data []byte) ([]byte, error) {
+	switch c {
+	case COMPRESS_NONE:
+		return data, nil
+	case COMPRESS_GZIP:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimit(r)
+	case COMPRESS_ZSTD:
+		return zstdDecoder.DecodeAll(data, nil)
+	case COMPRESS_SNAPPY:
+		if n, err := snappy.DecodedLen(data); err != nil {
+			return nil, err
+		} else if int64(n) > MaxDecompressSize {
+			return nil, errors.New("decompress body too large")
+		}
+		return snappy.Decode(nil, data)
+	default:
+		return nil, errors.New("unsupported compress type")
+	}
+}
+
+func readLimit(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxDecompressSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxDecompressSize {
+		return nil, errors.New("decompress body too large")
+	}
+	return data, nil
+}
@@ -3,6 +3,7 @@ package pproto
 import (
 	"context"
 	"errors"
+	"hash/crc32"
 	"math"
 	"time"
 	"unsafe"
@@ -57,23 +58,62 @@ import (
 // 4 byte : attachment长度
 // header body attachment
 
+// request protocol v5
+// 4 byte : ==>>
+// 4 byte : dataSize
+// 1 byte : version
+// 1 byte : action长度
+// 1 byte : respond 0:需要响应, 1:不需要响应
+// 1 byte : render 0:json
+// 1 byte : compress 0:不压缩 1:gzip 2:zstd 3:snappy
+// 4 byte : header长度
+// 4 byte : body长度(压缩后)
+// 4 byte : attachment长度
+// 4 byte : crc32(action header body attachment)
+// action header body attachment
+
+// response protocol v5
+// 4 byte : <<==
+// 4 byte : dataSize
+// 1 byte : version
+// 1 byte : render 0:json
+// 1 byte : compress 0:不压缩 1:gzip 2:zstd 3:snappy
+// 4 byte : rc错误码
+// 4 byte : header长度
+// 4 byte : body长度(压缩后)
+// 4 byte : attachment长度
+// 4 byte : crc32(header body attachment)
+// header body attachment
+
+// DefaultVersion NewPlayProtocolRequest 默认使用的协议版本, 对端全部支持v5后可改为5
+var DefaultVersion byte = 4
+
 type requestHeader struct {
 	TraceId  string    `key:"traceId" json:"traceId"`
 	SpanId   []byte    `key:"spanId" json:"spanId"`
 	CallerId int       `key:"callerId" json:"callerId"`
 	TagId    int       `key:"tagId" json:"tagId"`
 	Deadline time.Time `key:"deadline" json:"deadline"`
+	// v5: 可接受的响应压缩算法, 逗号分隔
+	AcceptCompress string            `key:"acceptCompress" json:"acceptCompress,omitempty"`
+	Metadata       map[string]string `key:"metadata" json:"metadata,omitempty"`
+	// v4: 幂等key, 重试时保持不变
+	IdempotencyKey string `key:"idempotencyKey" json:"idempotencyKey,omitempty"`
 }
 
 type responseHeader struct {
-	TraceId string `key:"traceId" json:"traceId"`
-	TagId   int    `key:"tagId" json:"tagId"`
+	TraceId  string            `key:"traceId" json:"traceId"`
+	TagId    int               `key:"tagId" json:"tagId"`
+	Metadata map[string]string `key:"metadata" json:"metadata,omitempty"`
+	// 服务端推送消息的action, 普通响应为空
+	Action string `key:"action" json:"action,omitempty"`
 }
 type PlayProtocolRequest struct {
 	Version    byte
 	Action     string
 	NonRespond bool
 	Render     byte
+	Compress   byte
 	Header     requestHeader
 	Body       []byte
 	Attachment map[string][]byte
@@ -82,6 +122,7 @@ type PlayProtocolRequest struct {
 type PlayProtocolResponse struct {
 	Version    byte
 	Render     byte
+	Compress   byte
 	ResultCode int
 	Header     responseHeader
 	Body       []byte
@@ -94,11 +135,15 @@ func NewPlayProtocolRequest(ctx context.Context, callerId int, action string, me
 		return
 	}
 
+	request.Version = DefaultVersion
 	request.Header.CallerId = callerId
-	if c, ok := ctx.(*play.Context); ok {
+	request.Header.AcceptCompress = AcceptCompress()
+	request.Header.IdempotencyKey = play.IdempotencyKeyOf(ctx)
+	if c, ok := play.ContextOf(ctx); ok {
 		c.Trace.SpanId++
 		request.Header.TraceId = c.Trace.TraceId
 		request.Header.SpanId = append(c.Trace.ParentSpanId, c.Trace.SpanId)
+		request.Header.Metadata = c.Metadata
 	} else {
 		request.Header.TraceId = play.NewTraceId()
 		request.Header.SpanId = []byte{1}
@@ -117,6 +162,8 @@ func MarshalProtocolRequest(request PlayProtocolRequest) ([]byte, error) {
 		buffer, err = _packRequestV2(request, buffer)
 	case 3:
 		buffer, err = _packRequestV3(request, buffer)
+	case 5:
+		buffer, err = _packRequestV5(request, buffer)
 	default:
 		buffer, err = _packRequestV4(request, buffer)
 	}
@@ -244,6 +291,51 @@ func _packRequestV4(request PlayProtocolRequest, buffer []byte) ([]byte, error)
 	return buffer, nil
 }
 
+func _packRequestV5(request PlayProtocolRequest, buffer []byte) ([]byte, error) {
+	if len(request.Action) > 255 {
+		return nil, errors.New("action length error must less than 255")
+	}
+
+	header, err := json.Marshal(request.Header)
+	if err != nil {
+		return nil, err
+	}
+	body, compress, err := _compressBody(request.Compress, request.Body)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := _packAttachment(request.Attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	// dataSize的值不包括==>>4个字节 和 dataSize 4个字节
+	var dataSize = 21 + len(request.Action) + len(header) + len(body) + len(attachment)
+	if dataSize > math.MaxInt32-8 {
+		return nil, errors.New("dataSize length error must less than 2147483647")
+	}
+
+	payload := make([]byte, 0, dataSize-21)
+	payload = append(payload, []byte(request.Action)...)
+	payload = append(payload, header...)
+	payload = append(payload, body...)
+	payload = append(payload, attachment...)
+
+	buffer = append(buffer, _intToBytes(dataSize)...)
+	buffer = append(buffer, byte(5))
+	buffer = append(buffer, uint8(len(request.Action)))
+	buffer = append(buffer, boolTobyte(request.NonRespond))
+	buffer = append(buffer, request.Render)
+	buffer = append(buffer, compress)
+	buffer = append(buffer, _intToBytes(len(header))...)
+	buffer = append(buffer, _intToBytes(len(body))...)
+	buffer = append(buffer, _intToBytes(len(attachment))...)
+	buffer = append(buffer, _intToBytes(int(crc32.ChecksumIEEE(payload)))...)
+	buffer = append(buffer, payload...)
+
+	return buffer, nil
+}
+
 // UnpackRequest 解包request请求
 func UnmarshalProtocolRequest(data []byte) (protocol PlayProtocolRequest, dataSize uint32, err error) {
 	if len(data) < 9 {
@@ -268,6 +360,8 @@ func UnmarshalProtocolRequest(data []byte) (protocol PlayProtocolRequest, dataSi
 		err = _unpackRequestV3(data, dataSize, &protocol)
 	case 4:
 		err = _unpackRequestV4(data, dataSize, &protocol)
+	case 5:
+		err = _unpackRequestV5(data, dataSize, &protocol)
 	default:
 		err = errors.New("socket protocol version error")
 	}
@@ -362,6 +456,48 @@ func _unpackRequestV4(buffer []byte, dataSize uint32, protocol *PlayProtocolRequ
 	return
 }
 
+func _unpackRequestV5(buffer []byte, dataSize uint32, protocol *PlayProtocolRequest) (err error) {
+	if dataSize < 29 {
+		return errors.New("socket protocol format error")
+	}
+	actionLength := uint32(_bytesToUint8(buffer[9:10]))
+	protocol.NonRespond = buffer[10] > 0
+	protocol.Render = buffer[11]
+	protocol.Compress = buffer[12]
+	headerLen := _bytesToUint32(buffer[13:17])
+	bodyLen := _bytesToUint32(buffer[17:21])
+	attachmentLen := _bytesToUint32(buffer[21:25])
+	checksum := _bytesToUint32(buffer[25:29])
+
+	if uint64(29)+uint64(actionLength)+uint64(headerLen)+uint64(bodyLen)+uint64(attachmentLen) != uint64(dataSize) {
+		return errors.New("socket protocol format error")
+	}
+	if crc32.ChecksumIEEE(buffer[29:dataSize]) != checksum {
+		return errors.New("socket protocol checksum error")
+	}
+
+	var idx uint32 = 29
+	protocol.Action = string(buffer[idx : idx+actionLength])
+	idx += actionLength
+	if headerLen > 0 {
+		if err = json.Unmarshal(buffer[idx:idx+headerLen], &protocol.Header); err != nil {
+			return
+		}
+		idx += headerLen
+	}
+	if protocol.Body, err = Decompress(protocol.Compress, buffer[idx:idx+bodyLen]); err != nil {
+		return
+	}
+	idx += bodyLen
+	protocol.Attachment, err = _unpackAttachment(buffer[idx : idx+attachmentLen])
+	return
+}
+
+// IsPush 是否为服务端主动推送的消息
+func (response PlayProtocolResponse) IsPush() bool {
+	return response.Header.Action != ""
+}
+
 func MarshalProtocolResponse(response PlayProtocolResponse) ([]byte, error) {
 	buffer := []byte("<<==")
 
@@ -371,6 +507,8 @@ func MarshalProtocolResponse(response PlayProtocolResponse) ([]byte, error) {
 		return _packResponseV2(response, buffer)
 	case 3:
 		return _packResponseV3(response, buffer)
+	case 5:
+		return _packResponseV5(response, buffer)
 	default:
 		return _packResponseV4(response, buffer)
 	}
@@ -450,6 +588,45 @@ func _packResponseV4(response PlayProtocolResponse, buffer []byte) ([]byte, erro
 	return buffer, nil
 }
 
+func _packResponseV5(response PlayProtocolResponse, buffer []byte) ([]byte, error) {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return nil, err
+	}
+	body, compress, err := _compressBody(response.Compress, response.Body)
+	if err != nil {
+		return nil, err
+	}
+	attachment, err := _packAttachment(response.Attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	// dataSize的值不包括<<==4个字节 和 dataSize 4个字节
+	var dataSize = 23 + len(header) + len(body) + len(attachment)
+	if dataSize > math.MaxInt32-8 {
+		return nil, errors.New("dataSize length error must less than 2147483647")
+	}
+
+	payload := make([]byte, 0, dataSize-23)
+	payload = append(payload, header...)
+	payload = append(payload, body...)
+	payload = append(payload, attachment...)
+
+	buffer = append(buffer, _intToBytes(dataSize)...)
+	buffer = append(buffer, byte(5))
+	buffer = append(buffer, response.Render)
+	buffer = append(buffer, compress)
+	buffer = append(buffer, _intToBytes(response.ResultCode)...)
+	buffer = append(buffer, _intToBytes(len(header))...)
+	buffer = append(buffer, _intToBytes(len(body))...)
+	buffer = append(buffer, _intToBytes(len(attachment))...)
+	buffer = append(buffer, _intToBytes(int(crc32.ChecksumIEEE(payload)))...)
+	buffer = append(buffer, payload...)
+
+	return buffer, nil
+}
+
 func UnmarshalProtocolResponse(data []byte) (protocol PlayProtocolResponse, dataSize uint32, err error) {
 	if len(data) < 9 {
 		return
@@ -471,6 +648,8 @@ func UnmarshalProtocolResponse(data []byte) (protocol PlayProtocolResponse, data
 		err = _unpackResponseV3(data, dataSize, &protocol)
 	case 4:
 		err = _unpackResponseV4(data, dataSize, &protocol)
+	case 5:
+		err = _unpackResponseV5(data, dataSize, &protocol)
 	default:
 		err = errors.New("socket protocol version error")
 	}
@@ -530,6 +709,101 @@ func _unpackResponseV4(buffer []byte, dataSize uint32, protocol *PlayProtocolRes
 
 	return
 }
+func _unpackResponseV5(buffer []byte, dataSize uint32, protocol *PlayProtocolResponse) (err error) {
+	if dataSize < 31 {
+		return errors.New("socket protocol format error")
+	}
+	protocol.Render = buffer[9]
+	protocol.Compress = buffer[10]
+	protocol.ResultCode = _bytesToInt(buffer[11:15])
+	headerLen := _bytesToUint32(buffer[15:19])
+	bodyLen := _bytesToUint32(buffer[19:23])
+	attachmentLen := _bytesToUint32(buffer[23:27])
+	checksum := _bytesToUint32(buffer[27:31])
+
+	if uint64(31)+uint64(headerLen)+uint64(bodyLen)+uint64(attachmentLen) != uint64(dataSize) {
+		return errors.New("socket protocol format error")
+	}
+	if crc32.ChecksumIEEE(buffer[31:dataSize]) != checksum {
+		return errors.New("socket protocol checksum error")
+	}
+
+	var idx uint32 = 31
+	if headerLen > 0 {
+		if err = json.Unmarshal(buffer[idx:idx+headerLen], &protocol.Header); err != nil {
+			return
+		}
+		idx += headerLen
+	}
+	if protocol.Body, err = Decompress(protocol.Compress, buffer[idx:idx+bodyLen]); err != nil {
+		return
+	}
+	idx += bodyLen
+	protocol.Attachment, err = _unpackAttachment(buffer[idx : idx+attachmentLen])
+	return
+}
+
+// _compressBody body达到CompressThreshold时才压缩, 返回实际使用的压缩算法
+func _compressBody(compress byte, body []byte) ([]byte, byte, error) {
+	if compress == COMPRESS_NONE || len(body) < CompressThreshold {
+		return body, COMPRESS_NONE, nil
+	}
+	data, err := Compress(compress, body)
+	return data, compress, err
+}
+
+func _packAttachment(attachment map[string][]byte) ([]byte, error) {
+	if len(attachment) > 255 {
+		return nil, errors.New("attachment count error must less than 255")
+	}
+
+	var buffer = []byte{byte(len(attachment))}
+	for k, v := range attachment {
+		if len(k) > 255 {
+			return nil, errors.New("attachment key length error must less than 255")
+		}
+		if len(buffer) > math.MaxInt32-len(k)-len(v)-5 {
+			return nil, errors.New("attachment length error must less than 2147483647")
+		}
+		buffer = append(buffer, byte(len(k)))
+		buffer = append(buffer, []byte(k)...)
+		buffer = append(buffer, _intToBytes(len(v))...)
+		buffer = append(buffer, v...)
+	}
+	return buffer, nil
+}
+
+func _unpackAttachment(buffer []byte) (map[string][]byte, error) {
+	if len(buffer) == 0 || buffer[0] == 0 {
+		return nil, nil
+	}
+
+	var size = uint32(len(buffer))
+	var count = buffer[0]
+	var idx uint32 = 1
+	var attachment = make(map[string][]byte, count)
+	for i := uint8(0); i < count; i++ {
+		if idx+1 > size {
+			return nil, errors.New("socket protocol attachment error")
+		}
+		keyLen := uint32(buffer[idx])
+		idx++
+		if idx+keyLen+4 > size {
+			return nil, errors.New("socket protocol attachment error")
+		}
+		key := string(buffer[idx : idx+keyLen])
+		idx += keyLen
+		valueLen := _bytesToUint32(buffer[idx : idx+4])
+		idx += 4
+		if uint64(idx)+uint64(valueLen) > uint64(size) {
+			return nil, errors.New("socket protocol attachment error")
+		}
+		attachment[key] = buffer[idx : idx+valueLen]
+		idx += valueLen
+	}
+	return attachment, nil
+}
+
 func _bytesToUint32(data []byte) uint32 {
 	var ret uint32
 	var l = len(data)
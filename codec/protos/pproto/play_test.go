@@ -0,0 +1,315 @@
+package pproto
+
+import (
+	"bytes"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTraceId = "0123456789abcdef0123456789abcdef"
+
+var largeBody = []byte(`{"items":"` + strings.Repeat("play protocol v5 compression ", 300) + `"}`)
+
+func TestRequestRoundTrip(t *testing.T) {
+	deadline := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	var cases = []struct {
+		name    string
+		request PlayProtocolRequest
+		want    PlayProtocolRequest // 为空时与request相同
+	}{
+		{name: "v2", request: request(2, func(r *PlayProtocolRequest) {
+			r.Header = requestHeader{TraceId: testTraceId, CallerId: 0xfffe}
+		})},
+		{name: "v3", request: request(3, func(r *PlayProtocolRequest) {
+			r.Header = requestHeader{TraceId: testTraceId, SpanId: []byte{1, 2, 3}, CallerId: 70000, TagId: 9}
+		})},
+		{name: "v4", request: request(4, func(r *PlayProtocolRequest) {
+			r.NonRespond, r.Render = true, 1
+			r.Header = requestHeader{TraceId: "short", SpanId: []byte{1, 2}, CallerId: 7, TagId: 9, Deadline: deadline, IdempotencyKey: "k1"}
+			r.Attachment = map[string][]byte{"a": []byte("1"), "bin": {0, 255, 0}}
+		})},
+		{name: "v5", request: request(5, func(r *PlayProtocolRequest) {
+			r.NonRespond = true
+			r.Header = requestHeader{TraceId: "t", CallerId: 7, AcceptCompress: "zstd,gzip", Metadata: map[string]string{"x-tenant": "a"}}
+			r.Attachment = map[string][]byte{"a": []byte("1")}
+		})},
+		{name: "v5 empty", request: PlayProtocolRequest{Version: 5, Action: "a"}},
+		{name: "v5 body below threshold not compressed", request: request(5, func(r *PlayProtocolRequest) {
+			r.Compress = COMPRESS_GZIP
+		}), want: request(5, func(r *PlayProtocolRequest) {})},
+	}
+	for _, c := range []byte{COMPRESS_GZIP, COMPRESS_ZSTD, COMPRESS_SNAPPY} {
+		c := c
+		cases = append(cases, struct {
+			name    string
+			request PlayProtocolRequest
+			want    PlayProtocolRequest
+		}{name: "v5 " + CompressName(c), request: request(5, func(r *PlayProtocolRequest) {
+			r.Compress, r.Body = c, largeBody
+			r.Attachment = map[string][]byte{"a": []byte("1")}
+		})})
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := MarshalProtocolRequest(c.request)
+			if err != nil {
+				t.Fatalf("MarshalProtocolRequest error: %v", err)
+			}
+			if c.request.Compress != COMPRESS_NONE && len(c.request.Body) >= CompressThreshold && len(data) >= len(c.request.Body) {
+				t.Errorf("compressed frame %d bytes, body %d bytes", len(data), len(c.request.Body))
+			}
+			// 帧之后的数据属于下一帧
+			got, size, err := UnmarshalProtocolRequest(append(data, "==>>next"...))
+			if err != nil {
+				t.Fatalf("UnmarshalProtocolRequest error: %v", err)
+			}
+			if int(size) != len(data) {
+				t.Errorf("dataSize = %d, want %d", size, len(data))
+			}
+			want := c.want
+			if want.Version == 0 {
+				want = c.request
+			}
+			if !requestEqual(got, want) {
+				t.Errorf("UnmarshalProtocolRequest = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	var cases = []struct {
+		name     string
+		response PlayProtocolResponse
+		want     PlayProtocolResponse
+	}{
+		{name: "v2", response: response(2, func(r *PlayProtocolResponse) {
+			r.ResultCode = 0
+		})},
+		{name: "v3", response: response(3, func(r *PlayProtocolResponse) {
+			r.Header.TagId = 9
+		})},
+		{name: "v4", response: response(4, func(r *PlayProtocolResponse) {
+			r.Render, r.Header.TagId = 1, 9
+			r.Header.Metadata = map[string]string{"k": "v"}
+			r.Attachment = map[string][]byte{"a": []byte("1"), "b": {}}
+		})},
+		{name: "v4 push", response: response(4, func(r *PlayProtocolResponse) {
+			r.Header.Action = "chat.message"
+		})},
+		{name: "v5", response: response(5, func(r *PlayProtocolResponse) {
+			r.Header.Metadata = map[string]string{"k": "v"}
+			r.Attachment = map[string][]byte{"a": []byte("1")}
+		})},
+		{name: "v5 large rc", response: response(5, func(r *PlayProtocolResponse) {
+			r.ResultCode = 0x7fffffff
+		})},
+	}
+	for _, c := range []byte{COMPRESS_GZIP, COMPRESS_ZSTD, COMPRESS_SNAPPY} {
+		c := c
+		cases = append(cases, struct {
+			name     string
+			response PlayProtocolResponse
+			want     PlayProtocolResponse
+		}{name: "v5 " + CompressName(c), response: response(5, func(r *PlayProtocolResponse) {
+			r.Compress, r.Body = c, largeBody
+			r.Header.Action = "push"
+		})})
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := MarshalProtocolResponse(c.response)
+			if err != nil {
+				t.Fatalf("MarshalProtocolResponse error: %v", err)
+			}
+			if c.response.Compress != COMPRESS_NONE && len(data) >= len(c.response.Body) {
+				t.Errorf("compressed frame %d bytes, body %d bytes", len(data), len(c.response.Body))
+			}
+			got, size, err := UnmarshalProtocolResponse(append(data, "<<==next"...))
+			if err != nil {
+				t.Fatalf("UnmarshalProtocolResponse error: %v", err)
+			}
+			if int(size) != len(data) {
+				t.Errorf("dataSize = %d, want %d", size, len(data))
+			}
+			want := c.want
+			if want.Version == 0 {
+				want = c.response
+			}
+			if !responseEqual(got, want) {
+				t.Errorf("UnmarshalProtocolResponse = %+v, want %+v", got, want)
+			}
+			if got.IsPush() != (want.Header.Action != "") {
+				t.Errorf("IsPush = %v", got.IsPush())
+			}
+		})
+	}
+}
+
+func TestUnmarshalPartialFrame(t *testing.T) {
+	for _, version := range []byte{2, 3, 4, 5} {
+		data, err := MarshalProtocolResponse(response(version, func(r *PlayProtocolResponse) {}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			if _, size, err := UnmarshalProtocolResponse(data[:n]); err != nil || size != 0 {
+				t.Fatalf("v%d partial frame %d/%d: dataSize = %d, err = %v", version, n, len(data), size, err)
+			}
+		}
+	}
+	data, _ := MarshalProtocolRequest(request(5, func(r *PlayProtocolRequest) {}))
+	for n := 0; n < len(data); n++ {
+		if _, size, err := UnmarshalProtocolRequest(data[:n]); err != nil || size != 0 {
+			t.Fatalf("request partial frame %d/%d: dataSize = %d, err = %v", n, len(data), size, err)
+		}
+	}
+}
+
+func TestUnmarshalV5Error(t *testing.T) {
+	valid, err := MarshalProtocolResponse(response(5, func(r *PlayProtocolResponse) {
+		r.Compress, r.Body = COMPRESS_ZSTD, largeBody
+		r.Attachment = map[string][]byte{"a": []byte("1")}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// mutate修改帧后按需重新计算crc, 以测试crc之后的校验
+	var cases = []struct {
+		name   string
+		mutate func(b []byte) []byte
+		fixCrc bool
+		err    string
+	}{
+		{"bad head", func(b []byte) []byte { copy(b, "XXXX"); return b }, false, "socket protocol head error"},
+		{"unknown version", func(b []byte) []byte { b[8] = 9; return b }, false, "socket protocol version error"},
+		{"checksum", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }, false, "socket protocol checksum error"},
+		{"header length", func(b []byte) []byte { b[15]++; return b }, false, "socket protocol format error"},
+		{"short frame", func(b []byte) []byte { copy(b[4:8], _intToBytes(20)); return b[:28] }, false, "socket protocol format error"},
+		{"unsupported compress", func(b []byte) []byte { b[10] = 9; return b }, true, "unsupported compress type"},
+		{"corrupt compressed body", func(b []byte) []byte { b[31+headerLen(b)+4] ^= 0xff; return b }, true, ""},
+		{"attachment overflow", func(b []byte) []byte { b[len(b)-5] = 200; return b }, true, "socket protocol attachment error"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data := c.mutate(append([]byte{}, valid...))
+			if c.fixCrc {
+				copy(data[27:31], _intToBytes(int(crc32.ChecksumIEEE(data[31:]))))
+			}
+			_, _, err := UnmarshalProtocolResponse(data)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("UnmarshalProtocolResponse error = %v, want %q", err, c.err)
+			}
+		})
+	}
+
+	request, _ := MarshalProtocolRequest(request(5, func(r *PlayProtocolRequest) {}))
+	request[len(request)-1] ^= 1
+	if _, _, err := UnmarshalProtocolRequest(request); err == nil || !strings.Contains(err.Error(), "checksum error") {
+		t.Errorf("UnmarshalProtocolRequest checksum error = %v", err)
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	saved := MaxDecompressSize
+	MaxDecompressSize = 1024
+	defer func() { MaxDecompressSize = saved }()
+
+	body := bytes.Repeat([]byte("a"), 4096)
+	for _, c := range []byte{COMPRESS_GZIP, COMPRESS_SNAPPY} {
+		data, err := Compress(c, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Decompress(c, data); err == nil || !strings.Contains(err.Error(), "too large") {
+			t.Errorf("%s Decompress error = %v, want too large", CompressName(c), err)
+		}
+	}
+}
+
+func TestNegotiateCompress(t *testing.T) {
+	var cases = map[string]byte{
+		"":                 COMPRESS_NONE,
+		"br":               COMPRESS_NONE,
+		"zstd,snappy,gzip": COMPRESS_ZSTD,
+		"br, gzip , zstd":  COMPRESS_GZIP,
+		AcceptCompress():   COMPRESS_ZSTD,
+		"snappy":           COMPRESS_SNAPPY,
+		"gzip;q=1, snappy": COMPRESS_SNAPPY,
+	}
+	for accept, want := range cases {
+		if got := NegotiateCompress(accept); got != want {
+			t.Errorf("NegotiateCompress(%q) = %d, want %d", accept, got, want)
+		}
+	}
+}
+
+func request(version byte, f func(r *PlayProtocolRequest)) PlayProtocolRequest {
+	r := PlayProtocolRequest{Version: version, Action: "user.info", Body: []byte(`{"uid":1}`)}
+	r.Header.TraceId = testTraceId
+	f(&r)
+	return r
+}
+
+func response(version byte, f func(r *PlayProtocolResponse)) PlayProtocolResponse {
+	r := PlayProtocolResponse{Version: version, ResultCode: 3, Body: []byte(`{"name":"play"}`)}
+	r.Header.TraceId = testTraceId
+	f(&r)
+	return r
+}
+
+func headerLen(frame []byte) int {
+	return int(_bytesToUint32(frame[15:19]))
+}
+
+// requestEqual 压缩算法在body未达到阈值时不生效, 不参与比较
+func requestEqual(got, want PlayProtocolRequest) bool {
+	if !got.Header.Deadline.Equal(want.Header.Deadline) {
+		return false
+	}
+	got.Header.Deadline, want.Header.Deadline = time.Time{}, time.Time{}
+	got.Compress, want.Compress = 0, 0
+	return reflect.DeepEqual(normalize(got.Body), normalize(want.Body)) &&
+		reflect.DeepEqual(normalizeAttachment(got.Attachment), normalizeAttachment(want.Attachment)) &&
+		reflect.DeepEqual(normalizeSpan(got.Header.SpanId), normalizeSpan(want.Header.SpanId)) &&
+		got.Version == want.Version && got.Action == want.Action && got.NonRespond == want.NonRespond && got.Render == want.Render &&
+		got.Header.TraceId == want.Header.TraceId && got.Header.CallerId == want.Header.CallerId && got.Header.TagId == want.Header.TagId &&
+		got.Header.AcceptCompress == want.Header.AcceptCompress && got.Header.IdempotencyKey == want.Header.IdempotencyKey &&
+		reflect.DeepEqual(got.Header.Metadata, want.Header.Metadata)
+}
+
+func responseEqual(got, want PlayProtocolResponse) bool {
+	got.Compress, want.Compress = 0, 0
+	return reflect.DeepEqual(normalize(got.Body), normalize(want.Body)) &&
+		reflect.DeepEqual(normalizeAttachment(got.Attachment), normalizeAttachment(want.Attachment)) &&
+		got.Version == want.Version && got.Render == want.Render && got.ResultCode == want.ResultCode &&
+		got.Header.TraceId == want.Header.TraceId && got.Header.TagId == want.Header.TagId && got.Header.Action == want.Header.Action &&
+		reflect.DeepEqual(got.Header.Metadata, want.Header.Metadata)
+}
+
+func normalize(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func normalizeSpan(b []byte) []byte {
+	return normalize(b)
+}
+
+func normalizeAttachment(m map[string][]byte) map[string][]byte {
+	if len(m) == 0 {
+		return nil
+	}
+	var out = make(map[string][]byte, len(m))
+	for k, v := range m {
+		out[k] = normalize(v)
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/logger"
+	"github.com/robfig/cron/v3"
+)
+
+var logLevels = map[string]int{"debug": logger.LEVEL_DEBUG, "info": logger.LEVEL_INFO, "warn": logger.LEVEL_WARN, "error": logger.LEVEL_ERROR}
+
+// BindLoggerLevel 日志级别跟随配置, 值为debug/info/warn/error或0-3
+func BindLoggerLevel(key string) error {
+	return bind(key, func(v interface{}) (func(), error) {
+		level, err := parseLogLevel(v)
+		if err != nil {
+			return nil, err
+		}
+		return func() { logger.SetLevel(level) }, nil
+	})
+}
+
+// BindActionTimeouts action超时时间跟随配置, 值为以action名为key的对象, 如 {"user.info": "800ms", "user.list": 2000},
+// 数字单位为毫秒; 从配置中移除的action恢复为ActionDefaultTimeout
+func BindActionTimeouts(key string) error {
+	var mutex sync.Mutex
+	var applied = make(map[string]bool)
+	return bind(key, func(v interface{}) (func(), error) {
+		m, err := play.ParseMapInterface(v)
+		if err != nil {
+			return nil, err
+		}
+		var timeouts = make(map[string]time.Duration, len(m))
+		for name, item := range m {
+			if play.GetAction(name) == nil {
+				return nil, errors.New("unknown action " + name)
+			}
+			if timeouts[name], err = parseTimeout(item); err != nil {
+				return nil, errors.New("action " + name + " timeout error: " + err.Error())
+			}
+		}
+		return func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for name := range applied {
+				if _, ok := timeouts[name]; !ok {
+					play.SetActionTimeout(name, 0)
+					delete(applied, name)
+				}
+			}
+			for name, timeout := range timeouts {
+				play.SetActionTimeout(name, timeout)
+				applied[name] = true
+			}
+		}, nil
+	})
+}
+
+// BindCronSpecs 定时任务的执行计划跟随配置, 值为以任务名为key的对象, 如 {"clean": "*/5 * * * *"}; 未配置的任务停止执行
+func BindCronSpecs(key string) error {
+	return bind(key, func(v interface{}) (func(), error) {
+		m, err := play.ParseMapInterface(v)
+		if err != nil {
+			return nil, err
+		}
+		var list = make([]play.CronConfig, 0, len(m))
+		for name, item := range m {
+			spec, err := play.ParseString(item)
+			if err != nil {
+				return nil, errors.New("cron " + name + " spec error: " + err.Error())
+			}
+			if _, err = cron.ParseStandard(spec); err != nil {
+				return nil, errors.New("cron " + name + " spec error: " + err.Error())
+			}
+			list = append(list, play.CronConfig{Name: name, Spec: spec})
+		}
+		return func() {
+			if err := play.CronUpdate(list); err != nil {
+				logger.System("cron update from config error", "error", err.Error())
+			}
+		}, nil
+	})
+}
+
+// bind 立即应用当前配置, 新配置无法解析时拒绝重新加载, 变更后重新应用
+func bind(key string, parseVal func(v interface{}) (func(), error)) error {
+	var parse = func(v interface{}) (func(), error) {
+		if v == nil {
+			return nil, errors.New("is empty")
+		}
+		return parseVal(v)
+	}
+	v, err := getVal(key)
+	if err != nil {
+		return err
+	}
+	apply, err := parse(v)
+	if err != nil {
+		return errors.New("config: " + key + " " + err.Error())
+	}
+	apply()
+
+	AddValidator(func(next Parser) error {
+		v, err := getValFrom(next, key)
+		if err != nil {
+			return errors.New(key + " " + err.Error())
+		}
+		if _, err = parse(v); err != nil {
+			return errors.New(key + " " + err.Error())
+		}
+		return nil
+	})
+	OnChange(key, func(c Change) {
+		if apply, err := parse(c.New); err == nil {
+			apply()
+		}
+	})
+	return nil
+}
+
+func parseLogLevel(v interface{}) (int, error) {
+	if s, ok := v.(string); ok {
+		if level, ok := logLevels[strings.ToLower(s)]; ok {
+			return level, nil
+		}
+	}
+	level, err := play.ParseInt(v)
+	if err != nil || level < logger.LEVEL_ERROR || level > logger.LEVEL_DEBUG {
+		return 0, errors.New("invalid log level")
+	}
+	return level, nil
+}
+
+func parseTimeout(v interface{}) (time.Duration, error) {
+	if s, ok := v.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+	}
+	ms, err := play.ParseInt64(v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
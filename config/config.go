@@ -1,17 +1,33 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
 
 	"github.com/leochen2038/play"
 )
 
+// ErrKeyNotExist 配置中不存在key, 以errors.Is判断; 其他错误(如解密失败)不能视为配置不存在
+var ErrKeyNotExist = errors.New("not exist key")
+
+// Parser 配置源, key不存在时返回包装了ErrKeyNotExist的错误
 type Parser interface {
 	GetVal(key string) (val interface{}, err error)
 }
 
+func keyNotExist(key string) error {
+	return fmt.Errorf("%w %s", ErrKeyNotExist, key)
+}
+
 type config struct {
-	parser Parser
+	parser atomic.Value // parserHolder
+}
+
+type parserHolder struct {
+	Parser
 }
 
 type emptyParser struct {
@@ -21,15 +37,76 @@ func (emptyParser) GetVal(key string) (val interface{}, err error) {
 	return nil, errors.New("empty parser, call config.InitConfig() first")
 }
 
-var configInstance *config = &config{parser: emptyParser{}}
+var configInstance = newConfig()
 
+func newConfig() *config {
+	c := new(config)
+	c.parser.Store(parserHolder{emptyParser{}})
+	return c
+}
+
+// InitConfig 设置配置源, 其中可热更新的文件配置在重新加载时先经过AddValidator注册的校验, 再通知OnChange订阅
 func InitConfig(parser Parser) {
-	configInstance.parser = parser
+	configInstance.parser.Store(parserHolder{parser})
+	watchReload(parser)
+}
+
+func currentParser() Parser {
+	return configInstance.parser.Load().(parserHolder).Parser
+}
+
+// getVal 读取配置并替换字符串中的${VAR}
+func getVal(key string) (interface{}, error) {
+	return getValFrom(currentParser(), key)
+}
+
+// getValFrom 替换${VAR}后解密enc:开头的值
+func getValFrom(parser Parser, key string) (interface{}, error) {
+	v, err := parser.GetVal(key)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = decryptSecrets(interpolate(v)); err != nil {
+		return nil, errors.New(key + ": " + err.Error())
+	}
+	return v, nil
+}
+
+// Dump 输出key对应的配置, key为空时输出全部文件配置; 加密值及密码等敏感字段被替换, 不解密
+func Dump(key string) (string, error) {
+	var v interface{}
+	if key == "" {
+		v = dumpData(Snapshot())
+	} else {
+		var err error
+		if v, err = Snapshot().GetVal(key); err != nil {
+			return "", err
+		}
+		v = interpolate(v)
+	}
+	data, err := json.MarshalIndent(redactSecrets(key[strings.LastIndex(key, ".")+1:], v), "", "  ")
+	return string(data), err
+}
+
+func dumpData(parser Parser) map[string]interface{} {
+	switch p := parser.(type) {
+	case mapParser:
+		return interpolate(map[string]interface{}(p)).(map[string]interface{})
+	case *LayeredParser:
+		var m = make(map[string]interface{})
+		for _, sub := range p.parsers {
+			if data := dumpData(sub); data != nil {
+				m = mergeMap(m, data)
+			}
+		}
+		return m
+	}
+	return nil
 }
 
 func Bool(key string) (val bool, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 
@@ -38,7 +115,7 @@ func Bool(key string) (val bool, err error) {
 
 func String(key string) (val string, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 
@@ -47,7 +124,7 @@ func String(key string) (val string, err error) {
 
 func Int(key string) (val int, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 	return play.ParseInt(v)
@@ -55,7 +132,7 @@ func Int(key string) (val int, err error) {
 
 func Int64(key string) (val int64, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 
@@ -64,7 +141,7 @@ func Int64(key string) (val int64, err error) {
 
 func Float64(key string) (val float64, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 
@@ -73,7 +150,7 @@ func Float64(key string) (val float64, err error) {
 
 func MapInterface(key string) (list map[string]interface{}, err error) {
 	var v interface{}
-	if v, err = configInstance.parser.GetVal(key); err != nil {
+	if v, err = getVal(key); err != nil {
 		return
 	}
 	return play.ParseMapInterface(v)
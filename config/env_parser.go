@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvParser 以环境变量读取配置, key a.b-c 对应 PREFIX_A_B_C; 读取对象时返回以该名称为前缀的变量, 去除前缀后小写为key
+type EnvParser struct {
+	prefix string
+	vars   func() map[string]string
+}
+
+// NewEnvParser prefix可为空
+func NewEnvParser(prefix string) Parser {
+	return &EnvParser{prefix: prefix, vars: environ}
+}
+
+// NewDotenvParser 从.env文件读取, 文件中的变量不写入进程环境变量
+func NewDotenvParser(file string, prefix string) (Parser, error) {
+	vars, err := readDotenv(file)
+	if err != nil {
+		return nil, err
+	}
+	return &EnvParser{prefix: prefix, vars: func() map[string]string { return vars }}, nil
+}
+
+// LoadDotenv 将.env文件中的变量写入进程环境变量, 已存在的变量不覆盖, 供${VAR}替换使用
+func LoadDotenv(file string) error {
+	vars, err := readDotenv(file)
+	if err != nil {
+		return err
+	}
+	for k, v := range vars {
+		if _, ok := os.LookupEnv(k); !ok {
+			if err = os.Setenv(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (parser *EnvParser) GetVal(key string) (val interface{}, err error) {
+	var name = envName(parser.prefix, key)
+	var vars = parser.vars()
+	if v, ok := vars[name]; ok {
+		return v, nil
+	}
+	var m = make(map[string]interface{})
+	for k, v := range vars {
+		if strings.HasPrefix(k, name+"_") {
+			m[strings.ToLower(k[len(name)+1:])] = v
+		}
+	}
+	if len(m) > 0 {
+		return m, nil
+	}
+	return nil, keyNotExist(key)
+}
+
+func envName(prefix string, key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if prefix != "" {
+		name = strings.ToUpper(prefix) + "_" + name
+	}
+	return name
+}
+
+func environ() map[string]string {
+	var vars = make(map[string]string)
+	for _, v := range os.Environ() {
+		if idx := strings.IndexByte(v, '='); idx > 0 {
+			vars[v[:idx]] = v[idx+1:]
+		}
+	}
+	return vars
+}
+
+func readDotenv(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := decodeDotenv(data)
+	if err != nil {
+		return nil, errors.New(file + ": " + err.Error())
+	}
+	return vars, nil
+}
+
+// decodeDotenv 每行KEY=VALUE, 支持export前缀、#注释、单引号原样及双引号转义
+func decodeDotenv(data []byte) (map[string]string, error) {
+	var err error
+	var vars = make(map[string]string)
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		idx := strings.IndexByte(line, '=')
+		if idx <= 0 {
+			return nil, errors.New("line " + strconv.Itoa(num) + ": expected KEY=VALUE")
+		}
+		key, value := strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, errors.New("line " + strconv.Itoa(num) + ": unterminated quoted value")
+			}
+			if value, err = strconv.Unquote(value[:end+1]); err != nil {
+				return nil, errors.New("line " + strconv.Itoa(num) + ": " + err.Error())
+			}
+		case strings.HasPrefix(value, "'"):
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, errors.New("line " + strconv.Itoa(num) + ": unterminated quoted value")
+			}
+			value = value[1 : end+1]
+		default:
+			if idx = strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+// closingQuote 返回双引号字符串结束引号的位置, 跳过转义
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
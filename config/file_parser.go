@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fileParser 以dotted key读取解码后的文件; refresh大于0时定期检查文件修改并重新加载,
+// 新配置经过校验后整体替换, 解码或校验失败时保留原配置
+type fileParser struct {
+	refreshTickTime time.Duration
+	lastFileModTime int64
+	filename        string
+	decode          func(data []byte) (map[string]interface{}, error)
+	data            atomic.Value // map[string]interface{}, 加载后不再修改
+	hook            atomic.Value // reloadHook
+}
+
+// reloadHook 校验新配置, 通过后调用commit替换
+type reloadHook func(next Parser, commit func()) error
+
+// mapParser 不可变的配置快照
+type mapParser map[string]interface{}
+
+func (m mapParser) GetVal(key string) (val interface{}, err error) {
+	return lookup(m, key)
+}
+
+// NewFileYamlParser yaml仅支持常用子集: 块状及流式的对象与数组、引号字符串、|与>多行字符串, 不支持锚点与标签
+func NewFileYamlParser(file string, refresh time.Duration) (Parser, error) {
+	return newFileParser(file, refresh, decodeYaml)
+}
+
+// NewFileTomlParser 日期时间值按字符串读取
+func NewFileTomlParser(file string, refresh time.Duration) (Parser, error) {
+	return newFileParser(file, refresh, decodeToml)
+}
+
+// NewFileParser 按扩展名选择json、yaml或toml解析
+func NewFileParser(file string, refresh time.Duration) (Parser, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return NewFileJsonParser(file, refresh)
+	case ".yaml", ".yml":
+		return NewFileYamlParser(file, refresh)
+	case ".toml":
+		return NewFileTomlParser(file, refresh)
+	}
+	return nil, errors.New("unsupported config file " + file)
+}
+
+func newFileParser(file string, refresh time.Duration, decode func(data []byte) (map[string]interface{}, error)) (Parser, error) {
+	var parser = new(fileParser)
+	if err := parser.init(file, refresh, decode); err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func (parser *fileParser) init(file string, refresh time.Duration, decode func(data []byte) (map[string]interface{}, error)) error {
+	parser.filename, parser.refreshTickTime, parser.decode = file, refresh, decode
+	fileInfo, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := parser.load(fileInfo)
+	if err != nil {
+		return err
+	}
+	parser.data.Store(data)
+	if refresh > 0 {
+		parser.startWatchFile()
+	}
+	return nil
+}
+
+func (parser *fileParser) GetVal(key string) (val interface{}, err error) {
+	return lookup(parser.Data(), key)
+}
+
+// Data 当前配置, 不可修改
+func (parser *fileParser) Data() map[string]interface{} {
+	data, _ := parser.data.Load().(map[string]interface{})
+	return data
+}
+
+func (parser *fileParser) Name() string {
+	return parser.filename
+}
+
+func (parser *fileParser) snapshot() Parser {
+	return mapParser(parser.Data())
+}
+
+func (parser *fileParser) onReload(hook reloadHook) {
+	parser.hook.Store(hook)
+}
+
+// load 仅在构造及监听协程中调用, 解码失败时同样记录修改时间, 避免重复报错
+func (parser *fileParser) load(fileInfo os.FileInfo) (map[string]interface{}, error) {
+	parser.lastFileModTime = fileInfo.ModTime().UnixNano()
+	dataByte, err := os.ReadFile(parser.filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parser.decode(dataByte)
+	if err != nil {
+		return nil, errors.New(parser.filename + ": " + err.Error())
+	}
+	return data, nil
+}
+
+func (parser *fileParser) reload(fileInfo os.FileInfo) error {
+	data, err := parser.load(fileInfo)
+	if err != nil {
+		return err
+	}
+	commit := func() {
+		parser.data.Store(data)
+	}
+	if hook, _ := parser.hook.Load().(reloadHook); hook != nil {
+		return hook(mapParser(data), commit)
+	}
+	commit()
+	return nil
+}
+
+func (parser *fileParser) startWatchFile() {
+	go func() {
+		defer func() {
+			if panicInfo := recover(); panicInfo != nil {
+				fmt.Println("start watch config file panic:", panicInfo)
+			}
+			time.Sleep(5 * time.Second)
+			parser.startWatchFile()
+		}()
+		parser.watchFile()
+	}()
+}
+
+func (parser *fileParser) watchFile() {
+	var ticker = time.NewTicker(parser.refreshTickTime)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fileInfo, err := os.Stat(parser.filename)
+		if err != nil || fileInfo.ModTime().UnixNano() == parser.lastFileModTime {
+			continue
+		}
+		if err = parser.reload(fileInfo); err != nil {
+			fmt.Println("reload config file error:", err)
+		}
+	}
+}
+
+// lookup 按dotted key逐层查找对象
+func lookup(data map[string]interface{}, key string) (interface{}, error) {
+	var val interface{} = data
+	for _, k := range strings.Split(key, ".") {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, keyNotExist(key)
+		}
+		if val, ok = m[k]; !ok {
+			return nil, keyNotExist(key)
+		}
+	}
+	return val, nil
+}
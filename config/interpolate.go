@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// interpolate 替换字符串中的${VAR}及${VAR:-default}为环境变量, $${ 表示字面量${; map与slice返回替换后的副本
+func interpolate(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return expandEnv(v)
+	case map[string]interface{}:
+		var m = make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = interpolate(item)
+		}
+		return m
+	case []interface{}:
+		var list = make([]interface{}, len(v))
+		for idx, item := range v {
+			list[idx] = interpolate(item)
+		}
+		return list
+	}
+	return v
+}
+
+func expandEnv(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if s[i+1] == '$' && i+2 < len(s) && s[i+2] == '{' {
+			buf.WriteByte('$')
+			i++
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if s[i+1] != '{' || end < 0 {
+			buf.WriteByte(s[i])
+			continue
+		}
+		name, def := s[i+2:i+end], ""
+		hasDef := false
+		if idx := strings.Index(name, ":-"); idx >= 0 {
+			name, def, hasDef = name[:idx], name[idx+2:], true
+		}
+		if val, ok := os.LookupEnv(name); ok && (val != "" || !hasDef) {
+			buf.WriteString(val)
+		} else {
+			buf.WriteString(def)
+		}
+		i += end
+	}
+	return buf.String()
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+)
+
+// LayeredParser 合并多个配置源, 后面的源优先, 如 NewLayeredParser(defaults, file, NewEnvParser("APP"));
+// 各源中同一key的值均为对象时逐层合并, 否则取优先级最高的源
+type LayeredParser struct {
+	parsers []Parser
+}
+
+func NewLayeredParser(parsers ...Parser) Parser {
+	return &LayeredParser{parsers: parsers}
+}
+
+func (parser *LayeredParser) GetVal(key string) (val interface{}, err error) {
+	var found bool
+	for _, p := range parser.parsers {
+		v, err := p.GetVal(key)
+		if errors.Is(err, ErrKeyNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			if base, ok := val.(map[string]interface{}); ok {
+				v = mergeMap(base, m)
+			}
+		}
+		val, found = v, true
+	}
+	if !found {
+		return nil, keyNotExist(key)
+	}
+	return val, nil
+}
+
+// mergeMap 返回合并后的新对象, 不修改原对象
+func mergeMap(base map[string]interface{}, over map[string]interface{}) map[string]interface{} {
+	var m = make(map[string]interface{}, len(base)+len(over))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range over {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if baseSub, ok := m[k].(map[string]interface{}); ok {
+				v = mergeMap(baseSub, sub)
+			}
+		}
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,413 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/leochen2038/play/logger"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// SECRET_PREFIX 加密值的前缀, 格式为 enc:<scheme>:<data>
+	SECRET_PREFIX           = "enc:"
+	SECRET_SCHEME_AES       = "aes"
+	SECRET_SCHEME_X25519    = "x25519"
+	X25519_RECIPIENT_PREFIX = "x25519-pub:"
+	X25519_IDENTITY_PREFIX  = "x25519-key:"
+)
+
+// SecretProvider 加解密配置值, Encrypt返回带SECRET_PREFIX的完整值, Decrypt接收完整值
+type SecretProvider interface {
+	Scheme() string
+	Encrypt(plaintext string) (string, error)
+	Decrypt(value string) (string, error)
+}
+
+var (
+	secretMutex     sync.RWMutex
+	secretProviders = make(map[string]SecretProvider)
+	secretCache     sync.Map
+)
+
+// RegisterSecretProvider 注册后, 读取配置时以enc:开头的值自动解密, 同一scheme后注册的替换先注册的
+func RegisterSecretProvider(provider SecretProvider) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	secretProviders[provider.Scheme()] = provider
+}
+
+func IsSecret(value string) bool {
+	return strings.HasPrefix(value, SECRET_PREFIX)
+}
+
+// DecryptSecret 使用已注册的provider解密, 解密结果注册为日志敏感值
+func DecryptSecret(value string) (string, error) {
+	if plaintext, ok := secretCache.Load(value); ok {
+		return plaintext.(string), nil
+	}
+	scheme := strings.SplitN(strings.TrimPrefix(value, SECRET_PREFIX), ":", 2)[0]
+	secretMutex.RLock()
+	provider := secretProviders[scheme]
+	secretMutex.RUnlock()
+	if provider == nil {
+		return "", errors.New("no secret provider for scheme " + scheme)
+	}
+	plaintext, err := provider.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	logger.RegisterSecret(plaintext)
+	secretCache.Store(value, plaintext)
+	return plaintext, nil
+}
+
+// RotateSecret 以已注册的provider解密后使用to重新加密
+func RotateSecret(value string, to SecretProvider) (string, error) {
+	plaintext, err := DecryptSecret(value)
+	if err != nil {
+		return "", err
+	}
+	return to.Encrypt(plaintext)
+}
+
+// decryptSecrets 解密值中的加密字符串, map与slice返回副本
+func decryptSecrets(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		if IsSecret(v) {
+			return DecryptSecret(v)
+		}
+	case map[string]interface{}:
+		var m = make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if m[k], err = decryptSecrets(item); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		var list = make([]interface{}, len(v))
+		for idx, item := range v {
+			if list[idx], err = decryptSecrets(item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return v, nil
+}
+
+// redactSecrets 加密值及敏感字段替换为REDACTED, 用于输出配置
+func redactSecrets(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if IsSecret(v) || logger.IsSensitiveKey(key) {
+			return logger.REDACTED
+		}
+		return logger.Redact(v)
+	case map[string]interface{}:
+		var m = make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = redactSecrets(k, item)
+		}
+		return m
+	case []interface{}:
+		var list = make([]interface{}, len(v))
+		for idx, item := range v {
+			list[idx] = redactSecrets(key, item)
+		}
+		return list
+	}
+	if v != nil && logger.IsSensitiveKey(key) {
+		return logger.REDACTED
+	}
+	return v
+}
+
+// aesProvider 密钥文件每行一个base64编码的32字节密钥, 第一行用于加密, 其余用于解密轮换前的值
+type aesProvider struct {
+	keys map[string]cipher.AEAD
+	ids  []string
+}
+
+// NewAESSecretProvider 加密值格式为 enc:aes:<密钥id>:<base64(nonce|密文)>
+func NewAESSecretProvider(keyFile string) (SecretProvider, error) {
+	lines, err := readKeyLines(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var p = &aesProvider{keys: make(map[string]cipher.AEAD)}
+	for _, line := range lines {
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New(keyFile + ": key must be base64 encoded 32 bytes")
+		}
+		block, _ := aes.NewCipher(key)
+		aead, _ := cipher.NewGCM(block)
+		id := AESKeyId(line)
+		p.keys[id], p.ids = aead, append(p.ids, id)
+	}
+	if len(p.ids) == 0 {
+		return nil, errors.New(keyFile + ": no key found")
+	}
+	return p, nil
+}
+
+// GenerateAESKey 生成base64编码的密钥
+func GenerateAESKey() (string, error) {
+	var key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// AESKeyId 密钥的标识, 为sha256的前4字节
+func AESKeyId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (p *aesProvider) Scheme() string {
+	return SECRET_SCHEME_AES
+}
+
+func (p *aesProvider) Encrypt(plaintext string) (string, error) {
+	id := p.ids[0]
+	aead := p.keys[id]
+	var nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return SECRET_PREFIX + SECRET_SCHEME_AES + ":" + id + ":" + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func (p *aesProvider) Decrypt(value string) (string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(value, SECRET_PREFIX+SECRET_SCHEME_AES+":"), ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("invalid aes secret")
+	}
+	aead, ok := p.keys[parts[0]]
+	if !ok {
+		return "", errors.New("unknown aes key id " + parts[0])
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("invalid aes secret")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(parts[0]))
+	if err != nil {
+		return "", errors.New("decrypt aes secret error: " + err.Error())
+	}
+	return string(plaintext), nil
+}
+
+// x25519Provider 类似age的接收者加密: 每个值使用随机密钥加密, 该密钥分别以各接收者的公钥封装,
+// 持有任一接收者私钥即可解密; 仅配置接收者时只能加密
+type x25519Provider struct {
+	recipients [][]byte
+	identities [][]byte
+}
+
+// NewX25519SecretProvider recipients为x25519-pub:开头的公钥, identityFile每行一个x25519-key:开头的私钥, 可为空
+func NewX25519SecretProvider(recipients []string, identityFile string) (SecretProvider, error) {
+	var p = new(x25519Provider)
+	for _, r := range recipients {
+		key, err := decodeX25519Key(r, X25519_RECIPIENT_PREFIX)
+		if err != nil {
+			return nil, err
+		}
+		p.recipients = append(p.recipients, key)
+	}
+	if identityFile != "" {
+		lines, err := readKeyLines(identityFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			key, err := decodeX25519Key(line, X25519_IDENTITY_PREFIX)
+			if err != nil {
+				return nil, errors.New(identityFile + ": " + err.Error())
+			}
+			p.identities = append(p.identities, key)
+		}
+	}
+	if len(p.recipients) == 0 && len(p.identities) == 0 {
+		return nil, errors.New("x25519 secret provider requires recipients or identities")
+	}
+	return p, nil
+}
+
+// GenerateX25519Identity 生成私钥及对应的公钥
+func GenerateX25519Identity() (identity string, recipient string, err error) {
+	var key = make([]byte, curve25519.ScalarSize)
+	if _, err = rand.Read(key); err != nil {
+		return
+	}
+	pub, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	return X25519_IDENTITY_PREFIX + base64.RawURLEncoding.EncodeToString(key), X25519_RECIPIENT_PREFIX + base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+// X25519Recipient 私钥对应的公钥
+func X25519Recipient(identity string) (string, error) {
+	key, err := decodeX25519Key(identity, X25519_IDENTITY_PREFIX)
+	if err != nil {
+		return "", err
+	}
+	pub, err := curve25519.X25519(key, curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return X25519_RECIPIENT_PREFIX + base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+func (p *x25519Provider) Scheme() string {
+	return SECRET_SCHEME_X25519
+}
+
+// Encrypt 数据格式: 接收者数量(1字节) | 每个接收者的临时公钥(32字节)及封装后的密钥(48字节) | nonce | 密文
+func (p *x25519Provider) Encrypt(plaintext string) (string, error) {
+	if len(p.recipients) == 0 || len(p.recipients) > 255 {
+		return "", errors.New("x25519 encrypt requires 1-255 recipients")
+	}
+	var fileKey = make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return "", err
+	}
+	var buf = bytes.NewBuffer([]byte{byte(len(p.recipients))})
+	for _, recipient := range p.recipients {
+		var ephemeral = make([]byte, curve25519.ScalarSize)
+		if _, err := rand.Read(ephemeral); err != nil {
+			return "", err
+		}
+		ephemeralPub, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+		if err != nil {
+			return "", err
+		}
+		wrapKey, err := x25519WrapKey(ephemeral, ephemeralPub, recipient)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(ephemeralPub)
+		buf.Write(wrapKey.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil))
+	}
+
+	aead, _ := chacha20poly1305.New(fileKey)
+	var nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, []byte(plaintext), nil))
+	return SECRET_PREFIX + SECRET_SCHEME_X25519 + ":" + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func (p *x25519Provider) Decrypt(value string) (string, error) {
+	if len(p.identities) == 0 {
+		return "", errors.New("x25519 decrypt requires identity")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, SECRET_PREFIX+SECRET_SCHEME_X25519+":"))
+	if err != nil || len(data) < 1 {
+		return "", errors.New("invalid x25519 secret")
+	}
+	const stanzaSize = 32 + chacha20poly1305.KeySize + chacha20poly1305.Overhead
+	count := int(data[0])
+	body := data[1:]
+	if len(body) < count*stanzaSize+chacha20poly1305.NonceSize {
+		return "", errors.New("invalid x25519 secret")
+	}
+
+	var fileKey []byte
+	for i := 0; i < count && fileKey == nil; i++ {
+		stanza := body[i*stanzaSize : (i+1)*stanzaSize]
+		for _, identity := range p.identities {
+			wrapKey, err := x25519WrapKey(identity, stanza[:32], nil)
+			if err != nil {
+				continue
+			}
+			if fileKey, err = wrapKey.Open(nil, make([]byte, chacha20poly1305.NonceSize), stanza[32:], nil); err == nil {
+				break
+			}
+		}
+	}
+	if fileKey == nil {
+		return "", errors.New("no identity matches x25519 secret recipients")
+	}
+	body = body[count*stanzaSize:]
+	aead, _ := chacha20poly1305.New(fileKey)
+	plaintext, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("decrypt x25519 secret error: " + err.Error())
+	}
+	return string(plaintext), nil
+}
+
+// x25519WrapKey 加密时scalar为临时私钥、peer为接收者公钥; 解密时scalar为接收者私钥、ephemeralPub为临时公钥, peer为nil
+func x25519WrapKey(scalar []byte, ephemeralPub []byte, peer []byte) (cipher.AEAD, error) {
+	var recipient = peer
+	var shared []byte
+	var err error
+	if peer == nil {
+		if recipient, err = curve25519.X25519(scalar, curve25519.Basepoint); err != nil {
+			return nil, err
+		}
+		shared, err = curve25519.X25519(scalar, ephemeralPub)
+	} else {
+		shared, err = curve25519.X25519(scalar, peer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var key = make([]byte, chacha20poly1305.KeySize)
+	salt := append(append([]byte{}, ephemeralPub...), recipient...)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("play-config-x25519")), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func decodeX25519Key(s string, prefix string) ([]byte, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errors.New("x25519 key must start with " + prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid x25519 key")
+	}
+	return key, nil
+}
+
+// readKeyLines 读取密钥文件中的非空行, 忽略#注释
+func readKeyLines(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
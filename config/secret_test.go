@@ -0,0 +1,336 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useSecretProviders 测试期间只使用给定的provider, 结束后恢复
+func useSecretProviders(t *testing.T, providers ...SecretProvider) {
+	secretMutex.Lock()
+	saved := secretProviders
+	secretProviders = make(map[string]SecretProvider)
+	secretMutex.Unlock()
+	secretCache = sync.Map{}
+	for _, p := range providers {
+		RegisterSecretProvider(p)
+	}
+	t.Cleanup(func() {
+		secretMutex.Lock()
+		secretProviders = saved
+		secretMutex.Unlock()
+		secretCache = sync.Map{}
+	})
+}
+
+func writeKeyFile(t *testing.T, lines ...string) string {
+	file := filepath.Join(t.TempDir(), "keys")
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newAESProvider(t *testing.T, keys ...string) SecretProvider {
+	p, err := NewAESSecretProvider(writeKeyFile(t, keys...))
+	if err != nil {
+		t.Fatalf("NewAESSecretProvider error: %v", err)
+	}
+	return p
+}
+
+func generateAESKey(t *testing.T) string {
+	key, err := GenerateAESKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func generateX25519(t *testing.T) (identity string, recipient string) {
+	identity, recipient, err := GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity, recipient
+}
+
+func TestAESSecretProvider(t *testing.T) {
+	oldKey, newKey := generateAESKey(t), generateAESKey(t)
+	oldProvider := newAESProvider(t, oldKey)
+	rotated := newAESProvider(t, "# primary", newKey, oldKey)
+
+	for _, plaintext := range []string{"", "hunter2", "密码 with spaces:and:colons", strings.Repeat("x", 4096)} {
+		value, err := oldProvider.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt error: %v", err)
+		}
+		if want := SECRET_PREFIX + SECRET_SCHEME_AES + ":" + AESKeyId(oldKey) + ":"; !strings.HasPrefix(value, want) {
+			t.Fatalf("Encrypt = %s, want prefix %s", value, want)
+		}
+		if again, _ := oldProvider.Encrypt(plaintext); again == value {
+			t.Errorf("Encrypt is deterministic for %q", plaintext)
+		}
+		for name, p := range map[string]SecretProvider{"same key": oldProvider, "rotated key file": rotated} {
+			if got, err := p.Decrypt(value); err != nil || got != plaintext {
+				t.Errorf("%s Decrypt = %q, %v, want %q", name, got, err, plaintext)
+			}
+		}
+	}
+
+	value, _ := rotated.Encrypt("v")
+	if want := ":" + AESKeyId(newKey) + ":"; !strings.Contains(value, want) {
+		t.Errorf("rotated Encrypt = %s, want first key id %s", value, want)
+	}
+	if _, err := oldProvider.Decrypt(value); err == nil || !strings.Contains(err.Error(), "unknown aes key id") {
+		t.Errorf("Decrypt with retired key file error = %v", err)
+	}
+}
+
+func TestAESSecretProviderDecryptError(t *testing.T) {
+	key, otherKey := generateAESKey(t), generateAESKey(t)
+	p := newAESProvider(t, key, otherKey)
+	value, _ := p.Encrypt("hunter2")
+	id := AESKeyId(key)
+	data := strings.TrimPrefix(value, SECRET_PREFIX+SECRET_SCHEME_AES+":"+id+":")
+	raw, _ := base64.RawURLEncoding.DecodeString(data)
+	raw[len(raw)-1] ^= 1
+
+	var cases = []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{"missing data", "enc:aes:" + id, "invalid aes secret"},
+		{"unknown key id", "enc:aes:zz:abc", "unknown aes key id zz"},
+		{"bad base64", "enc:aes:" + id + ":***", "invalid aes secret"},
+		{"short data", "enc:aes:" + id + ":AAAA", "invalid aes secret"},
+		{"tampered ciphertext", "enc:aes:" + id + ":" + base64.RawURLEncoding.EncodeToString(raw), "decrypt aes secret error"},
+		{"key id swapped", "enc:aes:" + AESKeyId(otherKey) + ":" + data, "decrypt aes secret error"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := p.Decrypt(c.value); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Decrypt error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestNewAESSecretProviderError(t *testing.T) {
+	var cases = []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"empty", []string{"# comment only"}, "no key found"},
+		{"not base64", []string{"not-a-key!"}, "key must be base64 encoded 32 bytes"},
+		{"short key", []string{base64.StdEncoding.EncodeToString([]byte("short"))}, "key must be base64 encoded 32 bytes"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := NewAESSecretProvider(writeKeyFile(t, c.lines...)); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("NewAESSecretProvider error = %v, want %q", err, c.err)
+			}
+		})
+	}
+	if _, err := NewAESSecretProvider(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewAESSecretProvider with missing file succeeded")
+	}
+}
+
+func TestX25519SecretProvider(t *testing.T) {
+	alice, alicePub := generateX25519(t)
+	bob, bobPub := generateX25519(t)
+	eve, _ := generateX25519(t)
+	if got, err := X25519Recipient(alice); err != nil || got != alicePub {
+		t.Fatalf("X25519Recipient = %s, %v, want %s", got, err, alicePub)
+	}
+
+	encrypter, err := NewX25519SecretProvider([]string{alicePub, bobPub}, "")
+	if err != nil {
+		t.Fatalf("NewX25519SecretProvider error: %v", err)
+	}
+	value, err := encrypter.Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt error: %v", err)
+	}
+	if !strings.HasPrefix(value, SECRET_PREFIX+SECRET_SCHEME_X25519+":") {
+		t.Fatalf("Encrypt = %s", value)
+	}
+
+	for name, identities := range map[string][]string{"alice": {alice}, "bob": {bob}, "eve then bob": {eve, bob}} {
+		p, err := NewX25519SecretProvider(nil, writeKeyFile(t, identities...))
+		if err != nil {
+			t.Fatalf("%s NewX25519SecretProvider error: %v", name, err)
+		}
+		if got, err := p.Decrypt(value); err != nil || got != "hunter2" {
+			t.Errorf("%s Decrypt = %q, %v", name, got, err)
+		}
+	}
+
+	evil, _ := NewX25519SecretProvider(nil, writeKeyFile(t, eve))
+	if _, err := evil.Decrypt(value); err == nil || !strings.Contains(err.Error(), "no identity matches") {
+		t.Errorf("Decrypt with other identity error = %v", err)
+	}
+	if _, err := encrypter.Decrypt(value); err == nil || !strings.Contains(err.Error(), "requires identity") {
+		t.Errorf("Decrypt without identity error = %v", err)
+	}
+	if _, err := evil.Encrypt("x"); err == nil || !strings.Contains(err.Error(), "requires 1-255 recipients") {
+		t.Errorf("Encrypt without recipients error = %v", err)
+	}
+
+	aliceOnly, _ := NewX25519SecretProvider(nil, writeKeyFile(t, alice))
+	raw, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(value, "enc:x25519:"))
+	tampered := append([]byte{}, raw...)
+	tampered[len(tampered)-1] ^= 1
+	var cases = []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{"bad base64", "enc:x25519:***", "invalid x25519 secret"},
+		{"empty", "enc:x25519:", "invalid x25519 secret"},
+		{"truncated", "enc:x25519:" + base64.RawURLEncoding.EncodeToString(raw[:40]), "invalid x25519 secret"},
+		{"tampered ciphertext", "enc:x25519:" + base64.RawURLEncoding.EncodeToString(tampered), "decrypt x25519 secret error"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := aliceOnly.Decrypt(c.value); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Decrypt error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestNewX25519SecretProviderError(t *testing.T) {
+	identity, recipient := generateX25519(t)
+	var cases = []struct {
+		name       string
+		recipients []string
+		identities []string
+		err        string
+	}{
+		{"nothing", nil, nil, "requires recipients or identities"},
+		{"identity as recipient", []string{identity}, nil, "must start with " + X25519_RECIPIENT_PREFIX},
+		{"recipient as identity", nil, []string{recipient}, "must start with " + X25519_IDENTITY_PREFIX},
+		{"short key", []string{X25519_RECIPIENT_PREFIX + "AAAA"}, nil, "invalid x25519 key"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var file string
+			if c.identities != nil {
+				file = writeKeyFile(t, c.identities...)
+			}
+			if _, err := NewX25519SecretProvider(c.recipients, file); err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("NewX25519SecretProvider error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestDecryptAndRotateSecret(t *testing.T) {
+	aes := newAESProvider(t, generateAESKey(t))
+	identity, recipient := generateX25519(t)
+	x25519, _ := NewX25519SecretProvider([]string{recipient}, writeKeyFile(t, identity))
+	useSecretProviders(t, aes, x25519)
+
+	value, _ := aes.Encrypt("hunter2")
+	rotated, err := RotateSecret(value, x25519)
+	if err != nil || !strings.HasPrefix(rotated, "enc:x25519:") {
+		t.Fatalf("RotateSecret = %s, %v", rotated, err)
+	}
+	if got, err := DecryptSecret(rotated); err != nil || got != "hunter2" {
+		t.Errorf("DecryptSecret = %q, %v", got, err)
+	}
+	if _, err := DecryptSecret("enc:rsa:abc"); err == nil || !strings.Contains(err.Error(), "no secret provider for scheme rsa") {
+		t.Errorf("DecryptSecret unknown scheme error = %v", err)
+	}
+
+	v, err := decryptSecrets(map[string]interface{}{"db": []interface{}{value, "plain", int64(1)}})
+	want := map[string]interface{}{"db": []interface{}{"hunter2", "plain", int64(1)}}
+	if err != nil || !reflect.DeepEqual(v, want) {
+		t.Errorf("decryptSecrets = %#v, %v", v, err)
+	}
+}
+
+func TestSecretLookup(t *testing.T) {
+	aes := newAESProvider(t, generateAESKey(t))
+	useSecretProviders(t, aes)
+	value, _ := aes.Encrypt("hunter2")
+	parser := mapParser{"db": map[string]interface{}{"pw": value, "bad": "enc:aes:zz:abc"}}
+
+	if got, err := getValFrom(parser, "db.pw"); err != nil || got != "hunter2" {
+		t.Errorf("getValFrom = %v, %v", got, err)
+	}
+	if _, err := getValFrom(parser, "db.bad"); err == nil || !strings.Contains(err.Error(), "db.bad: unknown aes key id zz") {
+		t.Errorf("getValFrom undecryptable error = %v", err)
+	}
+	if _, err := getValFrom(parser, "db.missing"); !errors.Is(err, ErrKeyNotExist) {
+		t.Errorf("getValFrom missing error = %v, want ErrKeyNotExist", err)
+	}
+
+	var cases = []struct {
+		name string
+		v    interface{}
+		err  string
+		want string
+	}{
+		{"decrypted", &struct {
+			Pw string `key:"pw" required:"true"`
+		}{}, "", "hunter2"},
+		{"missing uses default", &struct {
+			Pw string `key:"missing" default:"fallback"`
+		}{}, "", "fallback"},
+		{"missing required", &struct {
+			Pw string `key:"missing" required:"true"`
+		}{}, "db.missing is required", ""},
+		{"undecryptable ignores default", &struct {
+			Pw string `key:"bad" default:"fallback"`
+		}{}, "unknown aes key id zz", ""},
+		{"undecryptable required", &struct {
+			Pw string `key:"bad" required:"true"`
+		}{}, "unknown aes key id zz", ""},
+		{"undecryptable optional", &struct {
+			Pw string `key:"bad"`
+		}{}, "unknown aes key id zz", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := UnmarshalFrom(parser, "db", c.v)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("UnmarshalFrom error = %v, want %q", err, c.err)
+				}
+				return
+			}
+			if got := reflect.ValueOf(c.v).Elem().Field(0).String(); err != nil || got != c.want {
+				t.Errorf("UnmarshalFrom = %q, %v, want %q", got, err, c.want)
+			}
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	got := redactSecrets("", map[string]interface{}{
+		"db":    map[string]interface{}{"password": "plain", "user": "u", "dsn": "enc:aes:id:x"},
+		"token": int64(123),
+		"list":  []interface{}{"enc:x25519:abc", "ok"},
+		"empty": nil,
+	})
+	want := map[string]interface{}{
+		"db":    map[string]interface{}{"password": "******", "user": "u", "dsn": "******"},
+		"token": "******",
+		"list":  []interface{}{"******", "ok"},
+		"empty": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactSecrets = %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,466 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlDecoder current为当前[table]或[[array]]对应的对象, path为其路径;
+// defined记录已定义的table, 同一table不允许定义两次
+type tomlDecoder struct {
+	s       string
+	i       int
+	root    map[string]interface{}
+	current map[string]interface{}
+	path    []string
+	defined map[string]bool
+}
+
+func decodeToml(data []byte) (map[string]interface{}, error) {
+	var d = &tomlDecoder{s: strings.ReplaceAll(string(data), "\r\n", "\n"), root: make(map[string]interface{}), defined: make(map[string]bool)}
+	d.current = d.root
+	if err := d.parse(); err != nil {
+		return nil, fmt.Errorf("toml line %d: %v", strings.Count(d.s[:d.i], "\n")+1, err)
+	}
+	return d.root, nil
+}
+
+func (d *tomlDecoder) parse() error {
+	for {
+		d.skipBlank(true)
+		if d.i >= len(d.s) {
+			return nil
+		}
+		var err error
+		if strings.HasPrefix(d.s[d.i:], "[[") {
+			d.i += 2
+			err = d.parseArrayTable()
+		} else if d.s[d.i] == '[' {
+			d.i++
+			err = d.parseTable()
+		} else {
+			err = d.parseKeyValue(d.current, true)
+		}
+		if err != nil {
+			return err
+		}
+		if d.skipBlank(false); d.i < len(d.s) && d.s[d.i] != '\n' {
+			return fmt.Errorf("unexpected %q", d.rest())
+		}
+	}
+}
+
+func (d *tomlDecoder) parseTable() error {
+	keys, err := d.parseKey()
+	if err != nil {
+		return err
+	}
+	if d.skipBlank(false); d.i >= len(d.s) || d.s[d.i] != ']' {
+		return fmt.Errorf("expected ']'")
+	}
+	d.i++
+	var name = tomlPath(keys)
+	if d.defined[name] {
+		return fmt.Errorf("duplicate table [%s]", strings.Join(keys, "."))
+	}
+	parent, err := tomlTable(d.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	if _, ok := parent[keys[len(keys)-1]].([]interface{}); ok {
+		return fmt.Errorf("key %s is an array of tables", strings.Join(keys, "."))
+	}
+	if d.current, err = tomlTable(parent, keys[len(keys)-1:]); err != nil {
+		return err
+	}
+	d.defined[name], d.path = true, keys
+	return nil
+}
+
+func (d *tomlDecoder) parseArrayTable() error {
+	keys, err := d.parseKey()
+	if err != nil {
+		return err
+	}
+	if d.skipBlank(false); !strings.HasPrefix(d.s[d.i:], "]]") {
+		return fmt.Errorf("expected ']]'")
+	}
+	d.i += 2
+	parent, err := tomlTable(d.root, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	var last = keys[len(keys)-1]
+	var list []interface{}
+	switch v := parent[last].(type) {
+	case nil:
+	case []interface{}:
+		list = v
+	default:
+		return fmt.Errorf("key %s is not an array of tables", strings.Join(keys, "."))
+	}
+	d.current = make(map[string]interface{})
+	parent[last] = append(list, d.current)
+
+	// 新的数组元素中可重新定义子table
+	var name = tomlPath(keys)
+	for k := range d.defined {
+		if k == name || strings.HasPrefix(k, name+"\x00") {
+			delete(d.defined, k)
+		}
+	}
+	d.path = keys
+	return nil
+}
+
+// parseKeyValue top为false时table为内联table
+func (d *tomlDecoder) parseKeyValue(table map[string]interface{}, top bool) error {
+	keys, err := d.parseKey()
+	if err != nil {
+		return err
+	}
+	if d.skipBlank(false); d.i >= len(d.s) || d.s[d.i] != '=' {
+		return fmt.Errorf("expected '=' after key %s", strings.Join(keys, "."))
+	}
+	d.i++
+	v, err := d.parseValue()
+	if err != nil {
+		return err
+	}
+	if table, err = tomlTable(table, keys[:len(keys)-1]); err != nil {
+		return err
+	}
+	if _, ok := table[keys[len(keys)-1]]; ok {
+		return fmt.Errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+	table[keys[len(keys)-1]] = v
+	if _, ok := v.(map[string]interface{}); ok && top {
+		// 内联table不能再以[table]扩展
+		d.defined[tomlPath(append(append([]string{}, d.path...), keys...))] = true
+	}
+	return nil
+}
+
+// tomlPath table的唯一标识, 以\x00分隔避免与带点的引号key混淆
+func tomlPath(keys []string) string {
+	return strings.Join(keys, "\x00")
+}
+
+// tomlTable 按路径查找或创建对象, 路径中的数组取最后一个元素
+func tomlTable(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for idx, k := range keys {
+		switch v := table[k].(type) {
+		case nil:
+			sub := make(map[string]interface{})
+			table[k], table = sub, sub
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			if len(v) == 0 {
+				return nil, fmt.Errorf("key %s is not a table", strings.Join(keys[:idx+1], "."))
+			}
+			sub, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %s is not a table", strings.Join(keys[:idx+1], "."))
+			}
+			table = sub
+		default:
+			return nil, fmt.Errorf("key %s is not a table", strings.Join(keys[:idx+1], "."))
+		}
+	}
+	return table, nil
+}
+
+// parseKey 解析a.b."c"形式的key
+func (d *tomlDecoder) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		d.skipBlank(false)
+		if d.i >= len(d.s) {
+			return nil, fmt.Errorf("expected key")
+		}
+		var key string
+		switch d.s[d.i] {
+		case '"':
+			v, err := d.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = v
+		case '\'':
+			v, err := d.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = v
+		default:
+			start := d.i
+			for d.i < len(d.s) && isTomlBareKey(d.s[d.i]) {
+				d.i++
+			}
+			if start == d.i {
+				return nil, fmt.Errorf("invalid key at %q", d.rest())
+			}
+			key = d.s[start:d.i]
+		}
+		keys = append(keys, key)
+		if d.skipBlank(false); d.i >= len(d.s) || d.s[d.i] != '.' {
+			return keys, nil
+		}
+		d.i++
+	}
+}
+
+func (d *tomlDecoder) parseValue() (interface{}, error) {
+	if d.skipBlank(false); d.i >= len(d.s) {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch {
+	case strings.HasPrefix(d.s[d.i:], `"""`):
+		return d.parseMultilineString(`"""`)
+	case strings.HasPrefix(d.s[d.i:], "'''"):
+		return d.parseMultilineString("'''")
+	case d.s[d.i] == '"':
+		return d.parseBasicString()
+	case d.s[d.i] == '\'':
+		return d.parseLiteralString()
+	case d.s[d.i] == '[':
+		return d.parseArray()
+	case d.s[d.i] == '{':
+		return d.parseInlineTable()
+	}
+
+	start := d.i
+	for d.i < len(d.s) && strings.IndexByte(" \t\n,]}#", d.s[d.i]) < 0 {
+		d.i++
+	}
+	// 以空格分隔日期与时间的datetime
+	if d.i-start == 10 && d.i+1 < len(d.s) && d.s[d.i] == ' ' && d.s[d.i+1] >= '0' && d.s[d.i+1] <= '9' && d.s[start+4] == '-' {
+		for d.i++; d.i < len(d.s) && strings.IndexByte(" \t\n,]}#", d.s[d.i]) < 0; d.i++ {
+		}
+	}
+	return resolveTomlScalar(d.s[start:d.i])
+}
+
+func resolveTomlScalar(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	case "":
+		return nil, fmt.Errorf("expected value")
+	}
+	// 日期时间
+	if len(s) >= 8 && (s[2] == ':' || (len(s) >= 10 && s[4] == '-' && s[7] == '-')) {
+		return s, nil
+	}
+	num := strings.ReplaceAll(s, "_", "")
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if strings.HasPrefix(num, prefix) {
+			v, err := strconv.ParseInt(num[2:], base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %s", s)
+			}
+			return v, nil
+		}
+	}
+	if v, err := strconv.ParseInt(num, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(num, 64); err == nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+func (d *tomlDecoder) parseArray() (interface{}, error) {
+	var list = make([]interface{}, 0)
+	d.i++
+	for {
+		if d.skipBlank(true); d.i >= len(d.s) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if d.s[d.i] == ']' {
+			d.i++
+			return list, nil
+		}
+		v, err := d.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		if d.skipBlank(true); d.i < len(d.s) && d.s[d.i] == ',' {
+			d.i++
+		} else if d.i >= len(d.s) || d.s[d.i] != ']' {
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (d *tomlDecoder) parseInlineTable() (interface{}, error) {
+	var table = make(map[string]interface{})
+	d.i++
+	for {
+		if d.skipBlank(false); d.i >= len(d.s) {
+			return nil, fmt.Errorf("unterminated inline table")
+		}
+		if d.s[d.i] == '}' {
+			d.i++
+			return table, nil
+		}
+		if err := d.parseKeyValue(table, false); err != nil {
+			return nil, err
+		}
+		if d.skipBlank(false); d.i < len(d.s) && d.s[d.i] == ',' {
+			d.i++
+		} else if d.i >= len(d.s) || d.s[d.i] != '}' {
+			return nil, fmt.Errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+func (d *tomlDecoder) parseBasicString() (string, error) {
+	var buf strings.Builder
+	for d.i++; d.i < len(d.s); d.i++ {
+		switch c := d.s[d.i]; c {
+		case '"':
+			d.i++
+			return buf.String(), nil
+		case '\n':
+			return "", fmt.Errorf("unterminated string")
+		case '\\':
+			if err := d.parseEscape(&buf); err != nil {
+				return "", err
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (d *tomlDecoder) parseLiteralString() (string, error) {
+	end := strings.IndexAny(d.s[d.i+1:], "'\n")
+	if end < 0 || d.s[d.i+1+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	v := d.s[d.i+1 : d.i+1+end]
+	d.i += end + 2
+	return v, nil
+}
+
+// parseMultilineString 去除开头的换行, 基本字符串中行尾的\去除换行及下一行开头的空白
+func (d *tomlDecoder) parseMultilineString(delim string) (string, error) {
+	d.i += 3
+	if d.i < len(d.s) && d.s[d.i] == '\n' {
+		d.i++
+	}
+	var buf strings.Builder
+	for d.i < len(d.s) {
+		if strings.HasPrefix(d.s[d.i:], delim) {
+			// 结束符前最多可有两个引号属于内容
+			for n := 0; n < 2 && strings.HasPrefix(d.s[d.i+1:], delim); n++ {
+				buf.WriteByte(d.s[d.i])
+				d.i++
+			}
+			d.i += 3
+			return buf.String(), nil
+		}
+		c := d.s[d.i]
+		if c == '\\' && delim == `"""` {
+			if rest := strings.TrimLeft(d.s[d.i+1:], " \t"); strings.HasPrefix(rest, "\n") {
+				d.i = len(d.s) - len(strings.TrimLeft(rest, " \t\n"))
+				continue
+			}
+			if err := d.parseEscape(&buf); err != nil {
+				return "", err
+			}
+			d.i++
+			continue
+		}
+		buf.WriteByte(c)
+		d.i++
+	}
+	return "", fmt.Errorf("unterminated multi-line string")
+}
+
+// parseEscape d.i指向\, 结束时指向转义序列的最后一个字符
+func (d *tomlDecoder) parseEscape(buf *strings.Builder) error {
+	if d.i+1 >= len(d.s) {
+		return fmt.Errorf("invalid escape")
+	}
+	d.i++
+	switch c := d.s[d.i]; c {
+	case 'b':
+		buf.WriteByte('\b')
+	case 't':
+		buf.WriteByte('\t')
+	case 'n':
+		buf.WriteByte('\n')
+	case 'f':
+		buf.WriteByte('\f')
+	case 'r':
+		buf.WriteByte('\r')
+	case '"', '\\':
+		buf.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if d.i+size >= len(d.s) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(d.s[d.i+1:d.i+1+size], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+		buf.WriteRune(rune(code))
+		d.i += size
+	default:
+		return fmt.Errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+// skipBlank 跳过空白及注释, newline为true时同时跳过换行
+func (d *tomlDecoder) skipBlank(newline bool) {
+	for d.i < len(d.s) {
+		switch d.s[d.i] {
+		case ' ', '\t':
+			d.i++
+		case '\n':
+			if !newline {
+				return
+			}
+			d.i++
+		case '#':
+			for d.i < len(d.s) && d.s[d.i] != '\n' {
+				d.i++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (d *tomlDecoder) rest() string {
+	if end := strings.IndexByte(d.s[d.i:], '\n'); end >= 0 {
+		return d.s[d.i : d.i+end]
+	}
+	return d.s[d.i:]
+}
+
+func isTomlBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type m = map[string]interface{}
+type l = []interface{}
+
+func TestDecodeToml(t *testing.T) {
+	var cases = []struct {
+		name string
+		in   string
+		want m
+	}{
+		{"scalars", "a = 1\nb = -2.5\nc = true\nd = \"x\\ty\"\ne = 'C:\\path'\nf = 0x1f\ng = 1_000\n",
+			m{"a": int64(1), "b": -2.5, "c": true, "d": "x\ty", "e": `C:\path`, "f": int64(31), "g": int64(1000)}},
+		{"comments", "# head\na = 1 # tail\nb = \"# not comment\"\n",
+			m{"a": int64(1), "b": "# not comment"}},
+		{"dotted and quoted keys", "a.b = 1\n\"x.y\" = 2\nsite.\"google.com\" = true\n",
+			m{"a": m{"b": int64(1)}, "x.y": int64(2), "site": m{"google.com": true}}},
+		{"tables", "[server]\nhost = \"h\"\n[server.tls]\nenable = true\n[db]\nport = 3306\n",
+			m{"server": m{"host": "h", "tls": m{"enable": true}}, "db": m{"port": int64(3306)}}},
+		{"implicit parent defined later", "[a.b]\nx = 1\n[a]\ny = 2\n",
+			m{"a": m{"b": m{"x": int64(1)}, "y": int64(2)}}},
+		{"multiline basic string", "s = \"\"\"\nline1\nline2 \\\n   joined\"\"\"\n",
+			m{"s": "line1\nline2 joined"}},
+		{"multiline literal string", "s = '''\nraw \\n\n  kept'''\n",
+			m{"s": "raw \\n\n  kept"}},
+		{"nested arrays", "a = [[1, 2], [\"x\", [true]]]\nb = [\n  1,\n  2, # c\n]\n",
+			m{"a": l{l{int64(1), int64(2)}, l{"x", l{true}}}, "b": l{int64(1), int64(2)}}},
+		{"inline tables", "p = { x = 1, y = { z = \"w\" } }\n",
+			m{"p": m{"x": int64(1), "y": m{"z": "w"}}}},
+		{"array of tables", "[[svc]]\nname = \"a\"\n[svc.opt]\nv = 1\n[[svc]]\nname = \"b\"\n[svc.opt]\nv = 2\n",
+			m{"svc": l{m{"name": "a", "opt": m{"v": int64(1)}}, m{"name": "b", "opt": m{"v": int64(2)}}}}},
+		{"datetime kept as string", "t = 1979-05-27T07:32:00Z\nd = 1979-05-27 07:32:00\n",
+			m{"t": "1979-05-27T07:32:00Z", "d": "1979-05-27 07:32:00"}},
+		{"crlf", "a = 1\r\n[b]\r\nc = 2\r\n",
+			m{"a": int64(1), "b": m{"c": int64(2)}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToml([]byte(c.in))
+			if err != nil {
+				t.Fatalf("decodeToml error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("decodeToml = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTomlError(t *testing.T) {
+	var cases = []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"duplicate table", "[t]\na = 1\n[t]\nb = 2\n", "line 3: duplicate table [t]"},
+		{"duplicate nested table", "[a.b]\nx = 1\n[a]\n[a.b]\n", "duplicate table [a.b]"},
+		{"duplicate table in same array element", "[[s]]\n[s.o]\n[s.o]\n", "duplicate table [s.o]"},
+		{"table extends inline table", "p = { x = 1 }\n[p]\ny = 2\n", "duplicate table [p]"},
+		{"table over array of tables", "[[s]]\n[s]\n", "key s is an array of tables"},
+		{"array of tables over table", "[s]\n[[s]]\n", "key s is not an array of tables"},
+		{"duplicate key", "a = 1\na = 2\n", "line 2: duplicate key a"},
+		{"key over value", "a = 1\n[a]\n", "key a is not a table"},
+		{"missing value", "a =\n", "expected value"},
+		{"missing equals", "a 1\n", "expected '=' after key a"},
+		{"unterminated string", "a = \"x\n", "line 1"},
+		{"unterminated array", "a = [1, 2\n", "line 2"},
+		{"trailing garbage", "a = 1 b\n", "unexpected"},
+		{"unclosed header", "[a\n", "expected ']'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeToml([]byte(c.in))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("decodeToml error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
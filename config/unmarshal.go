@@ -0,0 +1,321 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leochen2038/play"
+)
+
+// getter 按相对于当前对象的dotted key读取值
+type getter func(key string) (interface{}, error)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal 将key对应的配置绑定到结构体指针, key为空时从根读取; 字段逐个按key读取, 因此LayeredParser中的环境变量可覆盖单个字段. 字段tag:
+//
+//	key      配置名, 默认为字段名, "-"时忽略
+//	default  配置不存在时的默认值; 未设置default且配置不存在时保留字段原值
+//	required "true"时配置必须存在
+//	regex    字符串需匹配的正则
+//	min,max  数值的范围, 字符串、slice及map的长度范围
+//	enum     以逗号分隔的可选值
+//	layout   time.Time的格式, 默认RFC3339
+//
+// time.Duration可使用"10s"格式, slice可使用逗号分隔的字符串; 所有字段从同一快照读取
+func Unmarshal(key string, v interface{}) error {
+	return UnmarshalFrom(Snapshot(), key, v)
+}
+
+// UnmarshalFrom 从指定配置源绑定, 如在AddValidator中校验新配置
+func UnmarshalFrom(parser Parser, key string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("config: unmarshal requires a non-nil struct pointer")
+	}
+	return decodeStruct(key, func(k string) (interface{}, error) {
+		if key != "" {
+			k = key + "." + k
+		}
+		return getValFrom(parser, k)
+	}, rv.Elem())
+}
+
+func decodeStruct(path string, get getter, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		tField, vField := rt.Field(i), rv.Field(i)
+		if tField.PkgPath != "" && !tField.Anonymous {
+			continue
+		}
+		name := tField.Tag.Get("key")
+		if name == "-" {
+			continue
+		}
+		// 未设置key的内嵌结构体字段视为当前对象的字段
+		if name == "" && tField.Anonymous && tField.Type.Kind() == reflect.Struct {
+			if err := decodeStruct(path, get, vField); err != nil {
+				return err
+			}
+			continue
+		}
+		if tField.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = tField.Name
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		if tField.Type.Kind() == reflect.Struct && tField.Type != timeType {
+			var fieldName = name
+			if err := decodeStruct(fieldPath, func(k string) (interface{}, error) {
+				return get(fieldName + "." + k)
+			}, vField); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, err := get(name)
+		if err != nil && !errors.Is(err, ErrKeyNotExist) {
+			return errors.New("config: " + err.Error())
+		}
+		if val == nil {
+			if def, ok := tField.Tag.Lookup("default"); ok {
+				val = def
+			} else if tField.Tag.Get("required") == "true" {
+				return errors.New("config: " + fieldPath + " is required")
+			} else {
+				continue
+			}
+		}
+		if err = setValue(fieldPath, vField, tField, val); err != nil {
+			return err
+		}
+		if err = validate(fieldPath, vField, tField); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setValue(path string, rv reflect.Value, tField reflect.StructField, val interface{}) (err error) {
+	if val == nil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	switch rv.Kind() {
+	case reflect.Interface:
+		rv.Set(reflect.ValueOf(val))
+		return nil
+	case reflect.Ptr:
+		elem := reflect.New(rv.Type().Elem())
+		if err = setValue(path, elem.Elem(), tField, val); err == nil {
+			rv.Set(elem)
+		}
+		return err
+	case reflect.String:
+		var s string
+		if s, err = play.ParseString(val); err == nil {
+			rv.SetString(s)
+		}
+	case reflect.Bool:
+		var b bool
+		if b, err = play.ParseBool(val); err == nil {
+			rv.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if s, ok := val.(string); ok && rv.Type() == durationType {
+			var d time.Duration
+			d, err = time.ParseDuration(s)
+			n = int64(d)
+		} else {
+			n, err = parseInt64(val)
+		}
+		if err == nil {
+			if rv.OverflowInt(n) {
+				return errors.New("config: " + path + " value " + strconv.FormatInt(n, 10) + " overflows " + rv.Type().String())
+			}
+			rv.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n int64
+		if n, err = parseInt64(val); err == nil {
+			if n < 0 || rv.OverflowUint(uint64(n)) {
+				return errors.New("config: " + path + " value " + strconv.FormatInt(n, 10) + " overflows " + rv.Type().String())
+			}
+			rv.SetUint(uint64(n))
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = play.ParseFloat64(val); err == nil {
+			rv.SetFloat(f)
+		}
+	case reflect.Slice:
+		var list []interface{}
+		switch v := val.(type) {
+		case []interface{}:
+			list = v
+		case string:
+			// 环境变量等以逗号分隔
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		default:
+			return errors.New("config: " + path + " can not convert " + reflect.TypeOf(val).String() + " to " + rv.Type().String())
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(list), len(list))
+		for idx, item := range list {
+			if err = setValue(path+"."+strconv.Itoa(idx), slice.Index(idx), tField, item); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Map:
+		m, ok := val.(map[string]interface{})
+		if !ok || rv.Type().Key().Kind() != reflect.String {
+			return errors.New("config: " + path + " can not convert " + reflect.TypeOf(val).String() + " to " + rv.Type().String())
+		}
+		result := reflect.MakeMapWithSize(rv.Type(), len(m))
+		for k, item := range m {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			if err = setValue(path+"."+k, elem, tField, item); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()), elem)
+		}
+		rv.Set(result)
+	case reflect.Struct:
+		if rv.Type() == timeType {
+			var s string
+			if s, err = play.ParseString(val); err == nil {
+				layout := tField.Tag.Get("layout")
+				if layout == "" {
+					layout = time.RFC3339
+				}
+				var t time.Time
+				if t, err = time.ParseInLocation(layout, s, time.Local); err == nil {
+					rv.Set(reflect.ValueOf(t))
+				}
+			}
+			break
+		}
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return errors.New("config: " + path + " can not convert " + reflect.TypeOf(val).String() + " to " + rv.Type().String())
+		}
+		return decodeStruct(path, func(k string) (interface{}, error) {
+			return lookup(m, k)
+		}, rv)
+	default:
+		return errors.New("config: " + path + " unsupported type " + rv.Type().String())
+	}
+	if err != nil {
+		return errors.New("config: " + path + " " + err.Error())
+	}
+	return nil
+}
+
+// parseInt64 拒绝带小数的浮点数, 避免静默截断
+func parseInt64(val interface{}) (int64, error) {
+	switch v := val.(type) {
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, errors.New("can not convert " + strconv.FormatFloat(v, 'f', -1, 64) + " to int")
+		}
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	}
+	return play.ParseInt64(val)
+}
+
+func validate(path string, rv reflect.Value, tField reflect.StructField) error {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if pattern := tField.Tag.Get("regex"); pattern != "" && rv.Kind() == reflect.String {
+		if match, err := regexp.MatchString(pattern, rv.String()); err != nil || !match {
+			return errors.New("config: " + path + " mismatch " + pattern)
+		}
+	}
+	if enum := tField.Tag.Get("enum"); enum != "" {
+		var s, ok = toString(rv), false
+		for _, item := range strings.Split(enum, ",") {
+			if strings.TrimSpace(item) == s {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return errors.New("config: " + path + " must be one of " + enum)
+		}
+	}
+
+	var size float64
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		size = float64(rv.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = rv.Float()
+	default:
+		return nil
+	}
+	if rv.Type() == durationType {
+		return validateRange(path, tField, size, func(s string) (float64, error) {
+			d, err := time.ParseDuration(s)
+			return float64(d), err
+		})
+	}
+	return validateRange(path, tField, size, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+func validateRange(path string, tField reflect.StructField, size float64, parse func(s string) (float64, error)) error {
+	if min := tField.Tag.Get("min"); min != "" {
+		if n, err := parse(min); err != nil || size < n {
+			return errors.New("config: " + path + " must be at least " + min)
+		}
+	}
+	if max := tField.Tag.Get("max"); max != "" {
+		if n, err := parse(max); err != nil || size > n {
+			return errors.New("config: " + path + " must be at most " + max)
+		}
+	}
+	return nil
+}
+
+func toString(rv reflect.Value) string {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	}
+	return rv.String()
+}
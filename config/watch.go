@@ -0,0 +1,201 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Change 配置变更, Keys为Key下发生变化的叶子节点; Key为空时表示任意变更, Old及New为nil
+type Change struct {
+	Key  string
+	Old  interface{}
+	New  interface{}
+	Keys []string
+}
+
+type subscription struct {
+	key      string
+	callback func(c Change)
+}
+
+// reloadable 可热更新的配置源
+type reloadable interface {
+	Parser
+	Name() string
+	Data() map[string]interface{}
+	snapshot() Parser
+	onReload(hook reloadHook)
+}
+
+var (
+	watchMutex    sync.Mutex
+	subscriptions = make(map[*subscription]struct{})
+	validators    []func(next Parser) error
+)
+
+// OnChange 订阅key对应的配置变更, 合并后的值发生变化时回调; key为空时订阅任意变更. 返回取消订阅的函数
+func OnChange(key string, callback func(c Change)) (cancel func()) {
+	var sub = &subscription{key: key, callback: callback}
+	watchMutex.Lock()
+	subscriptions[sub] = struct{}{}
+	watchMutex.Unlock()
+	return func() {
+		watchMutex.Lock()
+		delete(subscriptions, sub)
+		watchMutex.Unlock()
+	}
+}
+
+// AddValidator 注册新配置的校验, 任一校验失败时拒绝本次重新加载并保留原配置
+func AddValidator(validator func(next Parser) error) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+	validators = append(validators, validator)
+}
+
+// Snapshot 当前配置的不可变快照, 用于一致地读取多个key
+func Snapshot() Parser {
+	return snapshotWith(currentParser(), nil, nil)
+}
+
+// snapshotWith 生成快照, layer不为nil时以next替换该配置源
+func snapshotWith(parser Parser, layer Parser, next Parser) Parser {
+	if layer != nil && parser == layer {
+		return next
+	}
+	switch p := parser.(type) {
+	case *LayeredParser:
+		var list = make([]Parser, len(p.parsers))
+		for idx, sub := range p.parsers {
+			list[idx] = snapshotWith(sub, layer, next)
+		}
+		return &LayeredParser{parsers: list}
+	case reloadable:
+		return p.snapshot()
+	}
+	return parser
+}
+
+// watchReload 为配置源中可热更新的文件注册校验及通知
+func watchReload(parser Parser) {
+	switch p := parser.(type) {
+	case *LayeredParser:
+		for _, sub := range p.parsers {
+			watchReload(sub)
+		}
+	case reloadable:
+		p.onReload(func(next Parser, commit func()) error {
+			return applyReload(p, next, commit)
+		})
+	}
+}
+
+func applyReload(layer reloadable, next Parser, commit func()) error {
+	var notify []func()
+	err := func() error {
+		watchMutex.Lock()
+		defer watchMutex.Unlock()
+
+		root := currentParser()
+		if !containsParser(root, layer) {
+			// 配置源已不在使用中
+			commit()
+			return nil
+		}
+		oldView, newView := snapshotWith(root, nil, nil), snapshotWith(root, layer, next)
+		for _, validator := range validators {
+			if err := validator(newView); err != nil {
+				return errors.New("config " + layer.Name() + " rejected: " + err.Error())
+			}
+		}
+
+		var changed = diffKeys("", layer.Data(), map[string]interface{}(next.(mapParser)))
+		commit()
+		if len(changed) == 0 {
+			return nil
+		}
+		fmt.Println("config", layer.Name(), "reloaded, changed keys:", strings.Join(changed, ","))
+
+		for sub := range subscriptions {
+			if c, ok := subscriptionChange(sub.key, changed, oldView, newView); ok {
+				var callback = sub.callback
+				notify = append(notify, func() { callback(c) })
+			}
+		}
+		return nil
+	}()
+
+	for _, f := range notify {
+		func() {
+			defer func() {
+				if panicInfo := recover(); panicInfo != nil {
+					fmt.Printf("config change callback panic: %v\n%s", panicInfo, debug.Stack())
+				}
+			}()
+			f()
+		}()
+	}
+	return err
+}
+
+func containsParser(parser Parser, layer Parser) bool {
+	if parser == layer {
+		return true
+	}
+	if p, ok := parser.(*LayeredParser); ok {
+		for _, sub := range p.parsers {
+			if containsParser(sub, layer) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// subscriptionChange 被更高优先级配置源覆盖而合并结果未变化的key不通知
+func subscriptionChange(key string, changed []string, oldView Parser, newView Parser) (Change, bool) {
+	var c = Change{Key: key}
+	for _, k := range changed {
+		if key == "" || k == key || strings.HasPrefix(k, key+".") || strings.HasPrefix(key, k+".") {
+			c.Keys = append(c.Keys, k)
+		}
+	}
+	if len(c.Keys) == 0 {
+		return c, false
+	}
+	if key == "" {
+		return c, true
+	}
+	c.Old, _ = getValFrom(oldView, key)
+	c.New, _ = getValFrom(newView, key)
+	return c, !reflect.DeepEqual(c.Old, c.New)
+}
+
+// diffKeys 返回两个对象间发生变化的叶子节点, 按key排序
+func diffKeys(prefix string, old map[string]interface{}, new map[string]interface{}) []string {
+	var keys []string
+	for k, v := range new {
+		path := prefix + k
+		oldV, ok := old[k]
+		oldM, oldIsMap := oldV.(map[string]interface{})
+		newM, newIsMap := v.(map[string]interface{})
+		switch {
+		case ok && oldIsMap && newIsMap:
+			keys = append(keys, diffKeys(path+".", oldM, newM)...)
+		case !ok || !reflect.DeepEqual(oldV, v):
+			keys = append(keys, path)
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			keys = append(keys, prefix+k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
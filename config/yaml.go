@@ -0,0 +1,450 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	yamlIntRe   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloatRe = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlDecoder 按缩进逐行解析, override为"- key: value"中拆出的虚拟行
+type yamlDecoder struct {
+	raw      []string
+	pos      int
+	override *yamlLine
+}
+
+func decodeYaml(data []byte) (map[string]interface{}, error) {
+	var d = &yamlDecoder{raw: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+	line, ok, err := d.peek()
+	if err != nil || !ok {
+		return map[string]interface{}{}, err
+	}
+	v, err := d.parseBlock(line.indent)
+	if err != nil {
+		return nil, err
+	}
+	if line, ok, err = d.peek(); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", line.num)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("yaml root must be a mapping")
+	}
+	return m, nil
+}
+
+// peek 跳过空行、注释及文档分隔符, 返回下一行但不前进
+func (d *yamlDecoder) peek() (yamlLine, bool, error) {
+	if d.override != nil {
+		return *d.override, true, nil
+	}
+	for ; d.pos < len(d.raw); d.pos++ {
+		raw := d.raw[d.pos]
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return yamlLine{}, false, fmt.Errorf("yaml line %d: tab indentation is not allowed", d.pos+1)
+		}
+		indent := len(raw) - len(text)
+		if text = stripYamlComment(text); text == "" || (indent == 0 && (text == "---" || text == "...")) {
+			continue
+		}
+		return yamlLine{num: d.pos + 1, indent: indent, text: text}, true, nil
+	}
+	return yamlLine{}, false, nil
+}
+
+func (d *yamlDecoder) consume() {
+	if d.override != nil {
+		d.override = nil
+	} else {
+		d.pos++
+	}
+}
+
+func (d *yamlDecoder) parseBlock(indent int) (interface{}, error) {
+	line, _, _ := d.peek()
+	if isYamlSeqItem(line.text) {
+		return d.parseSeq(indent)
+	}
+	return d.parseMap(indent)
+}
+
+func (d *yamlDecoder) parseSeq(indent int) (interface{}, error) {
+	var list = make([]interface{}, 0)
+	for {
+		line, ok, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent || (line.indent == indent && !isYamlSeqItem(line.text)) {
+			return list, nil
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml line %d: unexpected indentation", line.num)
+		}
+		d.consume()
+
+		var v interface{}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			if v, err = d.parseNested(indent, false); err != nil {
+				return nil, err
+			}
+		} else if _, _, isMap := splitYamlEntry(rest); isMap || isYamlSeqItem(rest) {
+			col := line.indent + len(line.text) - len(rest)
+			d.override = &yamlLine{num: line.num, indent: col, text: rest}
+			if v, err = d.parseBlock(col); err != nil {
+				return nil, err
+			}
+		} else if v, err = d.parseValue(rest, line); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+func (d *yamlDecoder) parseMap(indent int) (interface{}, error) {
+	var m = make(map[string]interface{})
+	for {
+		line, ok, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent {
+			return m, nil
+		}
+		if line.indent > indent || isYamlSeqItem(line.text) {
+			return nil, fmt.Errorf("yaml line %d: unexpected indentation", line.num)
+		}
+		key, rest, isMap := splitYamlEntry(line.text)
+		if !isMap {
+			return nil, fmt.Errorf("yaml line %d: expected key: value", line.num)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("yaml line %d: duplicate key %s", line.num, key)
+		}
+		d.consume()
+
+		var v interface{}
+		if rest == "" {
+			v, err = d.parseNested(indent, true)
+		} else {
+			v, err = d.parseValue(rest, line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+// parseNested 值在后续缩进更深的行中, 对象的值为数组时可与key同缩进
+func (d *yamlDecoder) parseNested(indent int, inMap bool) (interface{}, error) {
+	next, ok, err := d.peek()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if next.indent > indent || (inMap && next.indent == indent && isYamlSeqItem(next.text)) {
+		return d.parseBlock(next.indent)
+	}
+	return nil, nil
+}
+
+func (d *yamlDecoder) parseValue(s string, line yamlLine) (interface{}, error) {
+	switch s[0] {
+	case '|', '>':
+		return d.parseBlockScalar(s, line)
+	case '[', '{':
+		var p = &yamlFlow{s: s}
+		v, err := p.parse()
+		if err == nil {
+			if p.skipSpace(); p.i < len(p.s) {
+				err = fmt.Errorf("unexpected %q", p.s[p.i:])
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: %v", line.num, err)
+		}
+		return v, nil
+	case '"', '\'':
+		v, rest, err := unquoteYaml(s)
+		if err == nil && strings.TrimSpace(rest) != "" {
+			err = fmt.Errorf("unexpected %q", rest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: %v", line.num, err)
+		}
+		return v, nil
+	case '&', '*', '!':
+		return nil, fmt.Errorf("yaml line %d: anchors, aliases and tags are not supported", line.num)
+	}
+	return resolveYamlScalar(s), nil
+}
+
+// parseBlockScalar |保留换行, >折叠为空格; -去除末尾换行, +保留全部末尾换行
+func (d *yamlDecoder) parseBlockScalar(header string, line yamlLine) (interface{}, error) {
+	var folded, chomp = header[0] == '>', byte(0)
+	for _, c := range header[1:] {
+		switch c {
+		case '-', '+':
+			chomp = byte(c)
+		default:
+			return nil, fmt.Errorf("yaml line %d: unsupported block scalar header %s", line.num, header)
+		}
+	}
+
+	var lines []string
+	var blockIndent = -1
+	for ; d.pos < len(d.raw); d.pos++ {
+		raw := strings.TrimRight(d.raw[d.pos], " \r")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		indent := len(raw) - len(text)
+		if blockIndent < 0 {
+			blockIndent = indent
+		}
+		if indent < blockIndent || indent <= line.indent {
+			break
+		}
+		lines = append(lines, raw[blockIndent:])
+	}
+
+	var trailing int
+	for trailing < len(lines) && lines[len(lines)-1-trailing] == "" {
+		trailing++
+	}
+	body := lines[:len(lines)-trailing]
+	var s string
+	if folded {
+		var buf strings.Builder
+		for idx, l := range body {
+			if idx > 0 {
+				if l == "" {
+					buf.WriteByte('\n')
+				} else if body[idx-1] != "" {
+					buf.WriteByte(' ')
+				}
+			}
+			buf.WriteString(l)
+		}
+		s = buf.String()
+	} else {
+		s = strings.Join(body, "\n")
+	}
+	switch {
+	case chomp == '+':
+		s += strings.Repeat("\n", trailing+1)
+	case chomp != '-' && len(body) > 0:
+		s += "\n"
+	}
+	return s, nil
+}
+
+func isYamlSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYamlEntry 拆分key: value, key可为引号字符串
+func splitYamlEntry(text string) (string, string, bool) {
+	if text == "" {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		key, rest, err := unquoteYaml(text)
+		if err != nil || !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+			return "", "", false
+		}
+		return key.(string), strings.TrimSpace(rest[1:]), true
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// stripYamlComment 去除引号外以空白或行首开始的#注释
+func stripYamlComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,:-", s[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		}
+	}
+	return strings.TrimRight(s, " \r")
+}
+
+// unquoteYaml 解析开头的引号字符串, 返回剩余部分
+func unquoteYaml(s string) (interface{}, string, error) {
+	if s[0] == '\'' {
+		var buf strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					buf.WriteByte('\'')
+					i++
+					continue
+				}
+				return buf.String(), s[i+1:], nil
+			}
+			buf.WriteByte(s[i])
+		}
+		return nil, "", fmt.Errorf("unterminated string")
+	}
+	end := closingQuote(s)
+	if end < 0 {
+		return nil, "", fmt.Errorf("unterminated string")
+	}
+	v, err := strconv.Unquote(s[:end+1])
+	if err != nil {
+		return nil, "", err
+	}
+	return v, s[end+1:], nil
+}
+
+func resolveYamlScalar(s string) interface{} {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case ".inf", ".Inf", ".INF", "+.inf":
+		return math.Inf(1)
+	case "-.inf", "-.Inf", "-.INF":
+		return math.Inf(-1)
+	case ".nan", ".NaN", ".NAN":
+		return math.NaN()
+	}
+	if yamlIntRe.MatchString(s) {
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v
+		}
+	} else if strings.HasPrefix(s, "0x") {
+		if v, err := strconv.ParseInt(s[2:], 16, 64); err == nil {
+			return v
+		}
+	} else if strings.HasPrefix(s, "0o") {
+		if v, err := strconv.ParseInt(s[2:], 8, 64); err == nil {
+			return v
+		}
+	}
+	if yamlFloatRe.MatchString(s) {
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+// yamlFlow 解析单行的[a, b]及{a: 1}
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (p *yamlFlow) skipSpace() {
+	for p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *yamlFlow) parse() (interface{}, error) {
+	if p.skipSpace(); p.i >= len(p.s) {
+		return nil, fmt.Errorf("unexpected end of flow collection")
+	}
+	switch p.s[p.i] {
+	case '[':
+		var list = make([]interface{}, 0)
+		err := p.items(']', func() error {
+			v, err := p.parse()
+			list = append(list, v)
+			return err
+		})
+		return list, err
+	case '{':
+		var m = make(map[string]interface{})
+		err := p.items('}', func() error {
+			k, err := p.scalar(":,}")
+			if err != nil {
+				return err
+			}
+			if p.skipSpace(); p.i >= len(p.s) || p.s[p.i] != ':' {
+				return fmt.Errorf("expected ':' in flow mapping")
+			}
+			p.i++
+			v, err := p.parse()
+			m[fmt.Sprint(k)] = v
+			return err
+		})
+		return m, err
+	}
+	return p.scalar(",]}")
+}
+
+// items 解析以逗号分隔直到end的元素, 允许末尾逗号
+func (p *yamlFlow) items(end byte, item func() error) error {
+	p.i++
+	for {
+		if p.skipSpace(); p.i >= len(p.s) {
+			return fmt.Errorf("unterminated flow collection")
+		}
+		if p.s[p.i] == end {
+			p.i++
+			return nil
+		}
+		if err := item(); err != nil {
+			return err
+		}
+		if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == ',' {
+			p.i++
+		} else if p.i >= len(p.s) || p.s[p.i] != end {
+			return fmt.Errorf("expected ',' or '%c' in flow collection", end)
+		}
+	}
+}
+
+func (p *yamlFlow) scalar(stops string) (interface{}, error) {
+	if p.skipSpace(); p.i < len(p.s) && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+		v, rest, err := unquoteYaml(p.s[p.i:])
+		p.i = len(p.s) - len(rest)
+		return v, err
+	}
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(stops, p.s[p.i]) < 0 {
+		p.i++
+	}
+	return resolveYamlScalar(strings.TrimSpace(p.s[start:p.i])), nil
+}
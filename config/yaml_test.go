@@ -0,0 +1,87 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeYaml(t *testing.T) {
+	var cases = []struct {
+		name string
+		in   string
+		want m
+	}{
+		{"scalars", "a: 1\nb: -2.5\nc: true\nd: ~\ne: hello world\nf: 0x1f\ng: \"1\"\nh: 'it''s'\n",
+			m{"a": int64(1), "b": -2.5, "c": true, "d": nil, "e": "hello world", "f": int64(31), "g": "1", "h": "it's"}},
+		{"comments and document marker", "---\n# head\na: 1 # tail\nb: \"# kept\"\nc: x#y\n",
+			m{"a": int64(1), "b": "# kept", "c": "x#y"}},
+		{"nested maps", "server:\n  host: h\n  tls:\n    enable: true\ndb:\n  port: 3306\n",
+			m{"server": m{"host": "h", "tls": m{"enable": true}}, "db": m{"port": int64(3306)}}},
+		{"quoted keys", "\"a.b\": 1\n'c d': 2\n",
+			m{"a.b": int64(1), "c d": int64(2)}},
+		{"sequence at key indent", "list:\n- a\n- b\n",
+			m{"list": l{"a", "b"}}},
+		{"sequence of maps", "svc:\n  - name: a\n    port: 1\n  - name: b\n    opt:\n      v: 2\n",
+			m{"svc": l{m{"name": "a", "port": int64(1)}, m{"name": "b", "opt": m{"v": int64(2)}}}}},
+		{"nested sequences", "a:\n  - - 1\n    - 2\n  - - x\n    - [true, false]\n",
+			m{"a": l{l{int64(1), int64(2)}, l{"x", l{true, false}}}}},
+		{"flow collections", "a: [1, \"x, y\", [2, 3], {k: v}]\nb: {p: 1, q: [a, b],}\n",
+			m{"a": l{int64(1), "x, y", l{int64(2), int64(3)}, m{"k": "v"}}, "b": m{"p": int64(1), "q": l{"a", "b"}}}},
+		{"literal block", "s: |\n  line1\n   indented\n\n  line3\nnext: 1\n",
+			m{"s": "line1\n indented\n\nline3\n", "next": int64(1)}},
+		{"literal block strip", "s: |-\n  a\n  b\n\n",
+			m{"s": "a\nb"}},
+		{"literal block keep", "s: |+\n  a\n\n\nn: 1\n",
+			m{"s": "a\n\n\n", "n": int64(1)}},
+		{"folded block", "s: >\n  a\n  b\n\n  c\n",
+			m{"s": "a b\nc\n"}},
+		{"double quoted escapes", "s: \"tab\\tnl\\n\\u4e2d\"\n",
+			m{"s": "tab\tnl\n中"}},
+		{"empty value", "a:\nb: 1\n",
+			m{"a": nil, "b": int64(1)}},
+		{"empty document", "# only comment\n",
+			m{}},
+		{"crlf", "a: 1\r\nb:\r\n  c: 2\r\n",
+			m{"a": int64(1), "b": m{"c": int64(2)}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeYaml([]byte(c.in))
+			if err != nil {
+				t.Fatalf("decodeYaml error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("decodeYaml = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeYamlError(t *testing.T) {
+	var cases = []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"duplicate key", "a: 1\na: 2\n", "line 2: duplicate key a"},
+		{"duplicate nested key", "a:\n  b: 1\n  b: 2\n", "line 3: duplicate key b"},
+		{"tab indentation", "a:\n\tb: 1\n", "tab indentation"},
+		{"bad indentation", "a: 1\n  b: 2\n", "unexpected indentation"},
+		{"not a mapping", "just text\n", "expected key: value"},
+		{"root sequence", "- a\n- b\n", "root must be a mapping"},
+		{"anchor", "a: &x 1\n", "anchors, aliases and tags are not supported"},
+		{"unterminated quote", "a: \"x\n", "unterminated string"},
+		{"unterminated flow", "a: [1, 2\n", "line 1"},
+		{"trailing after quote", "a: \"x\" y\n", "unexpected"},
+		{"bad block header", "a: |x\n  b\n", "unsupported block scalar header"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeYaml([]byte(c.in))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("decodeYaml error = %v, want %q", err, c.err)
+			}
+		})
+	}
+}
@@ -31,11 +31,13 @@ func NewTraceId() string {
 }
 
 type actionRequest struct {
-	CallerId    int
-	Name        string
-	RequestTime time.Time
-	Timeout     time.Duration
-	NonRespond  bool
+	CallerId       int
+	Name           string
+	Attach         map[string][]byte
+	RequestTime    time.Time
+	Timeout        time.Duration
+	NonRespond     bool
+	IdempotencyKey string
 }
 
 type TraceContext struct {
@@ -57,6 +59,8 @@ type Context struct {
 	Response      Response
 	Session       *Session
 	Trace         *TraceContext
+	Metadata      map[string]string // pproto v5 元数据, 调用下游时透传
+	Principal     *Principal        // 认证后的调用方身份, 匿名请求为nil
 	Logger        lcx
 	FinishTime    time.Time
 	isFinish      bool
@@ -79,10 +83,12 @@ func NewPlayContext(parent context.Context, s *Session, request *Request, timeou
 	}
 	gctx, gcfunc := context.WithTimeout(parent, timeout)
 	var action = actionRequest{
-		CallerId:    request.CallerId,
-		Name:        request.ActionName,
-		NonRespond:  request.NonRespond,
-		RequestTime: time.Now(),
+		CallerId:       request.CallerId,
+		Name:           request.ActionName,
+		Attach:         request.Attach,
+		NonRespond:     request.NonRespond,
+		RequestTime:    time.Now(),
+		IdempotencyKey: request.IdempotencyKey,
 	}
 	var trace = TraceContext{
 		TagId:        request.TagId,
@@ -92,10 +98,13 @@ func NewPlayContext(parent context.Context, s *Session, request *Request, timeou
 		ServerName:   request.ActionName,
 	}
 	var response = Response{
-		Version:    request.Version,
-		TraceId:    traceId,
-		RenderName: request.RenderName,
-		Template:   strings.ReplaceAll(request.ActionName, ".", "/"),
+		Version:     request.Version,
+		TraceId:     traceId,
+		RenderName:  request.RenderName,
+		RequestId:   request.RequestId,
+		Compress:    request.Compress,
+		MessageType: request.MessageType,
+		Template:    strings.ReplaceAll(request.ActionName, ".", "/"),
 	}
 
 	var l = lcx{
@@ -107,6 +116,7 @@ func NewPlayContext(parent context.Context, s *Session, request *Request, timeou
 		Input:         NewInput(request.InputBinder),
 		Response:      response,
 		Trace:         &trace,
+		Metadata:      request.Metadata,
 		Logger:        l,
 		Session:       s,
 		gctx:          gctx,
@@ -141,10 +151,24 @@ func (c *Context) Err() error {
 	return nil
 }
 
+type playContextKey struct{}
+
 func (c *Context) Value(key interface{}) interface{} {
+	if _, ok := key.(playContextKey); ok {
+		return c
+	}
 	return c.gctx.Value(key)
 }
 
+// ContextOf 从play.Context或其派生的context中取出play.Context
+func ContextOf(ctx context.Context) (*Context, bool) {
+	if c, ok := ctx.(*Context); ok {
+		return c, true
+	}
+	c, ok := ctx.Value(playContextKey{}).(*Context)
+	return c, ok
+}
+
 // Generate28Id 根据ip，按时间生成28位Id
 func Generate28Id(prefix string, suffix string) string {
 	var x uint16
@@ -1,15 +1,20 @@
 package play
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
 var (
+	cronLastError       atomic.Value
+	cronStopped         int32
 	cronLastFileModTime int64
 	cronJobs            = make(map[string]*cronJobWrap, 8)
 	cronRunner          = cron.New()
@@ -45,13 +50,26 @@ func RegisterCronJob(name string, new func() CronJob) {
 }
 
 func CronStop() {
+	atomic.StoreInt32(&cronStopped, 1)
 	<-cronRunner.Stop().Done()
 }
 
 func CronStart() {
+	atomic.StoreInt32(&cronStopped, 0)
 	cronRunner.Start()
 }
 
+// CronHealthCheck 供RegisterHealthCheck使用, 定时任务已停止或配置文件最近一次加载失败时返回错误
+func CronHealthCheck(ctx context.Context) error {
+	if atomic.LoadInt32(&cronStopped) == 1 {
+		return errors.New("cron stopped")
+	}
+	if msg, _ := cronLastError.Load().(string); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
 func CronStartWithFile(filename string, refashTickTime time.Duration) (err error) {
 	err = getConfigFromFile(filename)
 	if refashTickTime > 0 {
@@ -73,7 +91,10 @@ func startCronWatchFileChange(filename string, refashTickTime time.Duration) {
 		var refashTicker = time.NewTicker(refashTickTime * time.Second)
 		for range refashTicker.C {
 			if err := getConfigFromFile(filename); err != nil {
+				cronLastError.Store(err.Error())
 				fmt.Println("watch cron file error:", err)
+			} else {
+				cronLastError.Store("")
 			}
 		}
 	}()
@@ -2,6 +2,7 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -54,6 +55,17 @@ func GetConnect(ctx context.Context, router string) (*mongo.Client, error) {
 	}
 }
 
+// HealthCheck 供play.RegisterHealthCheck使用, 错误信息中不包含连接串
+func HealthCheck(router string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		client, err := GetConnect(ctx, router)
+		if err != nil {
+			return errors.New("mongodb router " + router + " unavailable")
+		}
+		return client.Ping(ctx, nil)
+	}
+}
+
 func getCollection(query *play.Query) (collection *mongo.Collection, err error) {
 	var client *mongo.Client
 	if client, err = GetConnect(context.Background(), query.Router); err != nil {
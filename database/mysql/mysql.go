@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -31,7 +32,7 @@ func GetConnect(router string) (*sql.DB, error) {
 			return nil, fmt.Errorf("can not find open mysql, %w", err)
 		}
 		if err = dbconnect.Ping(); err != nil {
-			return nil, fmt.Errorf("can not find ping mysql host: %s , %w", dest, err)
+			return nil, fmt.Errorf("can not find ping mysql host: %s , %w", database.RedactDest(dest), err)
 		}
 
 		if connect, ok := dbconnects.LoadOrStore(dest, dbconnect); ok {
@@ -47,6 +48,17 @@ func GetConnect(router string) (*sql.DB, error) {
 	}
 }
 
+// HealthCheck 供play.RegisterHealthCheck使用, 错误信息中不包含连接串
+func HealthCheck(router string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		conn, err := GetConnect(router)
+		if err != nil {
+			return errors.New("mysql router " + router + " unavailable")
+		}
+		return conn.PingContext(ctx)
+	}
+}
+
 func GetList(dest interface{}, query *play.Query) (err error) {
 	var conn *sql.DB
 	var rows *sql.Rows
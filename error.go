@@ -5,6 +5,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/leochen2038/play/logger"
 )
 
 type Err struct {
@@ -21,8 +23,25 @@ func (e Err) Err() error {
 	return e.err
 }
 
+// Attach 附加信息可能返回给客户端, 敏感字段及已注册的敏感值被替换
 func (e Err) Attach() map[string]interface{} {
-	return e.attach
+	if len(e.attach) == 0 {
+		return e.attach
+	}
+	var attach = make(map[string]interface{}, len(e.attach))
+	for k, v := range e.attach {
+		switch val := v.(type) {
+		case string:
+			v = logger.Redact(val)
+		case error:
+			v = logger.Redact(val.Error())
+		}
+		if logger.IsSensitiveKey(k) {
+			v = logger.REDACTED
+		}
+		attach[k] = v
+	}
+	return attach
 }
 
 func (e Err) Tip() string {
@@ -31,7 +50,7 @@ func (e Err) Tip() string {
 
 func (e Err) Error() string {
 	if e.err != nil {
-		return e.err.Error()
+		return logger.Redact(e.err.Error())
 	}
 	return e.tip
 }
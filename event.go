@@ -0,0 +1,190 @@
+package play
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/logger"
+)
+
+// Event 发布到主题的事件, Trace继承发布方的trace, 作为其下一个span
+type Event struct {
+	Topic    string
+	Payload  interface{}
+	Time     time.Time
+	Trace    *TraceContext
+	Metadata map[string]string
+	Logger   lcx
+	ctx      context.Context
+}
+
+// Context 同步订阅者为发布方的context, 异步订阅者不随发布方请求结束而取消
+func (e *Event) Context() context.Context {
+	return e.ctx
+}
+
+type EventHandler func(ev *Event)
+
+type eventSubscriber struct {
+	id      uint64
+	async   bool
+	handler EventHandler
+}
+
+type eventTopic struct {
+	typ         reflect.Type
+	subscribers []*eventSubscriber
+}
+
+var (
+	eventSeq    uint64
+	eventMutex  sync.RWMutex
+	eventTopics = make(map[string]*eventTopic, 8)
+)
+
+// RegisterTopic 声明主题的payload类型, 发布类型不一致的payload时返回错误; 未声明的主题不检查类型
+func RegisterTopic(topic string, payload interface{}) {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	getEventTopic(topic).typ = reflect.TypeOf(payload)
+}
+
+// Subscribe 同步订阅, 在Publish的goroutine中按订阅顺序执行; 返回取消订阅的函数
+func Subscribe(topic string, handler EventHandler) (unsubscribe func()) {
+	return subscribe(topic, handler, false)
+}
+
+// SubscribeAsync 异步订阅, 每个事件在新的goroutine中执行
+func SubscribeAsync(topic string, handler EventHandler) (unsubscribe func()) {
+	return subscribe(topic, handler, true)
+}
+
+func subscribe(topic string, handler EventHandler, async bool) func() {
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	sub := &eventSubscriber{id: atomic.AddUint64(&eventSeq, 1), async: async, handler: handler}
+	t := getEventTopic(topic)
+	t.subscribers = append(t.subscribers[:len(t.subscribers):len(t.subscribers)], sub)
+
+	return func() {
+		eventMutex.Lock()
+		defer eventMutex.Unlock()
+		var list = make([]*eventSubscriber, 0, len(t.subscribers))
+		for _, v := range t.subscribers {
+			if v.id != sub.id {
+				list = append(list, v)
+			}
+		}
+		t.subscribers = list
+	}
+}
+
+func getEventTopic(topic string) *eventTopic {
+	t, ok := eventTopics[topic]
+	if !ok {
+		t = new(eventTopic)
+		eventTopics[topic] = t
+	}
+	return t
+}
+
+// Publish 发布事件, 同步订阅者全部执行完后返回; 订阅者的panic被隔离并记录, 不影响发布方及其他订阅者
+func Publish(ctx context.Context, topic string, payload interface{}) error {
+	eventMutex.RLock()
+	t := eventTopics[topic]
+	var typ reflect.Type
+	var subscribers []*eventSubscriber
+	if t != nil {
+		typ, subscribers = t.typ, t.subscribers
+	}
+	eventMutex.RUnlock()
+
+	if typ != nil && reflect.TypeOf(payload) != typ {
+		return errors.New("event topic " + topic + " expects payload " + typ.String())
+	}
+	if len(subscribers) == 0 {
+		return nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ev := newEvent(ctx, topic, payload)
+	for _, sub := range subscribers {
+		if sub.async {
+			async := *ev
+			async.ctx = context.Background()
+			go deliverEvent(sub, &async)
+		} else {
+			deliverEvent(sub, ev)
+		}
+	}
+	return nil
+}
+
+func newEvent(ctx context.Context, topic string, payload interface{}) *Event {
+	ev := &Event{Topic: topic, Payload: payload, Time: time.Now(), ctx: ctx}
+	trace := TraceContext{StartTime: ev.Time, OperationName: "event:" + topic, ServerName: topic}
+	if pctx, ok := ContextOf(ctx); ok {
+		pctx.Trace.SpanId++
+		trace.TraceId, trace.TagId = pctx.Trace.TraceId, pctx.Trace.TagId
+		trace.ParentSpanId = append(append([]byte{}, pctx.Trace.ParentSpanId...), pctx.Trace.SpanId)
+		ev.Metadata = pctx.Metadata
+	} else {
+		trace.TraceId = NewTraceId()
+	}
+	ev.Trace, ev.Logger = &trace, lcx{traceId: trace.TraceId, action: trace.OperationName}
+	return ev
+}
+
+func deliverEvent(sub *eventSubscriber, ev *Event) {
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			logger.System("panic on event subscriber", "topic", ev.Topic, "traceId", ev.Trace.TraceId, "panicInfo", panicInfo, "stack", string(debug.Stack()))
+		}
+	}()
+	sub.handler(ev)
+}
+
+// BridgeTopic 将主题的事件以Session.Push转发给已登记(RegisterSession)的长连接session, action为主题名;
+// sse以event行标明主题, websocket消息为{"topic": 主题, "data": 事件}; match为nil时转发给所有SSE及WebSocket session
+func BridgeTopic(topic string, match func(ev *Event, s *Session) bool) (unsubscribe func()) {
+	return SubscribeAsync(topic, func(ev *Event) {
+		payload, err := eventPayload(ev.Payload)
+		if err != nil {
+			ev.Logger.Error(err)
+			return
+		}
+		RangeSession(func(s *Session) bool {
+			if (match != nil && !match(ev, s)) || (match == nil && s.Conn.Type != SERVER_TYPE_SSE && s.Conn.Type != SERVER_TYPE_WS) {
+				return true
+			}
+			if err := s.Push(topic, payload); err != nil {
+				ev.Logger.Warn("bridge event push error", err.Error(), "sessId", s.SessId)
+			}
+			return true
+		})
+	})
+}
+
+// eventPayload map直接推送, 其他类型按json转为map, 非对象的值放在data中
+func eventPayload(payload interface{}) (map[string]interface{}, error) {
+	if m, ok := payload.(map[string]interface{}); ok {
+		return m, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(data, &m) != nil || m == nil {
+		return map[string]interface{}{"data": payload}, nil
+	}
+	return m, nil
+}
@@ -18,6 +18,7 @@ var mdTemplate = `
 ## {{name}}
 
 ### 接口描述 {{desc}}
+{{authz}}
 >  请求参数
 
 | 参数名称 | 类型 | 必填 | 描述 | 默认 |
@@ -65,6 +66,7 @@ func getMdActionTpl(action *play.Action) error {
 
 	tmp = strings.ReplaceAll(tmp, "{{name}}", action.Name())
 	tmp = strings.ReplaceAll(tmp, "{{desc}}", action.MetaData()["desc"])
+	tmp = strings.ReplaceAll(tmp, "{{authz}}", getMdAuthzTpl(action.Authorization()))
 	tmp = strings.ReplaceAll(tmp, "{{request}}", getMdFieldTpl(action.Input(), 0))
 	tmp = strings.ReplaceAll(tmp, "{{response}}", getMdFieldTpl(action.Output(), 0))
 	mdDocument += tmp
@@ -100,3 +102,20 @@ func getMdFieldTpl(fields map[string]play.ActionField, level int) string {
 	}
 	return tmp
 }
+
+func getMdAuthzTpl(authz play.AuthzRequirement) string {
+	if authz.Empty() {
+		return ""
+	}
+	tmp := ">  权限要求\n\n- 需要登录\n"
+	if len(authz.Roles) > 0 {
+		tmp += "- 角色(具备其一): " + strings.Join(authz.Roles, ", ") + "\n"
+	}
+	if len(authz.Scopes) > 0 {
+		tmp += "- scope: " + strings.Join(authz.Scopes, ", ") + "\n"
+	}
+	if len(authz.Permissions) > 0 {
+		tmp += "- 权限: " + strings.Join(authz.Permissions, ", ") + "\n"
+	}
+	return tmp
+}
@@ -6,11 +6,14 @@ require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
+	github.com/klauspost/compress v1.13.6
 	github.com/quic-go/quic-go v0.33.0
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/tidwall/gjson v1.14.2
 	go.mongodb.org/mongo-driver v1.10.1
+	golang.org/x/crypto v0.4.0
 	golang.org/x/net v0.4.0
 	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.3.0
 	google.golang.org/protobuf v1.28.0
 )
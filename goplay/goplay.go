@@ -9,6 +9,7 @@ import (
 	"github.com/leochen2038/play/goplay/gendoc"
 	"github.com/leochen2038/play/goplay/initProject"
 	"github.com/leochen2038/play/goplay/reconst"
+	"github.com/leochen2038/play/goplay/secret"
 )
 
 var command string
@@ -31,9 +32,18 @@ Usage:
 The commands are:
 	init	init a new project
 	reconst	project path
-    gendoc  generate api document`, env.FrameworkVer)
+    gendoc  generate api document
+	secret	encrypt, decrypt and rotate config secrets`, env.FrameworkVer)
 		os.Exit(1)
 	}
+	// secret不需要项目路径
+	if os.Args[1] == "secret" {
+		if err := secret.Run(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	if len(os.Args) < 3 {
 		fmt.Println("please input project path")
 		os.Exit(1)
@@ -0,0 +1,249 @@
+package secret
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/leochen2038/play/config"
+)
+
+// secretRe 配置文件中的加密值
+var secretRe = regexp.MustCompile(`enc:[a-z0-9]+:[A-Za-z0-9_\-:]+`)
+
+const usage = `Usage:
+	goplay secret keygen <keyfile>                       generate aes key, existing keys are kept for decryption
+	goplay secret x25519-keygen <identityfile>           generate x25519 identity and print its recipient
+	goplay secret encrypt [-key f | -recipient r...] <plaintext|->
+	goplay secret decrypt [-key f] [-identity f] <value>
+	goplay secret rotate [-key f] [-identity f] [-to-key f | -to-recipient r...] <configfile>`
+
+type recipients []string
+
+func (r *recipients) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *recipients) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
+
+func Run(args []string) error {
+	if len(args) < 1 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "keygen":
+		return keygen(args[1:])
+	case "x25519-keygen":
+		return x25519Keygen(args[1:])
+	case "encrypt":
+		return encrypt(args[1:])
+	case "decrypt":
+		return decrypt(args[1:])
+	case "rotate":
+		return rotate(args[1:])
+	}
+	return errors.New(usage)
+}
+
+// keygen 新密钥写在第一行用于加密, 原有密钥保留用于解密, 之后使用rotate重新加密配置
+func keygen(args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+	key, err := config.GenerateAESKey()
+	if err != nil {
+		return err
+	}
+	if err = prependLine(args[0], key); err != nil {
+		return err
+	}
+	fmt.Println("key id:", config.AESKeyId(key))
+	return nil
+}
+
+func x25519Keygen(args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+	identity, recipient, err := config.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	if err = prependLine(args[0], identity); err != nil {
+		return err
+	}
+	fmt.Println("recipient:", recipient)
+	return nil
+}
+
+func encrypt(args []string) error {
+	var keyFile string
+	var to recipients
+	var fs = flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fs.StringVar(&keyFile, "key", "", "aes key file")
+	fs.Var(&to, "recipient", "x25519 recipient, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	provider, err := targetProvider(keyFile, to)
+	if err != nil {
+		return err
+	}
+	plaintext := fs.Arg(0)
+	if plaintext == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		plaintext = strings.TrimRight(string(data), "\r\n")
+	}
+	value, err := provider.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	fmt.Println(value)
+	return nil
+}
+
+func decrypt(args []string) error {
+	var fs = flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	keyFile := fs.String("key", "", "aes key file")
+	identityFile := fs.String("identity", "", "x25519 identity file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	if err := registerProviders(*keyFile, *identityFile); err != nil {
+		return err
+	}
+	plaintext, err := config.DecryptSecret(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(plaintext)
+	return nil
+}
+
+// rotate 以-key及-identity解密文件中的全部加密值, 使用目标重新加密后写回; 未指定目标时使用-key的第一个密钥
+func rotate(args []string) error {
+	var to recipients
+	var fs = flag.NewFlagSet("rotate", flag.ContinueOnError)
+	keyFile := fs.String("key", "", "aes key file")
+	identityFile := fs.String("identity", "", "x25519 identity file")
+	toKeyFile := fs.String("to-key", "", "target aes key file")
+	fs.Var(&to, "to-recipient", "target x25519 recipient, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	if err := registerProviders(*keyFile, *identityFile); err != nil {
+		return err
+	}
+	if *toKeyFile == "" && len(to) == 0 {
+		*toKeyFile = *keyFile
+	}
+	provider, err := targetProvider(*toKeyFile, to)
+	if err != nil {
+		return err
+	}
+
+	file := fs.Arg(0)
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var count int
+	result := secretRe.ReplaceAllStringFunc(string(data), func(value string) string {
+		if err != nil {
+			return value
+		}
+		var rotated string
+		if rotated, err = config.RotateSecret(value, provider); err != nil {
+			return value
+		}
+		count++
+		return rotated
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeFile(file, []byte(result), info.Mode()); err != nil {
+		return err
+	}
+	fmt.Printf("rotated %d secrets in %s\n", count, file)
+	return nil
+}
+
+func targetProvider(keyFile string, to recipients) (config.SecretProvider, error) {
+	if keyFile != "" && len(to) > 0 {
+		return nil, errors.New("use either aes key or x25519 recipients")
+	}
+	if keyFile != "" {
+		return config.NewAESSecretProvider(keyFile)
+	}
+	if len(to) > 0 {
+		return config.NewX25519SecretProvider(to, "")
+	}
+	return nil, errors.New("aes key or x25519 recipient required")
+}
+
+func registerProviders(keyFile string, identityFile string) error {
+	if keyFile == "" && identityFile == "" {
+		return errors.New("aes key or x25519 identity required")
+	}
+	if keyFile != "" {
+		provider, err := config.NewAESSecretProvider(keyFile)
+		if err != nil {
+			return err
+		}
+		config.RegisterSecretProvider(provider)
+	}
+	if identityFile != "" {
+		provider, err := config.NewX25519SecretProvider(nil, identityFile)
+		if err != nil {
+			return err
+		}
+		config.RegisterSecretProvider(provider)
+	}
+	return nil
+}
+
+// prependLine 密钥文件权限为0600
+func prependLine(file string, line string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeFile(file, append([]byte(line+"\n"), data...), 0600)
+}
+
+// writeFile 写入临时文件后替换, 避免中断时损坏原文件
+func writeFile(file string, data []byte, mode os.FileMode) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
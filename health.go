@@ -0,0 +1,221 @@
+package play
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	HEALTH_UP       = "up"
+	HEALTH_DEGRADED = "degraded" // 非关键检查失败, 仍可接收流量
+	HEALTH_DOWN     = "down"
+)
+
+var (
+	// HealthCacheTTL 检查结果的缓存时间, 期间的探测直接返回缓存结果
+	HealthCacheTTL = 2 * time.Second
+	// HealthDefaultTimeout 未指定超时时间的检查的默认超时
+	HealthDefaultTimeout = time.Second
+	healthMutex          sync.RWMutex
+	healthChecks         = make(map[string]*healthCheck)
+)
+
+// HealthResult 单项检查结果
+type HealthResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Duration  int64     `json:"duration_ms"`
+	CheckTime time.Time `json:"check_time"`
+}
+
+// HealthReport 汇总结果, 关键检查失败时为down, 仅非关键检查失败时为degraded
+type HealthReport struct {
+	Status string         `json:"status"`
+	Checks []HealthResult `json:"checks"`
+}
+
+type healthCheck struct {
+	name     string
+	check    func(ctx context.Context) error
+	timeout  time.Duration
+	critical bool
+	liveness bool
+	mutex    sync.Mutex
+	result   HealthResult
+	running  chan struct{} // 正在执行的检查, 完成时关闭
+}
+
+// RegisterHealthCheck 注册就绪检查, critical为true时失败则不可接收流量; 同名检查会被替换
+func RegisterHealthCheck(name string, check func(ctx context.Context) error, timeout time.Duration, critical bool) {
+	registerHealthCheck(&healthCheck{name: name, check: check, timeout: timeout, critical: critical})
+}
+
+// RegisterLivenessCheck 注册存活检查, 失败时进程应被重启, 同时计入就绪检查; 不应包含外部依赖
+func RegisterLivenessCheck(name string, check func(ctx context.Context) error, timeout time.Duration) {
+	registerHealthCheck(&healthCheck{name: name, check: check, timeout: timeout, critical: true, liveness: true})
+}
+
+func UnregisterHealthCheck(name string) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	delete(healthChecks, name)
+}
+
+func registerHealthCheck(c *healthCheck) {
+	if c.timeout <= 0 {
+		c.timeout = HealthDefaultTimeout
+	}
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	healthChecks[c.name] = c
+}
+
+// Liveness 执行存活检查
+func Liveness(ctx context.Context) HealthReport {
+	return runHealthChecks(ctx, true)
+}
+
+// Readiness 执行全部检查, 实例未启动完成或正在关闭时为down
+func Readiness(ctx context.Context) HealthReport {
+	report := runHealthChecks(ctx, false)
+	if !Ready() {
+		report.Status = HEALTH_DOWN
+		report.Checks = append([]HealthResult{{Name: "server", Status: HEALTH_DOWN, Critical: true, Error: "server not ready or draining", CheckTime: time.Now()}}, report.Checks...)
+	}
+	return report
+}
+
+func runHealthChecks(ctx context.Context, liveness bool) HealthReport {
+	var list []*healthCheck
+	healthMutex.RLock()
+	for _, c := range healthChecks {
+		if c.liveness || !liveness {
+			list = append(list, c)
+		}
+	}
+	healthMutex.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+
+	var wg sync.WaitGroup
+	var report = HealthReport{Status: HEALTH_UP, Checks: make([]HealthResult, len(list))}
+	for idx, c := range list {
+		wg.Add(1)
+		go func(idx int, c *healthCheck) {
+			defer wg.Done()
+			report.Checks[idx] = c.run(ctx)
+		}(idx, c)
+	}
+	wg.Wait()
+
+	for _, r := range report.Checks {
+		if r.Status != HEALTH_UP {
+			if r.Critical {
+				report.Status = HEALTH_DOWN
+			} else if report.Status == HEALTH_UP {
+				report.Status = HEALTH_DEGRADED
+			}
+		}
+	}
+	return report
+}
+
+// run 缓存未过期时返回缓存结果, 并发的探测共用同一次检查; 检查不受探测方ctx影响,
+// 探测方ctx先结束时返回down但不缓存, 检查结果仍在完成后写入缓存
+func (c *healthCheck) run(ctx context.Context) HealthResult {
+	c.mutex.Lock()
+	if !c.result.CheckTime.IsZero() && time.Since(c.result.CheckTime) < HealthCacheTTL {
+		defer c.mutex.Unlock()
+		return c.result
+	}
+	if c.running == nil {
+		c.running = make(chan struct{})
+		go c.execute(c.running)
+	}
+	running := c.running
+	c.mutex.Unlock()
+
+	select {
+	case <-running:
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.result
+	case <-ctx.Done():
+		return HealthResult{Name: c.name, Status: HEALTH_DOWN, Critical: c.critical, Error: "probe canceled: " + ctx.Err().Error(), CheckTime: time.Now()}
+	}
+}
+
+func (c *healthCheck) execute(running chan struct{}) {
+	start := time.Now()
+	tctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if panicInfo := recover(); panicInfo != nil {
+				done <- fmt.Errorf("panic: %v", panicInfo)
+			}
+		}()
+		done <- c.check(tctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-tctx.Done():
+		err = errors.New("timeout after " + c.timeout.String())
+	}
+	result := HealthResult{Name: c.name, Status: HEALTH_UP, Critical: c.critical, Duration: int64(time.Since(start) / time.Millisecond), CheckTime: time.Now()}
+	if err != nil {
+		result.Status, result.Error = HEALTH_DOWN, err.Error()
+	}
+
+	c.mutex.Lock()
+	c.result, c.running = result, nil
+	c.mutex.Unlock()
+	close(running)
+}
+
+// AgentHealthCheck 以空请求调用下游服务的action作为检查
+func AgentHealthCheck(agent Agent, service string, action string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := agent.Request(ctx, service, action, nil)
+		return err
+	}
+}
+
+// RegisterHealthAction 注册健康检查action, 供pproto等非http客户端探测
+func RegisterHealthAction(name string) {
+	RegisterAction(name, map[string]string{"desc": "健康检查"}, func() interface{} {
+		return NewProcessorWrap(new(healthProcessor), func(p Processor, ctx *Context) (string, error) {
+			return RunProcessor(unsafe.Pointer(p.(*healthProcessor)), unsafe.Sizeof(*p.(*healthProcessor)), p, ctx)
+		}, nil)
+	})
+}
+
+type healthProcessor struct {
+	Input struct {
+		Probe string `key:"probe" default:"ready" note:"live或ready"`
+	}
+	Output struct {
+		Status string         `key:"status" note:"up/degraded/down"`
+		Checks []HealthResult `key:"checks" note:"各项检查结果"`
+	}
+}
+
+func (p *healthProcessor) Run(ctx *Context) (string, error) {
+	var report HealthReport
+	if p.Input.Probe == "live" {
+		report = Liveness(ctx)
+	} else {
+		report = Readiness(ctx)
+	}
+	p.Output.Status, p.Output.Checks = report.Status, report.Checks
+	return "", nil
+}
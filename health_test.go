@@ -0,0 +1,70 @@
+package play
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthProbeCanceled(t *testing.T) {
+	var calls int32
+	RegisterHealthCheck("slow", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-time.After(200 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, time.Second, true)
+	defer UnregisterHealthCheck("slow")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report := runHealthChecks(ctx, false)
+	if report.Status != HEALTH_DOWN || !strings.HasPrefix(report.Checks[0].Error, "probe canceled") {
+		t.Fatalf("canceled probe = %+v", report)
+	}
+
+	// 探测方取消不影响检查本身, 后续探测等待同一次检查的结果
+	report = runHealthChecks(context.Background(), false)
+	if report.Status != HEALTH_UP || report.Checks[0].Error != "" {
+		t.Errorf("probe after cancel = %+v", report)
+	}
+	if report = runHealthChecks(context.Background(), false); report.Status != HEALTH_UP {
+		t.Errorf("cached probe = %+v", report)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("check ran %d times, want 1", n)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	var cases = []struct {
+		name     string
+		check    func(ctx context.Context) error
+		critical bool
+		status   string
+		err      string
+	}{
+		{"up", func(ctx context.Context) error { return nil }, true, HEALTH_UP, ""},
+		{"critical down", func(ctx context.Context) error { return errors.New("db unreachable") }, true, HEALTH_DOWN, "db unreachable"},
+		{"degraded", func(ctx context.Context) error { return errors.New("cache unreachable") }, false, HEALTH_DEGRADED, "cache unreachable"},
+		{"timeout", func(ctx context.Context) error { <-ctx.Done(); time.Sleep(50 * time.Millisecond); return nil }, true, HEALTH_DOWN, "timeout after 30ms"},
+		{"panic", func(ctx context.Context) error { panic("boom") }, true, HEALTH_DOWN, "panic: boom"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			RegisterHealthCheck(c.name, c.check, 30*time.Millisecond, c.critical)
+			defer UnregisterHealthCheck(c.name)
+
+			report := runHealthChecks(context.Background(), false)
+			if report.Status != c.status || len(report.Checks) != 1 || report.Checks[0].Error != c.err {
+				t.Errorf("runHealthChecks = %+v, want %s %q", report, c.status, c.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package play
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+)
+
+var (
+	// IdempotencyTTL 响应的默认保存时间, 可在action的metaData中以idempotency_ttl覆盖
+	IdempotencyTTL = 24 * time.Hour
+	// IdempotencyConflictCode 相同key的请求仍在执行时返回的错误码
+	IdempotencyConflictCode                  = 409
+	idempotencyStore        IdempotencyStore = NewMemoryIdempotencyStore()
+)
+
+// IdempotencyRecord 保存的首次响应
+type IdempotencyRecord struct {
+	Output json.RawMessage `json:"output,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Tip    string          `json:"tip,omitempty"`
+	Code   int             `json:"code,omitempty"`
+	Err    bool            `json:"err,omitempty"` // 原错误为play.Err
+}
+
+// IdempotencyStore 幂等记录存储, Acquire在key首次出现时占用并返回acquired为true;
+// 已完成时返回保存的记录; 仍在执行中时record为nil且acquired为false
+type IdempotencyStore interface {
+	Acquire(key string, lockTTL time.Duration) (record *IdempotencyRecord, acquired bool, err error)
+	Complete(key string, record *IdempotencyRecord, ttl time.Duration) error
+	Release(key string) error
+}
+
+func SetIdempotencyStore(store IdempotencyStore) {
+	idempotencyStore = store
+}
+
+type idempotencyCtxKey struct{}
+
+// WithIdempotencyKey 调用下游时携带幂等key, pproto请求中写入header
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyCtxKey{}, key)
+}
+
+func IdempotencyKeyOf(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyCtxKey{}).(string)
+	return key
+}
+
+// callIdempotent metaData中 "idempotent": "true" 的action按幂等key只执行一次, 重复请求返回首次的响应;
+// 超时、取消或panic时释放key允许重试; batch及异步任务中的调用不再检查
+func callIdempotent(act *Action, ctx *Context, call func()) {
+	key := idempotencyKeyOf(ctx)
+	if key == "" || idempotencyStore == nil || act == nil || act.metaData["idempotent"] != "true" {
+		call()
+		return
+	}
+	if _, ok := ctx.Session.Server.(*captureServer); ok {
+		call()
+		return
+	}
+
+	ttl := IdempotencyTTL
+	if d, err := time.ParseDuration(act.metaData["idempotency_ttl"]); err == nil && d > 0 {
+		ttl = d
+	}
+	lockTTL := ActionDefaultTimeout
+	if act.Timeout() > 0 {
+		lockTTL = act.Timeout()
+	}
+
+	key = act.name + ":" + strconv.Itoa(ctx.ActionRequest.CallerId) + ":" + key
+	record, acquired, err := idempotencyStore.Acquire(key, 2*lockTTL)
+	switch {
+	case err != nil:
+		ctx.err = err
+	case acquired:
+		call()
+		if idempotencyRetryable(ctx.Err()) {
+			_ = idempotencyStore.Release(key)
+		} else if record, err = newIdempotencyRecord(ctx); err == nil {
+			err = idempotencyStore.Complete(key, record, ttl)
+		}
+		if err != nil {
+			ctx.Logger.Warn("idempotency store error", err.Error())
+		}
+	case record == nil:
+		ctx.err = Err{err: errors.New("idempotency key " + idempotencyKeyOf(ctx) + " is in flight"), code: IdempotencyConflictCode, time: time.Now(), attach: map[string]interface{}{}}
+	default:
+		replayIdempotency(ctx, record)
+	}
+}
+
+func idempotencyKeyOf(ctx *Context) string {
+	if ctx.ActionRequest.IdempotencyKey != "" {
+		return ctx.ActionRequest.IdempotencyKey
+	}
+	if key := ctx.Metadata["idempotency-key"]; key != "" {
+		return key
+	}
+	if r := ctx.Session.Conn.Http.Request; r != nil {
+		return r.Header.Get("Idempotency-Key")
+	}
+	return ""
+}
+
+func idempotencyRetryable(err error) bool {
+	return err == context.DeadlineExceeded || err == context.Canceled || (err != nil && strings.HasPrefix(err.Error(), "panic: "))
+}
+
+func newIdempotencyRecord(ctx *Context) (*IdempotencyRecord, error) {
+	record := new(IdempotencyRecord)
+	if ctx.err != nil {
+		record.Error = ctx.err.Error()
+		if e, ok := ctx.err.(Err); ok {
+			record.Tip, record.Code, record.Err = e.Tip(), e.Code(), true
+		}
+		return record, nil
+	}
+	output, err := json.Marshal(ctx.Response.Output.All())
+	record.Output = output
+	return record, err
+}
+
+func replayIdempotency(ctx *Context, record *IdempotencyRecord) {
+	if record.Error != "" && !record.Err {
+		ctx.err = errors.New(record.Error)
+		return
+	}
+	if record.Error != "" {
+		ctx.err = Err{err: errors.New(record.Error), tip: record.Tip, code: record.Code, time: time.Now(), attach: map[string]interface{}{}}
+		return
+	}
+	var output map[string]interface{}
+	if err := json.Unmarshal(record.Output, &output); err != nil {
+		ctx.err = err
+		return
+	}
+	for k, v := range output {
+		ctx.Response.Output.Set(k, v)
+	}
+}
+
+// MemoryIdempotencyStore 进程内存储, 适用于单实例部署
+type MemoryIdempotencyStore struct {
+	mutex     sync.Mutex
+	records   map[string]*idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	Record *IdempotencyRecord `json:"record,omitempty"`
+	Expire time.Time          `json:"expire"`
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{records: make(map[string]*idempotencyEntry)}
+}
+
+func (s *MemoryIdempotencyStore) Acquire(key string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if entry, ok := s.records[key]; ok && entry.Expire.After(now) {
+		return entry.Record, false, nil
+	}
+	s.records[key] = &idempotencyEntry{Expire: now.Add(lockTTL)}
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records[key] = &idempotencyEntry{Record: record, Expire: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// sweep 每分钟最多一次清理过期记录
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for k, v := range s.records {
+		if !v.Expire.After(now) {
+			delete(s.records, k)
+		}
+	}
+}
+
+// FileIdempotencyStore 以目录保存记录, 同机多进程(prefork、平滑重启)共享; 文件名为key的sha1
+type FileIdempotencyStore struct {
+	dir       string
+	mutex     sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFileIdempotencyStore(dir string) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileIdempotencyStore{dir: dir}, nil
+}
+
+func (s *FileIdempotencyStore) Acquire(key string, lockTTL time.Duration) (*IdempotencyRecord, bool, error) {
+	path, now := s.path(key), time.Now()
+	s.sweep(now)
+	for i := 0; i < 2; i++ {
+		// O_EXCL创建成功即占用, 多进程间互斥
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			data, _ := json.Marshal(idempotencyEntry{Expire: now.Add(lockTTL)})
+			_, err = f.Write(data)
+			if e := f.Close(); err == nil {
+				err = e
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, false, err
+			}
+			return nil, true, nil
+		}
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+
+		entry, err := s.load(path)
+		if err == nil && entry.Expire.After(now) {
+			return entry.Record, false, nil
+		}
+		if err != nil && now.UnixNano()-fileModTime(path) < int64(time.Second) {
+			// 占用方尚未写完
+			return nil, false, nil
+		}
+		_ = os.Remove(path)
+	}
+	return nil, false, errors.New("idempotency key " + key + " acquire failed")
+}
+
+func (s *FileIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	data, err := json.Marshal(idempotencyEntry{Record: record, Expire: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileIdempotencyStore) Release(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileIdempotencyStore) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (s *FileIdempotencyStore) load(path string) (*idempotencyEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := new(idempotencyEntry)
+	if err = json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// sweep 每分钟最多一次清理过期记录
+func (s *FileIdempotencyStore) sweep(now time.Time) {
+	s.mutex.Lock()
+	if now.Sub(s.lastSweep) < time.Minute {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastSweep = now
+	s.mutex.Unlock()
+
+	go func() {
+		files, _ := ioutil.ReadDir(s.dir)
+		for _, f := range files {
+			path := filepath.Join(s.dir, f.Name())
+			if entry, err := s.load(path); err == nil && !entry.Expire.After(now) {
+				_ = os.Remove(path)
+			}
+		}
+	}()
+}
@@ -0,0 +1,112 @@
+package play
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// CIDRList 网段列表, 单个ip视为/32或/128
+type CIDRList []*net.IPNet
+
+func ParseCIDRs(list ...string) (CIDRList, error) {
+	var cidrs CIDRList
+	for _, v := range list {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, errors.New("invalid ip " + v)
+			}
+			if ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, ipnet)
+	}
+	return cidrs, nil
+}
+
+func (l CIDRList) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter 命中deny时拒绝; allow不为空时仅允许命中allow的地址
+type IPFilter struct {
+	allow CIDRList
+	deny  CIDRList
+}
+
+func NewIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	var err error
+	var f = new(IPFilter)
+	if f.allow, err = ParseCIDRs(allow...); err != nil {
+		return nil, err
+	}
+	if f.deny, err = ParseCIDRs(deny...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Allow addr为ip或ip:port, 无法解析的地址仅在未配置allow及deny时允许
+func (f *IPFilter) Allow(addr string) bool {
+	if f == nil || (len(f.allow) == 0 && len(f.deny) == 0) {
+		return true
+	}
+	ip := AddrIP(addr)
+	if ip == nil || f.deny.Contains(ip) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.Contains(ip)
+}
+
+// AddrIP 解析ip或ip:port中的ip
+func AddrIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// parseActionIPFilter action在metaData中以ip_allow、ip_deny声明网段, 以逗号分隔
+func parseActionIPFilter(name string, metaData map[string]string) *IPFilter {
+	if metaData["ip_allow"] == "" && metaData["ip_deny"] == "" {
+		return nil
+	}
+	f, err := NewIPFilter(strings.Split(metaData["ip_allow"], ","), strings.Split(metaData["ip_deny"], ","))
+	if err != nil {
+		panic("action " + name + " ip filter error: " + err.Error())
+	}
+	return f
+}
+
+// checkActionIP 异步任务执行时不再校验, 入队时已校验
+func checkActionIP(act *Action, ctx *Context) error {
+	if act == nil || act.ipFilter == nil {
+		return nil
+	}
+	if c, ok := ctx.Session.Server.(*captureServer); ok && c.jobId != "" {
+		return nil
+	}
+	if !act.ipFilter.Allow(ctx.Session.RemoteAddr) {
+		return ForbiddenErr("ip " + ctx.Session.RemoteAddr + " not allowed")
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package play
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/google/uuid"
+	"github.com/leochen2038/play/codec/binders"
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/logger"
+)
+
+const (
+	JOB_STATUS_PENDING   = "pending"
+	JOB_STATUS_RUNNING   = "running"
+	JOB_STATUS_SUCCEEDED = "succeeded"
+	JOB_STATUS_DEAD      = "dead"
+)
+
+var (
+	// JobMaxAttempts 任务最多执行次数, 超过后转入死信
+	JobMaxAttempts = 5
+	// JobScanInterval 扫描队列目录的间隔, 其他进程入队的任务最迟在一个间隔后执行
+	JobScanInterval = time.Second
+	// JobRetention 已完成任务的保留时间, 死信任务不清理
+	JobRetention = 24 * time.Hour
+	// JobBackoff 第attempts次执行失败后到下次重试的间隔
+	JobBackoff = func(attempts int) time.Duration {
+		if attempts > 8 {
+			return 5 * time.Minute
+		}
+		return time.Second << uint(attempts)
+	}
+	jobQueue *JobQueue
+)
+
+// Job 以json文件保存在队列目录中, 完成后移入done, 重试耗尽后移入dead
+type Job struct {
+	Id         string            `json:"id"`
+	Action     string            `json:"action"`
+	Input      json.RawMessage   `json:"input"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	CallerId   int               `json:"caller_id"`
+	TraceId    string            `json:"trace_id"`
+	Principal  *Principal        `json:"principal,omitempty"`
+	Status     string            `json:"status"`
+	Attempts   int               `json:"attempts"`
+	Error      string            `json:"error,omitempty"`
+	Output     json.RawMessage   `json:"output,omitempty"`
+	CreateTime time.Time         `json:"create_time"`
+	UpdateTime time.Time         `json:"update_time"`
+	NextTime   time.Time         `json:"next_time"`
+}
+
+// JobQueue 基于本地目录的持久化任务队列, 同一目录只有持有文件锁的进程执行任务;
+// 平滑重启时新进程在旧进程退出后接管, 旧进程中断的任务重新执行
+type JobQueue struct {
+	dir       string
+	server    IServer
+	workers   int
+	lock      *os.File
+	jobs      chan string
+	running   sync.Map
+	notify    chan struct{}
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+	lastClean time.Time
+}
+
+// StartJobQueue 在metaData中 "async": "true" 的action将写入队列后立即返回任务id,
+// 由workers个worker经server的hook异步执行; 需在Boot之前调用
+func StartJobQueue(dir string, server IServer, workers int) (*JobQueue, error) {
+	for _, v := range []string{dir, filepath.Join(dir, "done"), filepath.Join(dir, "dead")} {
+		if err := os.MkdirAll(v, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	q := &JobQueue{dir: dir, server: server, workers: workers, jobs: make(chan string, workers), notify: make(chan struct{}, 1)}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	go q.acquire()
+	jobQueue = q
+	return q, nil
+}
+
+// StopJobQueue 停止调度并等待执行中的任务完成
+func StopJobQueue() {
+	if jobQueue != nil {
+		jobQueue.Stop()
+	}
+}
+
+// GetJob 按id查询任务状态, 任意进程均可查询
+func GetJob(id string) (*Job, error) {
+	if jobQueue == nil {
+		return nil, errors.New("job queue not started")
+	}
+	return jobQueue.Get(id)
+}
+
+func (q *JobQueue) Get(id string) (*Job, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return nil, errors.New("job " + id + " not found")
+	}
+	for _, dir := range []string{q.dir, filepath.Join(q.dir, "done"), filepath.Join(q.dir, "dead")} {
+		if job, err := q.load(filepath.Join(dir, id+".json")); err == nil {
+			return job, nil
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, errors.New("job " + id + " not found")
+}
+
+func (q *JobQueue) Stop() {
+	q.cancel()
+	q.wg.Wait()
+	if q.lock != nil {
+		_ = syscall.Flock(int(q.lock.Fd()), syscall.LOCK_UN)
+		_ = q.lock.Close()
+	}
+}
+
+func (q *JobQueue) Enqueue(action string, input []byte, ctx *Context) (*Job, error) {
+	now := time.Now()
+	job := &Job{
+		Id:         uuid.New().String(),
+		Action:     action,
+		Input:      input,
+		Status:     JOB_STATUS_PENDING,
+		CreateTime: now,
+		UpdateTime: now,
+		NextTime:   now,
+	}
+	if ctx != nil {
+		job.Metadata, job.CallerId, job.TraceId, job.Principal = ctx.Metadata, ctx.ActionRequest.CallerId, ctx.Trace.TraceId, ctx.Principal
+	}
+	if err := q.save(q.dir, job); err != nil {
+		return nil, err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// acquire 等待获得队列目录的文件锁后开始调度
+func (q *JobQueue) acquire() {
+	var err error
+	if q.lock, err = os.OpenFile(filepath.Join(q.dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		logger.System("open job queue lock error", "dir", q.dir, "error", err.Error())
+		return
+	}
+	for syscall.Flock(int(q.lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) != nil {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-time.After(JobScanInterval):
+		}
+	}
+
+	q.wg.Add(q.workers + 1)
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	go q.schedule()
+}
+
+func (q *JobQueue) schedule() {
+	defer q.wg.Done()
+	defer close(q.jobs)
+
+	ticker := time.NewTicker(JobScanInterval)
+	defer ticker.Stop()
+	for {
+		q.scan()
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.notify:
+		}
+	}
+}
+
+// scan 未在执行中的running任务为中断的任务, 与到期的pending任务一同执行
+func (q *JobQueue) scan() {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		logger.System("scan job queue error", "dir", q.dir, "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+		if f.IsDir() || id == f.Name() {
+			continue
+		}
+		if _, ok := q.running.Load(id); ok {
+			continue
+		}
+		job, err := q.load(filepath.Join(q.dir, f.Name()))
+		if err != nil || (job.Status == JOB_STATUS_PENDING && job.NextTime.After(now)) {
+			continue
+		}
+		q.running.Store(id, struct{}{})
+		select {
+		case q.jobs <- id:
+		default:
+			q.running.Delete(id)
+			return
+		}
+	}
+
+	if now.Sub(q.lastClean) > time.Hour {
+		q.lastClean = now
+		q.clean(now)
+	}
+}
+
+func (q *JobQueue) clean(now time.Time) {
+	dir := filepath.Join(q.dir, "done")
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		if now.Sub(f.ModTime()) > JobRetention {
+			_ = os.Remove(filepath.Join(dir, f.Name()))
+		}
+	}
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for id := range q.jobs {
+		if q.ctx.Err() == nil {
+			q.execute(id)
+		}
+		q.running.Delete(id)
+	}
+}
+
+func (q *JobQueue) execute(id string) {
+	path := filepath.Join(q.dir, id+".json")
+	job, err := q.load(path)
+	if err != nil {
+		return
+	}
+	job.Status, job.Attempts, job.UpdateTime = JOB_STATUS_RUNNING, job.Attempts+1, time.Now()
+	if err = q.save(q.dir, job); err != nil {
+		logger.System("save job error", "id", id, "error", err.Error())
+		return
+	}
+
+	job.Output, err = q.call(job)
+	job.UpdateTime = time.Now()
+	switch {
+	case err == nil:
+		job.Status, job.Error = JOB_STATUS_SUCCEEDED, ""
+		err = q.move(path, filepath.Join(q.dir, "done"), job)
+	case job.Attempts >= JobMaxAttempts:
+		job.Status, job.Error = JOB_STATUS_DEAD, err.Error()
+		logger.System("job dead", "id", id, "action", job.Action, "attempts", job.Attempts, "error", job.Error)
+		err = q.move(path, filepath.Join(q.dir, "dead"), job)
+	default:
+		job.Status, job.Error, job.NextTime = JOB_STATUS_PENDING, err.Error(), job.UpdateTime.Add(JobBackoff(job.Attempts))
+		err = q.save(q.dir, job)
+	}
+	if err != nil {
+		logger.System("save job error", "id", id, "error", err.Error())
+	}
+}
+
+// call 执行中的任务不随Stop取消, 由action自身的超时控制
+func (q *JobQueue) call(job *Job) ([]byte, error) {
+	request := &Request{
+		RenderName:  "json",
+		CallerId:    job.CallerId,
+		TraceId:     job.TraceId,
+		ActionName:  job.Action,
+		Metadata:    job.Metadata,
+		InputBinder: binders.GetBinderOfJson(job.Input),
+	}
+	capture := &captureServer{IServer: q.server, jobId: job.Id, principal: job.Principal}
+	sess := NewSession(context.Background(), capture)
+	defer sess.Close()
+	if err := CallAction(context.Background(), sess, request); err != nil {
+		return nil, err
+	}
+	if capture.res == nil {
+		return nil, errors.New("action " + job.Action + " no response")
+	}
+	if capture.res.Error != nil {
+		return nil, capture.res.Error
+	}
+	return json.Marshal(capture.res.Output.All())
+}
+
+func (q *JobQueue) load(path string) (*Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	job := new(Job)
+	if err = json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// save 先写临时文件再rename, 保证任务文件完整
+func (q *JobQueue) save(dir string, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+job.Id+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, job.Id+".json"))
+}
+
+func (q *JobQueue) move(path string, dir string, job *Job) error {
+	if err := q.save(dir, job); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// enqueueJob 异步action写入队列并返回任务id, 返回false时由调用方直接执行
+func enqueueJob(act *Action, ctx *Context) bool {
+	if jobQueue == nil || act == nil || act.metaData["async"] != "true" {
+		return false
+	}
+	if c, ok := ctx.Session.Server.(*captureServer); ok && c.jobId != "" {
+		return false
+	}
+
+	input, err := jobInput(act, &ctx.Input)
+	if err == nil {
+		var job *Job
+		if job, err = jobQueue.Enqueue(act.name, input, ctx); err == nil {
+			ctx.Response.Output.Set("job_id", job.Id)
+			ctx.Response.Output.Set("status", job.Status)
+		}
+	}
+	ctx.err = err
+	return true
+}
+
+// jobInput 将请求输入转为json保存, json请求直接使用原始输入
+func jobInput(act *Action, input *Input) ([]byte, error) {
+	if binder := input.Binder(); binder != nil {
+		if v, ok := binder.Get("").(map[string]interface{}); ok {
+			return json.Marshal(v)
+		}
+	}
+	var m = make(map[string]interface{}, len(act.input))
+	for _, field := range act.input {
+		keys := field.Keys
+		if len(keys) == 0 {
+			keys = []string{field.Field}
+		}
+		for _, key := range keys {
+			if v := input.Value(key); v != nil && v != "" {
+				m[key] = v
+			}
+		}
+	}
+	return json.Marshal(m)
+}
+
+// RegisterJobAction 注册按id查询异步任务状态的action
+func RegisterJobAction(name string) {
+	RegisterAction(name, map[string]string{"desc": "查询异步任务状态"}, func() interface{} {
+		return NewProcessorWrap(new(jobProcessor), func(p Processor, ctx *Context) (string, error) {
+			return RunProcessor(unsafe.Pointer(p.(*jobProcessor)), unsafe.Sizeof(*p.(*jobProcessor)), p, ctx)
+		}, nil)
+	})
+}
+
+type jobProcessor struct {
+	Input struct {
+		Id string `key:"id" required:"true" note:"任务id"`
+	}
+	Output struct {
+		Id         string      `key:"id" note:"任务id"`
+		Action     string      `key:"action" note:"action名称"`
+		Status     string      `key:"status" note:"pending/running/succeeded/dead"`
+		Attempts   int         `key:"attempts" note:"已执行次数"`
+		Error      string      `key:"error" note:"最近一次执行的错误"`
+		Output     interface{} `key:"output" note:"执行成功时action的输出"`
+		CreateTime int64       `key:"create_time" note:"创建时间"`
+		UpdateTime int64       `key:"update_time" note:"更新时间"`
+	}
+}
+
+func (p *jobProcessor) Run(ctx *Context) (string, error) {
+	job, err := GetJob(p.Input.Id)
+	if err != nil {
+		return "", err
+	}
+	p.Output.Id, p.Output.Action, p.Output.Status = job.Id, job.Action, job.Status
+	p.Output.Attempts, p.Output.Error = job.Attempts, job.Error
+	p.Output.CreateTime, p.Output.UpdateTime = job.CreateTime.Unix(), job.UpdateTime.Unix()
+	if len(job.Output) > 0 {
+		p.Output.Output = job.Output
+	}
+	return "", nil
+}
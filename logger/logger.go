@@ -8,6 +8,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/leochen2038/play/codec/protos/golang/json"
@@ -18,7 +19,7 @@ const LEVEL_INFO = 2
 const LEVEL_WARN = 1
 const LEVEL_ERROR = 0
 
-var level = 3
+var level int32 = 3 // 原子读写, 支持配置热更新
 var wchan chan *log
 var lvMap = map[int]string{3: "debug", 2: "info", 1: "warn", 0: "error"}
 
@@ -46,11 +47,15 @@ func SetLevel(l int) {
 	if l < 0 {
 		l = 0
 	}
-	level = l
+	atomic.StoreInt32(&level, int32(l))
+}
+
+func Level() int {
+	return int(atomic.LoadInt32(&level))
 }
 
 func Write(lv int, now time.Time, traceId string, action string, file string, k string, v interface{}, attach map[string]interface{}) {
-	if level < lv {
+	if Level() < lv {
 		return
 	}
 	data := fmt.Sprintf(`{"time":"%s", "level":"%s", "traceId":"%s", "action":"%s", "file":"%s"`, now.Format("2006-01-02 15:04:05.000"), lvMap[lv], traceId, action, file)
@@ -61,14 +66,17 @@ func Write(lv int, now time.Time, traceId string, action string, file string, k
 		}
 	}
 	for k, v := range attach {
+		if IsSensitiveKey(k) {
+			v = REDACTED
+		}
 		if vv, _ := json.MarshalEscape(v, false, false); vv != nil {
 			data += fmt.Sprintf(`, "%s":%s`, k, vv)
 		}
 	}
-	data += "}\n"
+	data = Redact(data) + "}\n"
 
 	select {
-	case wchan <- &log{now, level, []byte(data)}:
+	case wchan <- &log{now, Level(), []byte(data)}:
 		return
 	default:
 		fmt.Println("log channel is full")
@@ -76,7 +84,7 @@ func Write(lv int, now time.Time, traceId string, action string, file string, k
 }
 
 func Info(k string, v interface{}, kv ...interface{}) {
-	if level >= LEVEL_INFO {
+	if Level() >= LEVEL_INFO {
 		Write(LEVEL_INFO, time.Now(), "", "", getFile(), k, v, getAttach(kv))
 	}
 }
@@ -90,13 +98,13 @@ func Error(err error, kv ...interface{}) {
 }
 
 func Debug(k string, v interface{}, kv ...interface{}) {
-	if level >= LEVEL_DEBUG {
+	if Level() >= LEVEL_DEBUG {
 		Write(LEVEL_DEBUG, time.Now(), "", "", getFile(), k, v, getAttach(kv))
 	}
 }
 
 func Warn(k string, v interface{}, kv ...interface{}) {
-	if level >= LEVEL_WARN {
+	if Level() >= LEVEL_WARN {
 		Write(LEVEL_WARN, time.Now(), "", "", getFile(), k, v, getAttach(kv))
 	}
 }
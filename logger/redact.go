@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+)
+
+// REDACTED 替换敏感值的文本
+const REDACTED = "******"
+
+var (
+	secretMutex sync.Mutex
+	secrets     atomic.Value // []string
+	// sensitiveKeys 名称包含这些词的字段视为敏感字段
+	sensitiveKeys = []string{"password", "passwd", "secret", "token", "private_key", "api_key", "apikey", "credential"}
+)
+
+// RegisterSecret 注册敏感值, 日志中出现时替换为REDACTED; 少于4个字符的值不注册, 避免误替换
+func RegisterSecret(secret string) {
+	if len(secret) < 4 {
+		return
+	}
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+	list, _ := secrets.Load().([]string)
+	for _, v := range list {
+		if v == secret {
+			return
+		}
+	}
+	var next = append(append(make([]string, 0, len(list)+2), list...), secret)
+	// 同时替换json转义后的形式
+	if escaped, _ := json.MarshalEscape(secret, false, false); len(escaped) > 2 && string(escaped[1:len(escaped)-1]) != secret {
+		next = append(next, string(escaped[1:len(escaped)-1]))
+	}
+	secrets.Store(next)
+}
+
+// Redact 替换s中已注册的敏感值
+func Redact(s string) string {
+	list, _ := secrets.Load().([]string)
+	for _, v := range list {
+		if strings.Contains(s, v) {
+			s = strings.ReplaceAll(s, v, REDACTED)
+		}
+	}
+	return s
+}
+
+// IsSensitiveKey 字段名是否为密码、密钥等敏感字段
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, v := range sensitiveKeys {
+		if strings.Contains(key, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package packers
+
+import (
+	"github.com/leochen2038/play"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// GatewayPacker 同一http端口上, fileDescriptors中定义的方法及grpc/grpc-web请求交由PbPacker处理(含json转码),
+// 其余请求交由fallback处理
+type GatewayPacker struct {
+	pb       *PbPacker
+	fallback play.IPacker
+}
+
+func NewGatewayPacker(fileDescriptors []protoreflect.FileDescriptor, fallback play.IPacker) play.IPacker {
+	if fallback == nil {
+		fallback = NewHttpPackert()
+	}
+	return &GatewayPacker{pb: NewPbPacker(fileDescriptors).(*PbPacker), fallback: fallback}
+}
+
+func (p *GatewayPacker) Receive(c *play.Conn) (*play.Request, error) {
+	return p.route(c).Receive(c)
+}
+
+func (p *GatewayPacker) Pack(c *play.Conn, res *play.Response) ([]byte, error) {
+	return p.route(c).Pack(c, res)
+}
+
+func (p *GatewayPacker) route(c *play.Conn) play.IPacker {
+	if r := c.Http.Request; r != nil {
+		if grpcModeOf(r) != grpcModeJson || p.pb.getMethod(r.URL.Path) != nil {
+			return p.pb
+		}
+	}
+	return p.fallback
+}
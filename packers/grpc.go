@@ -0,0 +1,224 @@
+package packers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leochen2038/play"
+)
+
+const (
+	GRPC_STATUS_OK                  = 0
+	GRPC_STATUS_CANCELED            = 1
+	GRPC_STATUS_UNKNOWN             = 2
+	GRPC_STATUS_INVALID_ARGUMENT    = 3
+	GRPC_STATUS_DEADLINE_EXCEEDED   = 4
+	GRPC_STATUS_NOT_FOUND           = 5
+	GRPC_STATUS_ALREADY_EXISTS      = 6
+	GRPC_STATUS_PERMISSION_DENIED   = 7
+	GRPC_STATUS_RESOURCE_EXHAUSTED  = 8
+	GRPC_STATUS_FAILED_PRECONDITION = 9
+	GRPC_STATUS_ABORTED             = 10
+	GRPC_STATUS_OUT_OF_RANGE        = 11
+	GRPC_STATUS_UNIMPLEMENTED       = 12
+	GRPC_STATUS_INTERNAL            = 13
+	GRPC_STATUS_UNAVAILABLE         = 14
+	GRPC_STATUS_DATA_LOSS           = 15
+	GRPC_STATUS_UNAUTHENTICATED     = 16
+)
+
+// GrpcMaxRecvSize 单个grpc请求消息的最大长度
+var GrpcMaxRecvSize = 4 << 20
+
+// grpcStatusOfHttp 框架内以http状态码表示的错误(认证、鉴权、幂等冲突、限流)
+var grpcStatusOfHttp = map[int]int{
+	401: GRPC_STATUS_UNAUTHENTICATED,
+	403: GRPC_STATUS_PERMISSION_DENIED,
+	409: GRPC_STATUS_ABORTED,
+	429: GRPC_STATUS_RESOURCE_EXHAUSTED,
+}
+
+// GrpcStatusOf 将action返回的错误映射为grpc状态码, play.Err的code在1~16之间时直接作为grpc状态码
+var GrpcStatusOf = func(err error) (code int, message string) {
+	if e, ok := err.(play.Err); ok {
+		if message = e.Tip(); message == "" {
+			message = e.Error()
+		}
+		if c := e.Code(); c > GRPC_STATUS_OK && c <= GRPC_STATUS_UNAUTHENTICATED {
+			return c, message
+		} else if c, ok := grpcStatusOfHttp[c]; ok {
+			return c, message
+		}
+		return GRPC_STATUS_UNKNOWN, message
+	}
+
+	message = err.Error()
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return GRPC_STATUS_DEADLINE_EXCEEDED, message
+	case errors.Is(err, context.Canceled):
+		return GRPC_STATUS_CANCELED, message
+	case strings.HasPrefix(message, "can not find action"):
+		return GRPC_STATUS_UNIMPLEMENTED, message
+	case strings.HasPrefix(message, "input: "):
+		return GRPC_STATUS_INVALID_ARGUMENT, message
+	}
+	return GRPC_STATUS_UNKNOWN, message
+}
+
+// setGrpcStatus 通过trailer返回grpc-status及grpc-message
+func setGrpcStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGrpcMessage(message))
+	} else {
+		w.Header().Del(http.TrailerPrefix + "Grpc-Message")
+	}
+}
+
+// grpcFailed 已返回非0的grpc-status, 同一请求的后续消息不再处理
+func grpcFailed(w http.ResponseWriter) bool {
+	status := w.Header().Get(http.TrailerPrefix + "Grpc-Status")
+	return status != "" && status != "0"
+}
+
+// encodeGrpcMessage grpc-message需对非可打印字符及'%'做百分号编码
+func encodeGrpcMessage(message string) string {
+	var sb strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return sb.String()
+}
+
+func DecodeGrpcMessage(message string) string {
+	if !strings.Contains(message, "%") {
+		return message
+	}
+	var buf = make([]byte, 0, len(message))
+	for i := 0; i < len(message); i++ {
+		if message[i] == '%' && i+2 < len(message) {
+			if c, err := strconv.ParseUint(message[i+1:i+3], 16, 8); err == nil {
+				buf = append(buf, byte(c))
+				i += 2
+				continue
+			}
+		}
+		buf = append(buf, message[i])
+	}
+	return string(buf)
+}
+
+// ParseGrpcTimeout 解析grpc-timeout请求头, 如 100m、5S
+func ParseGrpcTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, errors.New("invalid grpc-timeout: " + value)
+	}
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid grpc-timeout: " + value)
+	}
+	var unit time.Duration
+	switch value[len(value)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, errors.New("invalid grpc-timeout unit: " + value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// EncodeGrpcTimeout 以毫秒为单位编码grpc-timeout, 最多8位数字
+func EncodeGrpcTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	if ms := d.Milliseconds(); ms < 1e8 {
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "S"
+}
+
+// parseGrpcMetadata 将非保留请求头作为元数据, -bin结尾的值做base64解码
+func parseGrpcMetadata(header http.Header) map[string]string {
+	var metadata map[string]string
+	for k, v := range header {
+		key := strings.ToLower(k)
+		if len(v) == 0 || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "te" || key == "user-agent" {
+			continue
+		}
+		value := strings.Join(v, ",")
+		if strings.HasSuffix(key, "-bin") {
+			if b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "=")); err == nil {
+				value = string(b)
+			}
+		}
+		if metadata == nil {
+			metadata = make(map[string]string, len(header))
+		}
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// SetGrpcMetadata 将元数据写入请求头或响应头, -bin结尾的值做base64编码
+func SetGrpcMetadata(header http.Header, metadata map[string]string) {
+	for k, v := range metadata {
+		if strings.HasSuffix(strings.ToLower(k), "-bin") {
+			v = base64.RawStdEncoding.EncodeToString([]byte(v))
+		}
+		header.Set(k, v)
+	}
+}
+
+// EncodeGrpcFrame 1字节压缩标记 + 4字节大端长度 + 消息
+func EncodeGrpcFrame(message []byte) []byte {
+	data := make([]byte, 5+len(message))
+	binary.BigEndian.PutUint32(data[1:5], uint32(len(message)))
+	copy(data[5:], message)
+	return data
+}
+
+// ReadGrpcFrame 读取一个grpc消息, 无后续消息时返回io.EOF
+func ReadGrpcFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0] != 0 {
+		return nil, errors.New("grpc compressed message unsupported")
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if maxSize > 0 && int64(size) > int64(maxSize) {
+		return nil, errors.New("grpc message size " + strconv.FormatUint(uint64(size), 10) + " exceeds limit " + strconv.Itoa(maxSize))
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return message, nil
+}
@@ -0,0 +1,318 @@
+package packers
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"unsafe"
+
+	"github.com/leochen2038/play"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	GRPC_HEALTH_UNKNOWN         protoreflect.EnumNumber = 0
+	GRPC_HEALTH_SERVING         protoreflect.EnumNumber = 1
+	GRPC_HEALTH_NOT_SERVING     protoreflect.EnumNumber = 2
+	GRPC_HEALTH_SERVICE_UNKNOWN protoreflect.EnumNumber = 3
+)
+
+// grpcFiles 供reflection服务查询的proto文件, 包括NewPbPacker传入的文件及其依赖
+var grpcFiles = struct {
+	sync.RWMutex
+	registry *protoregistry.Files
+}{registry: new(protoregistry.Files)}
+
+var grpcHealth sync.Map
+var grpcServicesOnce sync.Once
+
+// SetServingStatus 设置grpc.health.v1.Health返回的服务状态, service为空表示整个服务
+func SetServingStatus(service string, serving bool) {
+	if serving {
+		grpcHealth.Store(service, GRPC_HEALTH_SERVING)
+	} else {
+		grpcHealth.Store(service, GRPC_HEALTH_NOT_SERVING)
+	}
+}
+
+func getServingStatus(service string) (protoreflect.EnumNumber, bool) {
+	if v, ok := grpcHealth.Load(service); ok {
+		return v.(protoreflect.EnumNumber), true
+	}
+	if service == "" {
+		return GRPC_HEALTH_SERVING, true
+	}
+	return GRPC_HEALTH_SERVICE_UNKNOWN, false
+}
+
+func registerGrpcFiles(fileDescriptors ...protoreflect.FileDescriptor) {
+	grpcFiles.Lock()
+	defer grpcFiles.Unlock()
+	for _, fd := range fileDescriptors {
+		_registerGrpcFile(fd)
+	}
+}
+
+func _registerGrpcFile(fd protoreflect.FileDescriptor) {
+	if _, err := grpcFiles.registry.FindFileByPath(fd.Path()); err == nil {
+		return
+	}
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		_registerGrpcFile(imports.Get(i).FileDescriptor)
+	}
+	_ = grpcFiles.registry.RegisterFile(fd)
+}
+
+// registerGrpcServices 注册标准的health及reflection服务
+func registerGrpcServices() {
+	grpcServicesOnce.Do(func() {
+		registerGrpcFiles(newHealthFile(), newReflectionFile("grpc.reflection.v1alpha"), newReflectionFile("grpc.reflection.v1"))
+
+		play.RegisterAction("grpc.health.v1.Health.Check", nil, func() interface{} {
+			return play.NewProcessorWrap(new(grpcHealthCheck), func(p play.Processor, ctx *play.Context) (string, error) {
+				return play.RunProcessor(unsafe.Pointer(p.(*grpcHealthCheck)), unsafe.Sizeof(*p.(*grpcHealthCheck)), p, ctx)
+			}, nil)
+		})
+		play.RegisterAction("grpc.health.v1.Health.Watch", nil, func() interface{} {
+			return play.NewProcessorWrap(new(grpcHealthWatch), func(p play.Processor, ctx *play.Context) (string, error) {
+				return play.RunProcessor(unsafe.Pointer(p.(*grpcHealthWatch)), unsafe.Sizeof(*p.(*grpcHealthWatch)), p, ctx)
+			}, nil)
+		})
+		for _, name := range []string{"grpc.reflection.v1alpha.ServerReflection.ServerReflectionInfo", "grpc.reflection.v1.ServerReflection.ServerReflectionInfo"} {
+			play.RegisterAction(name, nil, func() interface{} {
+				return play.NewProcessorWrap(new(grpcReflection), func(p play.Processor, ctx *play.Context) (string, error) {
+					return play.RunProcessor(unsafe.Pointer(p.(*grpcReflection)), unsafe.Sizeof(*p.(*grpcReflection)), p, ctx)
+				}, nil)
+			})
+		}
+	})
+}
+
+type grpcHealthCheck struct {
+	Input struct {
+		Service string `key:"service"`
+	}
+	Output struct {
+		Status protoreflect.EnumNumber `key:"status"`
+	}
+}
+
+func (p *grpcHealthCheck) Run(ctx *play.Context) (string, error) {
+	var ok bool
+	if p.Output.Status, ok = getServingStatus(p.Input.Service); !ok {
+		return "", play.WrapErr(errors.New("unknown service " + p.Input.Service)).WrapCode(GRPC_STATUS_NOT_FOUND)
+	}
+	return "", nil
+}
+
+// grpcHealthWatch 返回当前状态后结束, 客户端按grpc规范重新发起Watch
+type grpcHealthWatch struct {
+	Input struct {
+		Service string `key:"service"`
+	}
+	Output struct {
+		Status protoreflect.EnumNumber `key:"status"`
+	}
+}
+
+func (p *grpcHealthWatch) Run(ctx *play.Context) (string, error) {
+	p.Output.Status, _ = getServingStatus(p.Input.Service)
+	return "", nil
+}
+
+type grpcReflection struct {
+	Input struct {
+		Host                      string `key:"host"`
+		FileByFilename            string `key:"file_by_filename"`
+		FileContainingSymbol      string `key:"file_containing_symbol"`
+		AllExtensionNumbersOfType string `key:"all_extension_numbers_of_type"`
+		FileContainingExtension   struct {
+			ContainingType  string `key:"containing_type"`
+			ExtensionNumber int32  `key:"extension_number"`
+		} `key:"file_containing_extension"`
+	}
+	Output struct {
+		ValidHost string `key:"valid_host"`
+	}
+}
+
+func (p *grpcReflection) Run(ctx *play.Context) (string, error) {
+	var err error
+	var fd protoreflect.FileDescriptor
+	p.Output.ValidHost = p.Input.Host
+
+	grpcFiles.RLock()
+	defer grpcFiles.RUnlock()
+	switch {
+	case p.Input.FileByFilename != "":
+		fd, err = grpcFiles.registry.FindFileByPath(p.Input.FileByFilename)
+	case p.Input.FileContainingSymbol != "":
+		var d protoreflect.Descriptor
+		if d, err = grpcFiles.registry.FindDescriptorByName(protoreflect.FullName(p.Input.FileContainingSymbol)); err == nil {
+			fd = d.ParentFile()
+		}
+	case p.Input.FileContainingExtension.ContainingType != "":
+		err = errors.New("extension not found")
+	case p.Input.AllExtensionNumbersOfType != "":
+		ctx.Response.Output.Set("all_extension_numbers_response", map[string]interface{}{"base_type_name": p.Input.AllExtensionNumbersOfType})
+		return "", nil
+	default:
+		var services []interface{}
+		var names []string
+		grpcFiles.registry.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			for i := 0; i < fd.Services().Len(); i++ {
+				names = append(names, string(fd.Services().Get(i).FullName()))
+			}
+			return true
+		})
+		sort.Strings(names)
+		for _, name := range names {
+			services = append(services, map[string]interface{}{"name": name})
+		}
+		ctx.Response.Output.Set("list_services_response", map[string]interface{}{"service": services})
+		return "", nil
+	}
+
+	if err != nil {
+		ctx.Response.Output.Set("error_response", map[string]interface{}{"error_code": int32(GRPC_STATUS_NOT_FOUND), "error_message": err.Error()})
+		return "", nil
+	}
+
+	var files []interface{}
+	var sent = make(map[string]bool)
+	if err = appendFileDescriptor(fd, sent, &files); err != nil {
+		ctx.Response.Output.Set("error_response", map[string]interface{}{"error_code": int32(GRPC_STATUS_INTERNAL), "error_message": err.Error()})
+		return "", nil
+	}
+	ctx.Response.Output.Set("file_descriptor_response", map[string]interface{}{"file_descriptor_proto": files})
+	return "", nil
+}
+
+// appendFileDescriptor 返回文件及其依赖, 客户端无需再逐个查询依赖
+func appendFileDescriptor(fd protoreflect.FileDescriptor, sent map[string]bool, files *[]interface{}) error {
+	if sent[fd.Path()] {
+		return nil
+	}
+	sent[fd.Path()] = true
+	data, err := proto.Marshal(protodesc.ToFileDescriptorProto(fd))
+	if err != nil {
+		return err
+	}
+	*files = append(*files, data)
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err = appendFileDescriptor(imports.Get(i).FileDescriptor, sent, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newHealthFile() protoreflect.FileDescriptor {
+	return newFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("grpc/health/v1/health.proto"),
+		Package: proto.String("grpc.health.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("HealthCheckRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("service", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+			}},
+			{Name: proto.String("HealthCheckResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("status", 1, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".grpc.health.v1.HealthCheckResponse.ServingStatus", false, nil),
+			}, EnumType: []*descriptorpb.EnumDescriptorProto{
+				{Name: proto.String("ServingStatus"), Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(int32(GRPC_HEALTH_UNKNOWN))},
+					{Name: proto.String("SERVING"), Number: proto.Int32(int32(GRPC_HEALTH_SERVING))},
+					{Name: proto.String("NOT_SERVING"), Number: proto.Int32(int32(GRPC_HEALTH_NOT_SERVING))},
+					{Name: proto.String("SERVICE_UNKNOWN"), Number: proto.Int32(int32(GRPC_HEALTH_SERVICE_UNKNOWN))},
+				}},
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{Name: proto.String("Health"), Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Check"), InputType: proto.String(".grpc.health.v1.HealthCheckRequest"), OutputType: proto.String(".grpc.health.v1.HealthCheckResponse")},
+				{Name: proto.String("Watch"), InputType: proto.String(".grpc.health.v1.HealthCheckRequest"), OutputType: proto.String(".grpc.health.v1.HealthCheckResponse"), ServerStreaming: proto.Bool(true)},
+			}},
+		},
+	})
+}
+
+// newReflectionFile v1与v1alpha的消息定义相同, 仅package不同
+func newReflectionFile(pkg string) protoreflect.FileDescriptor {
+	var oneof = proto.Int32(0)
+	var typeName = func(name string) string { return "." + pkg + "." + name }
+	return newFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("grpc/reflection/" + pkg[len("grpc.reflection."):] + "/reflection.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("ServerReflectionRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("host", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+				newField("file_by_filename", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, oneof),
+				newField("file_containing_symbol", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, oneof),
+				newField("file_containing_extension", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ExtensionRequest"), false, oneof),
+				newField("all_extension_numbers_of_type", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, oneof),
+				newField("list_services", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, oneof),
+			}, OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("message_request")}}},
+			{Name: proto.String("ExtensionRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("containing_type", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+				newField("extension_number", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false, nil),
+			}},
+			{Name: proto.String("ServerReflectionResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("valid_host", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+				newField("original_request", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ServerReflectionRequest"), false, nil),
+				newField("file_descriptor_response", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("FileDescriptorResponse"), false, oneof),
+				newField("all_extension_numbers_response", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ExtensionNumberResponse"), false, oneof),
+				newField("list_services_response", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ListServiceResponse"), false, oneof),
+				newField("error_response", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ErrorResponse"), false, oneof),
+			}, OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("message_response")}}},
+			{Name: proto.String("FileDescriptorResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("file_descriptor_proto", 1, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "", true, nil),
+			}},
+			{Name: proto.String("ExtensionNumberResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("base_type_name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+				newField("extension_number", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", true, nil),
+			}},
+			{Name: proto.String("ListServiceResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("service", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, typeName("ServiceResponse"), true, nil),
+			}},
+			{Name: proto.String("ServiceResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+			}},
+			{Name: proto.String("ErrorResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				newField("error_code", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false, nil),
+				newField("error_message", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false, nil),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{Name: proto.String("ServerReflection"), Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("ServerReflectionInfo"), InputType: proto.String(typeName("ServerReflectionRequest")), OutputType: proto.String(typeName("ServerReflectionResponse")), ClientStreaming: proto.Bool(true), ServerStreaming: proto.Bool(true)},
+			}},
+		},
+	})
+}
+
+func newField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool, oneof *int32) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	field := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum(), OneofIndex: oneof}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+func newFile(fdp *descriptorpb.FileDescriptorProto) protoreflect.FileDescriptor {
+	fd, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		panic(err)
+	}
+	return fd
+}
@@ -0,0 +1,152 @@
+package packers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	grpcModeNative  = 0
+	grpcModeWeb     = 1
+	grpcModeWebText = 2
+	grpcModeJson    = 3
+)
+
+// GrpcJsonMarshalOptions json转码时的输出选项, 默认使用protojson的lowerCamelCase字段名
+var GrpcJsonMarshalOptions = protojson.MarshalOptions{}
+
+func grpcModeOf(r *http.Request) int {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/grpc-web-text"):
+		return grpcModeWebText
+	case strings.HasPrefix(contentType, "application/grpc-web"):
+		return grpcModeWeb
+	case strings.HasPrefix(contentType, "application/grpc"):
+		return grpcModeNative
+	}
+	return grpcModeJson
+}
+
+// grpcContentType grpc-web的响应Content-Type与请求一致
+func grpcContentType(r *http.Request, mode int) string {
+	switch mode {
+	case grpcModeWeb, grpcModeWebText:
+		if contentType := r.Header.Get("Content-Type"); strings.Contains(contentType, "+") {
+			return contentType
+		}
+		if mode == grpcModeWebText {
+			return "application/grpc-web-text+proto"
+		}
+		return "application/grpc-web+proto"
+	case grpcModeJson:
+		return "application/json; charset=utf-8"
+	}
+	return "application/grpc"
+}
+
+// grpcWebTrailer grpc-web将状态以0x80标记的帧放在body末尾
+func grpcWebTrailer(code int, message string) []byte {
+	trailer := "grpc-status:" + strconv.Itoa(code) + "\r\n"
+	if message != "" {
+		trailer += "grpc-message:" + encodeGrpcMessage(message) + "\r\n"
+	}
+	data := make([]byte, 5+len(trailer))
+	data[0] = 0x80
+	binary.BigEndian.PutUint32(data[1:5], uint32(len(trailer)))
+	copy(data[5:], trailer)
+	return data
+}
+
+// grpcWebTextBody 解码grpc-web-text请求体, 可能由多段带padding的base64拼接而成
+type grpcWebTextBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func newGrpcWebTextBody(body io.ReadCloser) *grpcWebTextBody {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return &grpcWebTextBody{Reader: &errReader{err: err}, body: body}
+	}
+	data = bytes.Join(bytes.Fields(data), nil)
+
+	var decoded []byte
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '=')
+		if end < 0 {
+			end = len(data)
+		}
+		for end < len(data) && data[end] == '=' {
+			end++
+		}
+		chunk, err := base64.StdEncoding.DecodeString(string(data[:end]))
+		if err != nil {
+			return &grpcWebTextBody{Reader: &errReader{err: err}, body: body}
+		}
+		decoded = append(decoded, chunk...)
+		data = data[end:]
+	}
+	return &grpcWebTextBody{Reader: bytes.NewReader(decoded), body: body}
+}
+
+func (b *grpcWebTextBody) Close() error {
+	return b.body.Close()
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+// transcodeToJson 将protobuf编码的消息转为protojson
+func transcodeToJson(descriptor protoreflect.MessageDescriptor, data []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(descriptor)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return GrpcJsonMarshalOptions.Marshal(msg)
+}
+
+// HttpStatusOfGrpc grpc状态码对应的http状态码, 用于json转码的错误响应
+func HttpStatusOfGrpc(code int) int {
+	switch code {
+	case GRPC_STATUS_OK:
+		return http.StatusOK
+	case GRPC_STATUS_CANCELED:
+		return 499
+	case GRPC_STATUS_INVALID_ARGUMENT, GRPC_STATUS_FAILED_PRECONDITION, GRPC_STATUS_OUT_OF_RANGE:
+		return http.StatusBadRequest
+	case GRPC_STATUS_DEADLINE_EXCEEDED:
+		return http.StatusGatewayTimeout
+	case GRPC_STATUS_NOT_FOUND:
+		return http.StatusNotFound
+	case GRPC_STATUS_ALREADY_EXISTS, GRPC_STATUS_ABORTED:
+		return http.StatusConflict
+	case GRPC_STATUS_PERMISSION_DENIED:
+		return http.StatusForbidden
+	case GRPC_STATUS_UNAUTHENTICATED:
+		return http.StatusUnauthorized
+	case GRPC_STATUS_RESOURCE_EXHAUSTED:
+		return http.StatusTooManyRequests
+	case GRPC_STATUS_UNIMPLEMENTED:
+		return http.StatusNotImplemented
+	case GRPC_STATUS_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
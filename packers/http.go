@@ -31,7 +31,7 @@ func (p *HttpPacker) Pack(c *play.Conn, res *play.Response) (data []byte, err er
 	case "json":
 		c.Http.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
 		c.Http.ResponseWriter.Header().Set("Cache-Control", "no-cache, must-revalidate, max-age=0")
-		return renders.GetRenderOfJson().Render(res.Output.All())
+		return renders.GetRenderOfJson().Render(packOutput(c, res))
 	default:
 		return nil, errors.New("undefined " + res.RenderName + " http response render")
 	}
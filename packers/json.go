@@ -39,5 +39,13 @@ func (m *JsonPacker) Receive(c *play.Conn) (*play.Request, error) {
 }
 
 func (m *JsonPacker) Pack(c *play.Conn, res *play.Response) (data []byte, err error) {
-	return renders.GetRenderOfJson().Render(res.Output.All())
+	return renders.GetRenderOfJson().Render(packOutput(c, res))
+}
+
+// packOutput 推送消息包装为{"topic": action, "data": output}以便客户端区分; sse由event行标明action, 不包装
+func packOutput(c *play.Conn, res *play.Response) map[string]interface{} {
+	if res.Action == "" || c.Type == play.SERVER_TYPE_SSE {
+		return res.Output.All()
+	}
+	return map[string]interface{}{"topic": res.Action, "data": res.Output.All()}
 }
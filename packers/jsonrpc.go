@@ -0,0 +1,293 @@
+package packers
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/codec/binders"
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/codec/renders"
+)
+
+const (
+	JSONRPC_PARSE_ERROR      = -32700
+	JSONRPC_INVALID_REQUEST  = -32600
+	JSONRPC_METHOD_NOT_FOUND = -32601
+	JSONRPC_INVALID_PARAMS   = -32602
+	JSONRPC_INTERNAL_ERROR   = -32603
+	JSONRPC_SERVER_ERROR     = -32000
+)
+
+// JsonRpcCallTimeout 批量请求等待汇总响应的最长时间, 超时未完成的记录将被清理
+var JsonRpcCallTimeout = time.Minute
+
+// JsonRpcErrorOf 将action返回的错误映射为jsonrpc错误对象, play.Err的code非0时作为错误码
+var JsonRpcErrorOf = func(err error) (code int, message string, data interface{}) {
+	if e, ok := err.(play.Err); ok {
+		if message = e.Tip(); message == "" {
+			message = e.Error()
+		}
+		if code = e.Code(); code == 0 {
+			code = JSONRPC_SERVER_ERROR
+		}
+		if len(e.Attach()) > 0 {
+			data = e.Attach()
+		}
+		return
+	}
+
+	message = err.Error()
+	switch {
+	case strings.HasPrefix(message, "can not find action"):
+		return JSONRPC_METHOD_NOT_FOUND, message, nil
+	case strings.HasPrefix(message, "input: "):
+		return JSONRPC_INVALID_PARAMS, message, nil
+	}
+	return JSONRPC_SERVER_ERROR, message, nil
+}
+
+// JsonRpcPacker 实现JSON-RPC 2.0, method对应action名称, params作为输入;
+// 无id的通知不响应, 批量请求的响应在全部完成后以数组返回
+type JsonRpcPacker struct {
+	seq       uint64
+	lastSweep int64
+	pending   sync.Map // *play.Conn -> *jsonrpcBatch, 尚未Receive完的批量请求
+	calls     sync.Map // RequestId -> *jsonrpcCall, 需在Pack时补充信息的请求
+}
+
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type jsonrpcCall struct {
+	id    json.RawMessage
+	err   *jsonrpcError
+	batch *jsonrpcBatch
+	time  time.Time
+}
+
+type jsonrpcBatch struct {
+	items   []json.RawMessage
+	next    int
+	wait    int
+	time    time.Time
+	mutex   sync.Mutex
+	results [][]byte
+}
+
+func NewJsonRpcPacker() play.IPacker {
+	return new(JsonRpcPacker)
+}
+
+func (p *JsonRpcPacker) Receive(c *play.Conn) (*play.Request, error) {
+	if v, ok := p.pending.Load(c); ok {
+		return p.nextOfBatch(c, v.(*jsonrpcBatch)), nil
+	}
+
+	var err error
+	var data []byte
+	switch c.Type {
+	case play.SERVER_TYPE_HTTP, play.SERVER_TYPE_H2C, play.SERVER_TYPE_HTTP3:
+		if data, err = ioutil.ReadAll(c.Http.Request.Body); err != nil {
+			return nil, err
+		}
+	case play.SERVER_TYPE_WS:
+		if data = c.Websocket.Message; len(data) == 0 {
+			return nil, nil
+		}
+	default:
+		return nil, errors.New("jsonrpc packer not support " + strconv.Itoa(c.Type) + " type")
+	}
+
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		if err = json.Unmarshal(data, &items); err != nil {
+			return p.errorRequest(nil, &jsonrpcError{Code: JSONRPC_PARSE_ERROR, Message: "Parse error"}, nil), nil
+		}
+		if len(items) == 0 {
+			return p.errorRequest(nil, &jsonrpcError{Code: JSONRPC_INVALID_REQUEST, Message: "Invalid Request"}, nil), nil
+		}
+		return p.newBatch(c, items), nil
+	}
+
+	var obj map[string]json.RawMessage
+	if err = json.Unmarshal(data, &obj); err != nil {
+		return p.errorRequest(nil, &jsonrpcError{Code: JSONRPC_PARSE_ERROR, Message: "Parse error"}, nil), nil
+	}
+	return p.parseCall(obj, nil), nil
+}
+
+func (p *JsonRpcPacker) Pack(c *play.Conn, res *play.Response) ([]byte, error) {
+	if c.Type != play.SERVER_TYPE_WS && c.Http.ResponseWriter != nil {
+		c.Http.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	// 服务端推送以通知的形式发送
+	if res.Action != "" {
+		params, err := renders.GetRenderOfJson().Render(res.Output.All())
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(struct {
+			Jsonrpc string          `json:"jsonrpc"`
+			Method  string          `json:"method"`
+			Params  json.RawMessage `json:"params"`
+		}{"2.0", res.Action, params})
+	}
+
+	if !strings.HasPrefix(res.RequestId, "#") {
+		return p.marshal(json.RawMessage(res.RequestId), nil, res)
+	}
+
+	v, ok := p.calls.Load(res.RequestId)
+	if !ok {
+		return nil, errors.New("jsonrpc call " + res.RequestId + " not found")
+	}
+	p.calls.Delete(res.RequestId)
+	call := v.(*jsonrpcCall)
+	data, err := p.marshal(call.id, call.err, res)
+	if err != nil || call.batch == nil {
+		return data, err
+	}
+
+	call.batch.mutex.Lock()
+	defer call.batch.mutex.Unlock()
+	if call.batch.results = append(call.batch.results, data); len(call.batch.results) < call.batch.wait {
+		return nil, nil
+	}
+	return append(append([]byte{'['}, bytes.Join(call.batch.results, []byte{','})...), ']'), nil
+}
+
+func (p *JsonRpcPacker) marshal(id json.RawMessage, rpcErr *jsonrpcError, res *play.Response) ([]byte, error) {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	if rpcErr == nil && res.Error != nil {
+		rpcErr = new(jsonrpcError)
+		rpcErr.Code, rpcErr.Message, rpcErr.Data = JsonRpcErrorOf(res.Error)
+	}
+	if rpcErr != nil {
+		return json.Marshal(struct {
+			Jsonrpc string          `json:"jsonrpc"`
+			Error   *jsonrpcError   `json:"error"`
+			Id      json.RawMessage `json:"id"`
+		}{"2.0", rpcErr, id})
+	}
+
+	result, err := renders.GetRenderOfJson().Render(res.Output.All())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Jsonrpc string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result"`
+		Id      json.RawMessage `json:"id"`
+	}{"2.0", result, id})
+}
+
+// parseCall 解析单个请求对象, batch非空时为批量请求中的一项
+func (p *JsonRpcPacker) parseCall(obj map[string]json.RawMessage, batch *jsonrpcBatch) *play.Request {
+	id, hasId := obj["id"]
+	method, ok := jsonrpcMethod(obj)
+	if !ok {
+		return p.errorRequest(id, &jsonrpcError{Code: JSONRPC_INVALID_REQUEST, Message: "Invalid Request"}, batch)
+	}
+
+	params := []byte(obj["params"])
+	if len(params) == 0 {
+		params = []byte("{}")
+	}
+	request := &play.Request{RenderName: "json", ActionName: method, InputBinder: binders.GetBinderOfJson(params)}
+	switch {
+	case !hasId:
+		request.NonRespond = true
+	case batch != nil:
+		request.RequestId = p.register(&jsonrpcCall{id: id, batch: batch})
+	default:
+		request.RequestId = string(id)
+	}
+	return request
+}
+
+// errorRequest 非法请求同样交由框架走完流程, 在Pack时返回对应的错误对象
+func (p *JsonRpcPacker) errorRequest(id json.RawMessage, rpcErr *jsonrpcError, batch *jsonrpcBatch) *play.Request {
+	return &play.Request{RenderName: "json", RequestId: p.register(&jsonrpcCall{id: id, err: rpcErr, batch: batch})}
+}
+
+func (p *JsonRpcPacker) newBatch(c *play.Conn, items []json.RawMessage) *play.Request {
+	batch := &jsonrpcBatch{items: items, time: time.Now()}
+	for _, item := range items {
+		var obj map[string]json.RawMessage
+		_ = json.Unmarshal(item, &obj)
+		if _, ok := jsonrpcMethod(obj); !ok {
+			batch.wait++
+		} else if _, ok := obj["id"]; ok {
+			batch.wait++
+		}
+	}
+	if len(items) > 1 {
+		p.pending.Store(c, batch)
+	}
+	return p.nextOfBatch(c, batch)
+}
+
+func (p *JsonRpcPacker) nextOfBatch(c *play.Conn, batch *jsonrpcBatch) *play.Request {
+	var obj map[string]json.RawMessage
+	_ = json.Unmarshal(batch.items[batch.next], &obj)
+	request := p.parseCall(obj, batch)
+	if batch.next++; batch.next < len(batch.items) {
+		request.More = true
+	} else {
+		p.pending.Delete(c)
+	}
+	return request
+}
+
+// jsonrpcMethod 校验请求对象, 返回method
+func jsonrpcMethod(obj map[string]json.RawMessage) (method string, ok bool) {
+	var version string
+	if obj == nil || json.Unmarshal(obj["jsonrpc"], &version) != nil || version != "2.0" {
+		return "", false
+	}
+	if json.Unmarshal(obj["method"], &method) != nil || method == "" {
+		return "", false
+	}
+	return method, true
+}
+
+func (p *JsonRpcPacker) register(call *jsonrpcCall) string {
+	id := "#" + strconv.FormatUint(atomic.AddUint64(&p.seq, 1), 10)
+	call.time = time.Now()
+	p.calls.Store(id, call)
+	p.sweep(call.time)
+	return id
+}
+
+// sweep 每秒最多一次, 清理因hook取消响应或连接中断而未完成的记录
+func (p *JsonRpcPacker) sweep(now time.Time) {
+	if last := atomic.LoadInt64(&p.lastSweep); now.Unix() == last || !atomic.CompareAndSwapInt64(&p.lastSweep, last, now.Unix()) {
+		return
+	}
+	expire := now.Add(-JsonRpcCallTimeout)
+	p.calls.Range(func(key, value interface{}) bool {
+		if value.(*jsonrpcCall).time.Before(expire) {
+			p.calls.Delete(key)
+		}
+		return true
+	})
+	p.pending.Range(func(key, value interface{}) bool {
+		if value.(*jsonrpcBatch).time.Before(expire) {
+			p.pending.Delete(key)
+		}
+		return true
+	})
+}
@@ -1,16 +1,21 @@
 package packers
 
 import (
-	"encoding/binary"
+	"bytes"
+	"encoding/base64"
 	"errors"
+	"io"
 	"io/ioutil"
-	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/leochen2038/play"
 	"github.com/leochen2038/play/codec/binders"
+	"github.com/leochen2038/play/codec/protos/golang/json"
 	"github.com/leochen2038/play/codec/renders"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/dynamicpb"
@@ -18,78 +23,247 @@ import (
 
 type PbPacker struct {
 	fileDescriptors []protoreflect.FileDescriptor
+	methods         sync.Map
+}
+
+// grpcMethod 由请求路径 /package.Service/Method 解析出的方法描述, 按路径缓存
+type grpcMethod struct {
+	input        protoreflect.MessageDescriptor
+	output       protoreflect.MessageDescriptor
+	clientStream bool
+	serverStream bool
 }
 
 func NewPbPacker(fileDescriptors []protoreflect.FileDescriptor) play.IPacker {
+	registerGrpcServices()
+	registerGrpcFiles(fileDescriptors...)
 	return &PbPacker{fileDescriptors: fileDescriptors}
 }
 
+// Receive 每次读取一个grpc消息, client/bidi streaming时由server循环调用直至io.EOF;
+// 按Content-Type同时支持grpc、grpc-web(binary/text)及json转码
 func (p *PbPacker) Receive(c *play.Conn) (*play.Request, error) {
 	var err error
+	var message []byte
 	var request play.Request
 	request.RenderName = "pb"
 
 	switch c.Type {
-	case play.SERVER_TYPE_HTTP, play.SERVER_TYPE_H2C:
-		request.ActionName = ParseHttp2Path(c.Http.Request.URL.Path)
-		request.InputBinder, err = getBinderOfProtobuf(c.Http.Request, p.fileDescriptors)
+	case play.SERVER_TYPE_HTTP, play.SERVER_TYPE_H2C, play.SERVER_TYPE_HTTP3:
 	default:
-		return nil, errors.New("json packer not support " + strconv.Itoa(c.Type) + " type")
+		return nil, errors.New("pb packer not support " + strconv.Itoa(c.Type) + " type")
+	}
+
+	r, w := c.Http.Request, c.Http.ResponseWriter
+	mode := grpcModeOf(r)
+	w.Header().Set("Content-Type", grpcContentType(r, mode))
+	if grpcFailed(w) {
+		return nil, io.EOF
+	}
+
+	method := p.getMethod(r.URL.Path)
+	if method == nil {
+		p.fail(c, mode, GRPC_STATUS_UNIMPLEMENTED, "unknown method "+r.URL.Path)
+		return nil, errors.New("descriptor not found")
+	}
+
+	msg := dynamicpb.NewMessage(method.input)
+	if mode == grpcModeJson {
+		if message, err = ioutil.ReadAll(r.Body); err == nil && len(bytes.TrimSpace(message)) > 0 {
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(message, msg)
+		}
+		if err != nil {
+			p.fail(c, mode, GRPC_STATUS_INVALID_ARGUMENT, "unmarshal json request message err:"+err.Error())
+			return nil, errors.New("unmarshal json request message err:" + err.Error())
+		}
+	} else {
+		if mode == grpcModeWebText {
+			if _, ok := r.Body.(*grpcWebTextBody); !ok {
+				r.Body = newGrpcWebTextBody(r.Body)
+			}
+		}
+		if message, err = p.readMessage(c); err != nil {
+			if err == io.EOF && method.clientStream {
+				if !grpcFailed(w) {
+					p.fail(c, mode, GRPC_STATUS_OK, "")
+				}
+				return nil, io.EOF
+			}
+			if err == io.EOF {
+				err = errors.New("grpc request message not found")
+			}
+			p.fail(c, mode, GRPC_STATUS_INTERNAL, err.Error())
+			return nil, err
+		}
+		if err = proto.Unmarshal(message, msg); err != nil {
+			p.fail(c, mode, GRPC_STATUS_INTERNAL, "unmarshal grpc request message err:"+err.Error())
+			return nil, errors.New("unmarshal grpc request message err:" + err.Error())
+		}
+
+		// client streaming只对最后一个消息响应, 预读下一个消息判断是否结束
+		if method.clientStream {
+			request.More = true
+			if !method.serverStream {
+				if c.Http.Surplus, err = p.readMessage(c); err == io.EOF {
+					request.More = false
+				} else if err != nil {
+					p.fail(c, mode, GRPC_STATUS_INTERNAL, err.Error())
+					return nil, err
+				} else {
+					request.NonRespond = true
+				}
+			}
+		}
 	}
 
-	return &request, err
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		if d, err := ParseGrpcTimeout(timeout); err == nil {
+			request.Deadline = time.Now().Add(d)
+		}
+	}
+	request.ActionName = ParseHttp2Path(r.URL.Path)
+	request.Metadata = parseGrpcMetadata(r.Header)
+	request.InputBinder = binders.GetBinderOfProtobuf(msg.ProtoReflect())
+	return &request, nil
 }
 
 func (p *PbPacker) Pack(c *play.Conn, res *play.Response) ([]byte, error) {
-	w := c.Http.ResponseWriter
-	w.Header().Set("content-type", "application/grpc")
-	w.Header().Set("status", "200")
-	w.Header().Set("trailer", "grpc-status, grpc-message")
-	w.Header().Set("grpc-status", "0")
-	w.Header().Set("grpc-message", "ok")
-
-	descriptor := getMessageDescriptor(c.Http.Request, p.fileDescriptors, false)
-	if descriptor == nil {
-		return nil, errors.New("descriptor not found")
+	var data []byte
+	r, w := c.Http.Request, c.Http.ResponseWriter
+	mode := grpcModeOf(r)
+	w.Header().Set("Content-Type", grpcContentType(r, mode))
+	SetGrpcMetadata(w.Header(), res.Metadata)
+
+	method := p.getMethod(r.URL.Path)
+	if method == nil {
+		return p.statusData(c, mode, GRPC_STATUS_UNIMPLEMENTED, "unknown method "+r.URL.Path), errors.New("descriptor not found")
 	}
 
-	data, err := renders.GetRenderOfProtobuf(descriptor).Render(res.Output.All())
-	if err != nil {
-		return nil, err
+	if res.Error != nil {
+		code, message := GrpcStatusOf(res.Error)
+		return p.statusData(c, mode, code, message), nil
 	}
 
-	head := make([]byte, 5)
-	binary.BigEndian.PutUint32(head[1:5], uint32(len(data)))
-	data = append(head, data...)
+	// server streaming的消息通过Session.Push发送, 最终输出为空时不再追加消息
+	if res.Action != "" || !method.serverStream || len(res.Output.All()) > 0 {
+		message, err := renders.GetRenderOfProtobuf(method.output).Render(res.Output.All())
+		if err == nil && mode == grpcModeJson {
+			message, err = transcodeToJson(method.output, message)
+			if method.serverStream {
+				message = append(message, '\n')
+			}
+		}
+		if err != nil {
+			return p.statusData(c, mode, GRPC_STATUS_INTERNAL, err.Error()), err
+		}
+		if mode == grpcModeJson {
+			data = message
+		} else {
+			data = EncodeGrpcFrame(message)
+		}
+	}
 
+	if res.Action == "" && !grpcFailed(w) {
+		switch mode {
+		case grpcModeWeb, grpcModeWebText:
+			data = append(data, grpcWebTrailer(GRPC_STATUS_OK, "")...)
+		case grpcModeNative:
+			setGrpcStatus(w, GRPC_STATUS_OK, "")
+		}
+	}
+	if mode == grpcModeWebText && len(data) > 0 {
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
 	return data, nil
 }
 
-func ParseHttp2Path(path string) string {
-	return strings.ReplaceAll(path[1:], "/", ".")
+// fail 在Receive阶段直接返回错误状态
+func (p *PbPacker) fail(c *play.Conn, mode int, code int, message string) {
+	if data := p.statusData(c, mode, code, message); len(data) > 0 {
+		_, _ = c.Http.ResponseWriter.Write(data)
+	}
 }
 
-func getBinderOfProtobuf(request *http.Request, fileDescriptors []protoreflect.FileDescriptor) (binders.Binder, error) {
-	descriptor := getMessageDescriptor(request, fileDescriptors, true)
-	if descriptor == nil {
-		return nil, errors.New("descriptor not found")
+// statusData grpc通过trailer返回状态; grpc-web在body末尾追加trailer帧; json返回对应的http状态码及错误信息
+func (p *PbPacker) statusData(c *play.Conn, mode int, code int, message string) []byte {
+	w := c.Http.ResponseWriter
+	switch mode {
+	case grpcModeWeb:
+		setGrpcStatus(w, code, message)
+		return grpcWebTrailer(code, message)
+	case grpcModeWebText:
+		setGrpcStatus(w, code, message)
+		return []byte(base64.StdEncoding.EncodeToString(grpcWebTrailer(code, message)))
+	case grpcModeJson:
+		if code == GRPC_STATUS_OK {
+			return nil
+		}
+		data, _ := json.Marshal(map[string]interface{}{"code": code, "message": message})
+		w.WriteHeader(HttpStatusOfGrpc(code))
+		return data
+	default:
+		setGrpcStatus(w, code, message)
+		return nil
+	}
+}
+
+func (p *PbPacker) readMessage(c *play.Conn) ([]byte, error) {
+	if message := c.Http.Surplus; message != nil {
+		c.Http.Surplus = nil
+		return message, nil
+	}
+	return ReadGrpcFrame(c.Http.Request.Body, GrpcMaxRecvSize)
+}
+
+func (p *PbPacker) getMethod(path string) *grpcMethod {
+	if v, ok := p.methods.Load(path); ok {
+		return v.(*grpcMethod)
 	}
-	msg := dynamicpb.NewMessage(descriptor)
 
-	data, err := ioutil.ReadAll(request.Body)
-	if err != nil {
-		return nil, errors.New("read grpc request message err:" + err.Error())
+	var method *grpcMethod
+	if md := findGrpcMethod(p.fileDescriptors, path); md != nil {
+		method = &grpcMethod{input: md.Input(), output: md.Output(), clientStream: md.IsStreamingClient(), serverStream: md.IsStreamingServer()}
+	} else if input, output := getMessageDescriptor(path, p.fileDescriptors); input != nil && output != nil {
+		method = &grpcMethod{input: input, output: output}
+	} else {
+		return nil
 	}
+	p.methods.Store(path, method)
+	return method
+}
 
-	err = proto.Unmarshal(data[5:], msg)
-	if err != nil {
-		return nil, errors.New("unmarshal grpc request message err:" + err.Error())
+func ParseHttp2Path(path string) string {
+	return strings.ReplaceAll(path[1:], "/", ".")
+}
+
+// findGrpcMethod 按 /package.Service/Method 查找方法, 未在fileDescriptors中找到时查找已注册的文件
+func findGrpcMethod(fileDescriptors []protoreflect.FileDescriptor, path string) protoreflect.MethodDescriptor {
+	index := strings.LastIndex(path, "/")
+	if index <= 0 {
+		return nil
 	}
-	return binders.GetBinderOfProtobuf(msg.ProtoReflect()), nil
+	service, method := protoreflect.FullName(strings.TrimPrefix(path[:index], "/")), protoreflect.Name(path[index+1:])
+	for _, fd := range fileDescriptors {
+		if fd.Package() != service.Parent() {
+			continue
+		}
+		if sd := fd.Services().ByName(service.Name()); sd != nil {
+			return sd.Methods().ByName(method)
+		}
+	}
+	grpcFiles.RLock()
+	defer grpcFiles.RUnlock()
+	if d, err := grpcFiles.registry.FindDescriptorByName(service); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			return sd.Methods().ByName(method)
+		}
+	}
+	return nil
 }
 
-func getMessageDescriptor(request *http.Request, fileDescriptors []protoreflect.FileDescriptor, isRequest bool) protoreflect.MessageDescriptor {
-	packName, requestName, responseName := parseHttp2Path(request)
+// getMessageDescriptor 未定义service时按 <Method>Request/<Method>Response 的命名约定查找消息
+func getMessageDescriptor(path string, fileDescriptors []protoreflect.FileDescriptor) (input protoreflect.MessageDescriptor, output protoreflect.MessageDescriptor) {
+	packName, requestName, responseName := parseHttp2Path(path)
 	var fileDescriptor protoreflect.FileDescriptor
 	for _, descriptor := range fileDescriptors {
 		if packName == string(descriptor.Name()) {
@@ -97,19 +271,16 @@ func getMessageDescriptor(request *http.Request, fileDescriptors []protoreflect.
 		}
 	}
 	if fileDescriptor == nil {
-		return nil
-	}
-	if isRequest {
-		return fileDescriptor.Messages().ByName(protoreflect.Name(requestName))
+		return nil, nil
 	}
-	return fileDescriptor.Messages().ByName(protoreflect.Name(responseName))
+	return fileDescriptor.Messages().ByName(protoreflect.Name(requestName)), fileDescriptor.Messages().ByName(protoreflect.Name(responseName))
 }
 
-func parseHttp2Path(request *http.Request) (string, string, string) {
-	requestPath := strings.Split(request.URL.Path, "/")
+func parseHttp2Path(path string) (string, string, string) {
+	requestPath := strings.Split(path, "/")
 	requestName := requestPath[len(requestPath)-1] + "Request"
 	responseName := requestPath[len(requestPath)-1] + "Response"
-	requestPath = strings.Split(request.URL.Path, ".")
+	requestPath = strings.Split(path, ".")
 	packName := strings.TrimLeft(requestPath[0], "/")
 	return packName, requestName, responseName
 }
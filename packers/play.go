@@ -56,6 +56,8 @@ import (
 // 4  byte : rc错误码
 // 4  byte : body长度
 
+// protocol v5 见 pproto, 增加body压缩、crc32校验及metadata
+
 type PlayPacker struct {
 }
 
@@ -94,15 +96,19 @@ func (p *PlayPacker) Receive(c *play.Conn) (*play.Request, error) {
 		}
 
 		return &play.Request{
-			Version:     protocol.Version,
-			ActionName:  protocol.Action,
-			TraceId:     protocol.Header.TraceId,
-			SpanId:      protocol.Header.SpanId,
-			CallerId:    protocol.Header.CallerId,
-			TagId:       protocol.Header.TagId,
-			NonRespond:  protocol.NonRespond,
-			Deadline:    protocol.Header.Deadline,
-			InputBinder: binders.GetBinderOfJson(protocol.Body),
+			Version:        protocol.Version,
+			ActionName:     protocol.Action,
+			TraceId:        protocol.Header.TraceId,
+			SpanId:         protocol.Header.SpanId,
+			CallerId:       protocol.Header.CallerId,
+			TagId:          protocol.Header.TagId,
+			NonRespond:     protocol.NonRespond,
+			Deadline:       protocol.Header.Deadline,
+			Attach:         protocol.Attachment,
+			Metadata:       protocol.Header.Metadata,
+			IdempotencyKey: protocol.Header.IdempotencyKey,
+			Compress:       pproto.NegotiateCompress(protocol.Header.AcceptCompress),
+			InputBinder:    binders.GetBinderOfJson(protocol.Body),
 		}, nil
 	}
 	return nil, nil
@@ -134,8 +140,10 @@ func (p *PlayPacker) Pack(c *play.Conn, res *play.Response) (data []byte, err er
 			version = c.Quic.Version
 		}
 	}
-	response := pproto.PlayProtocolResponse{Version: version, ResultCode: rc, Body: body}
+	response := pproto.PlayProtocolResponse{Version: version, ResultCode: rc, Body: body, Compress: res.Compress, Attachment: res.Attach}
 	response.Header.TraceId = res.TraceId
+	response.Header.Metadata = res.Metadata
+	response.Header.Action = res.Action
 
 	if buffer, err = pproto.MarshalProtocolResponse(response); err != nil {
 		return nil, err
@@ -0,0 +1,432 @@
+package play
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/leochen2038/play/codec/protos/golang/json"
+	"github.com/leochen2038/play/logger"
+)
+
+var (
+	// QuotaExceededCode 超出配额时play.Err的错误码
+	QuotaExceededCode = 429
+	// QuotaFlushInterval 用量写入文件及检查调用方配置修改的间隔
+	QuotaFlushInterval = 10 * time.Second
+	// QuotaRejectUnknown 为true时拒绝未在配置中登记的CallerId
+	QuotaRejectUnknown = false
+	quota              *Quota
+)
+
+// Caller 调用方配置, Actions为空时不限制, 支持 order.* 前缀匹配; 配额为0时不限制
+type Caller struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Actions     []string `json:"actions"`
+	Daily       int64    `json:"daily"`
+	Monthly     int64    `json:"monthly"`
+	Concurrency int      `json:"concurrency"`
+}
+
+// CallerUsage 调用方用量, Actions为当月各action的调用次数
+type CallerUsage struct {
+	CallerId int              `json:"caller_id"`
+	Day      string           `json:"day"`
+	Daily    int64            `json:"daily"`
+	Month    string           `json:"month"`
+	Monthly  int64            `json:"monthly"`
+	Actions  map[string]int64 `json:"actions"`
+	Running  int              `json:"-"`
+}
+
+// Quota 按CallerId限制可调用的action、日/月调用次数及并发数;
+// 用量定期以增量合并写入文件, 多个进程共用同一文件时计数不会丢失
+type Quota struct {
+	registryFile string
+	usageFile    string
+	mutex        sync.Mutex
+	callers      map[int]Caller
+	modTime      int64
+	usage        map[int]*CallerUsage
+	delta        map[int]*usageDelta
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+type usageDelta struct {
+	reset   bool
+	daily   int64
+	monthly int64
+	actions map[string]int64
+}
+
+func QuotaExceededErr(reason string) Err {
+	return Err{err: errors.New("quota exceeded: " + reason), tip: "quota exceeded", code: QuotaExceededCode, time: time.Now(), attach: map[string]interface{}{}}
+}
+
+// StartQuota 从registryFile加载调用方配置 {"callers": [...]}, 用量保存在usageFile; 需在Boot之前调用
+func StartQuota(registryFile string, usageFile string) (*Quota, error) {
+	q := &Quota{registryFile: registryFile, usageFile: usageFile, usage: make(map[int]*CallerUsage), delta: make(map[int]*usageDelta), stop: make(chan struct{}), done: make(chan struct{})}
+	if err := q.Reload(); err != nil {
+		return nil, err
+	}
+	if err := q.Flush(); err != nil {
+		return nil, err
+	}
+	go q.loop()
+	quota = q
+	return q, nil
+}
+
+// StopQuota 停止并写入尚未保存的用量
+func StopQuota() {
+	if quota != nil {
+		quota.Stop()
+	}
+}
+
+// GetCallerUsage 查询调用方当前用量
+func GetCallerUsage(callerId int) (CallerUsage, error) {
+	if quota == nil {
+		return CallerUsage{}, errors.New("quota not started")
+	}
+	return quota.Usage(callerId), nil
+}
+
+// ResetCallerUsage 清零调用方当日及当月用量
+func ResetCallerUsage(callerId int) error {
+	if quota == nil {
+		return errors.New("quota not started")
+	}
+	quota.Reset(callerId)
+	return nil
+}
+
+// Reload 重新加载调用方配置
+func (q *Quota) Reload() error {
+	data, err := ioutil.ReadFile(q.registryFile)
+	if err != nil {
+		return err
+	}
+	var registry struct {
+		Callers []Caller `json:"callers"`
+	}
+	if err = json.Unmarshal(data, &registry); err != nil {
+		return errors.New("parse caller registry " + q.registryFile + " error: " + err.Error())
+	}
+	callers := make(map[int]Caller, len(registry.Callers))
+	for _, c := range registry.Callers {
+		callers[c.Id] = c
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.callers, q.modTime = callers, fileModTime(q.registryFile)
+	return nil
+}
+
+func (q *Quota) Stop() {
+	select {
+	case <-q.stop:
+	default:
+		close(q.stop)
+		<-q.done
+	}
+}
+
+func (q *Quota) Caller(callerId int) (Caller, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	c, ok := q.callers[callerId]
+	return c, ok
+}
+
+func (q *Quota) Usage(callerId int) CallerUsage {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	u := q.current(callerId, time.Now())
+	usage := *u
+	usage.Actions = make(map[string]int64, len(u.Actions))
+	for k, v := range u.Actions {
+		usage.Actions[k] = v
+	}
+	return usage
+}
+
+func (q *Quota) Reset(callerId int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	u := q.current(callerId, time.Now())
+	u.Daily, u.Monthly, u.Actions = 0, 0, map[string]int64{}
+	q.delta[callerId] = &usageDelta{reset: true, actions: map[string]int64{}}
+}
+
+// acquire 校验并计入一次调用, 返回的release在调用结束后释放并发数
+func (q *Quota) acquire(action string, callerId int, concurrent bool) (func(), error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	c, ok := q.callers[callerId]
+	if !ok {
+		if QuotaRejectUnknown {
+			return nil, ForbiddenErr("unknown caller " + strconv.Itoa(callerId))
+		}
+		return func() {}, nil
+	}
+	if len(c.Actions) > 0 && !matchAction(c.Actions, action) {
+		return nil, ForbiddenErr("caller " + strconv.Itoa(callerId) + " not allowed to call " + action)
+	}
+
+	u := q.current(callerId, time.Now())
+	if c.Daily > 0 && u.Daily >= c.Daily {
+		return nil, QuotaExceededErr("daily quota " + strconv.FormatInt(c.Daily, 10))
+	}
+	if c.Monthly > 0 && u.Monthly >= c.Monthly {
+		return nil, QuotaExceededErr("monthly quota " + strconv.FormatInt(c.Monthly, 10))
+	}
+	if concurrent && c.Concurrency > 0 && u.Running >= c.Concurrency {
+		return nil, QuotaExceededErr("concurrency " + strconv.Itoa(c.Concurrency))
+	}
+
+	d := q.delta[callerId]
+	if d == nil {
+		d = &usageDelta{actions: map[string]int64{}}
+		q.delta[callerId] = d
+	}
+	u.Daily, u.Monthly, u.Actions[action] = u.Daily+1, u.Monthly+1, u.Actions[action]+1
+	d.daily, d.monthly, d.actions[action] = d.daily+1, d.monthly+1, d.actions[action]+1
+	if !concurrent {
+		return func() {}, nil
+	}
+
+	u.Running++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// Flush会替换用量对象, 释放时重新查找
+			q.mutex.Lock()
+			q.usage[callerId].Running--
+			q.mutex.Unlock()
+		})
+	}, nil
+}
+
+// current 返回调用方用量, 跨日或跨月时清零对应计数; 需持有mutex
+func (q *Quota) current(callerId int, now time.Time) *CallerUsage {
+	u := q.usage[callerId]
+	if u == nil {
+		u = &CallerUsage{CallerId: callerId, Actions: map[string]int64{}}
+		q.usage[callerId] = u
+	}
+	rollUsage(u, now)
+	return u
+}
+
+func rollUsage(u *CallerUsage, now time.Time) {
+	if day := now.Format("20060102"); u.Day != day {
+		u.Day, u.Daily = day, 0
+	}
+	if month := now.Format("200601"); u.Month != month {
+		u.Month, u.Monthly, u.Actions = month, 0, map[string]int64{}
+	}
+	if u.Actions == nil {
+		u.Actions = map[string]int64{}
+	}
+}
+
+func (q *Quota) loop() {
+	defer close(q.done)
+	ticker := time.NewTicker(QuotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			if err := q.Flush(); err != nil {
+				logger.System("flush quota usage error", "file", q.usageFile, "error", err.Error())
+			}
+			return
+		case <-ticker.C:
+			if err := q.Flush(); err != nil {
+				logger.System("flush quota usage error", "file", q.usageFile, "error", err.Error())
+			}
+			q.mutex.Lock()
+			changed := fileModTime(q.registryFile) != q.modTime
+			q.mutex.Unlock()
+			if changed {
+				if err := q.Reload(); err != nil {
+					logger.System("reload caller registry error", "file", q.registryFile, "error", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// Flush 在文件锁内把本进程的增量合并到用量文件, 并以合并后的结果更新内存中的用量
+func (q *Quota) Flush() error {
+	if err := os.MkdirAll(filepath.Dir(q.usageFile), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(q.usageFile+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	var stored = make(map[string]*CallerUsage)
+	if data, err := ioutil.ReadFile(q.usageFile); err == nil && len(data) > 0 {
+		if err = json.Unmarshal(data, &stored); err != nil {
+			return errors.New("parse quota usage " + q.usageFile + " error: " + err.Error())
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	q.mutex.Lock()
+	delta := q.delta
+	q.delta = make(map[int]*usageDelta)
+	q.mutex.Unlock()
+
+	now := time.Now()
+	for callerId, d := range delta {
+		key := strconv.Itoa(callerId)
+		u := stored[key]
+		if u == nil || d.reset {
+			u = &CallerUsage{CallerId: callerId}
+			stored[key] = u
+		}
+		rollUsage(u, now)
+		u.Daily, u.Monthly = u.Daily+d.daily, u.Monthly+d.monthly
+		for action, n := range d.actions {
+			u.Actions[action] += n
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err == nil {
+		tmp := q.usageFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, q.usageFile)
+		}
+	}
+	if err != nil {
+		// 写入失败时增量放回, 下次重试
+		q.mutex.Lock()
+		for callerId, d := range delta {
+			if cur := q.delta[callerId]; cur != nil {
+				d.daily, d.monthly = d.daily+cur.daily, d.monthly+cur.monthly
+				for action, n := range cur.actions {
+					d.actions[action] += n
+				}
+				d.reset = d.reset || cur.reset
+			}
+			q.delta[callerId] = d
+		}
+		q.mutex.Unlock()
+		return err
+	}
+
+	// 合并后的用量加上flush期间新增的增量
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, u := range stored {
+		running := 0
+		if cur := q.usage[u.CallerId]; cur != nil {
+			running = cur.Running
+		}
+		u.Running = running
+		rollUsage(u, now)
+		if d := q.delta[u.CallerId]; d != nil {
+			if d.reset {
+				u.Daily, u.Monthly, u.Actions = 0, 0, map[string]int64{}
+			}
+			u.Daily, u.Monthly = u.Daily+d.daily, u.Monthly+d.monthly
+			for action, n := range d.actions {
+				u.Actions[action] += n
+			}
+		}
+		q.usage[u.CallerId] = u
+	}
+	return nil
+}
+
+func matchAction(patterns []string, action string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == action || (strings.HasSuffix(p, "*") && strings.HasPrefix(action, p[:len(p)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquireQuota 异步任务执行时不再计数, batch子请求计数但不占用并发数
+func acquireQuota(act *Action, ctx *Context) (func(), error) {
+	if quota == nil || act == nil {
+		return func() {}, nil
+	}
+	concurrent := true
+	if c, ok := ctx.Session.Server.(*captureServer); ok {
+		if c.jobId != "" {
+			return func() {}, nil
+		}
+		concurrent = false
+	}
+	return quota.acquire(act.name, ctx.ActionRequest.CallerId, concurrent)
+}
+
+// RegisterQuotaAction 注册查询及重置调用方用量的action, roles不为空时仅限具备其一的调用方
+func RegisterQuotaAction(name string, roles ...string) {
+	metaData := map[string]string{"desc": "查询或重置调用方用量"}
+	if len(roles) > 0 {
+		metaData["roles"] = strings.Join(roles, ",")
+	}
+	RegisterAction(name, metaData, func() interface{} {
+		return NewProcessorWrap(new(quotaProcessor), func(p Processor, ctx *Context) (string, error) {
+			return RunProcessor(unsafe.Pointer(p.(*quotaProcessor)), unsafe.Sizeof(*p.(*quotaProcessor)), p, ctx)
+		}, nil)
+	})
+}
+
+type quotaProcessor struct {
+	Input struct {
+		CallerId int  `key:"caller_id" required:"true" note:"调用方id"`
+		Reset    bool `key:"reset" default:"false" note:"是否清零当日及当月用量"`
+	}
+	Output struct {
+		CallerId     int              `key:"caller_id" note:"调用方id"`
+		Name         string           `key:"name" note:"调用方名称"`
+		Daily        int64            `key:"daily" note:"当日调用次数"`
+		DailyLimit   int64            `key:"daily_limit" note:"日配额, 0为不限"`
+		Monthly      int64            `key:"monthly" note:"当月调用次数"`
+		MonthlyLimit int64            `key:"monthly_limit" note:"月配额, 0为不限"`
+		Running      int              `key:"running" note:"执行中的请求数"`
+		Concurrency  int              `key:"concurrency" note:"并发上限, 0为不限"`
+		Actions      map[string]int64 `key:"actions" note:"当月各action调用次数"`
+	}
+}
+
+func (p *quotaProcessor) Run(ctx *Context) (string, error) {
+	if quota == nil {
+		return "", errors.New("quota not started")
+	}
+	if p.Input.Reset {
+		quota.Reset(p.Input.CallerId)
+	}
+	usage := quota.Usage(p.Input.CallerId)
+	caller, _ := quota.Caller(p.Input.CallerId)
+	p.Output.CallerId, p.Output.Name = usage.CallerId, caller.Name
+	p.Output.Daily, p.Output.DailyLimit = usage.Daily, caller.Daily
+	p.Output.Monthly, p.Output.MonthlyLimit = usage.Monthly, caller.Monthly
+	p.Output.Running, p.Output.Concurrency = usage.Running, caller.Concurrency
+	p.Output.Actions = usage.Actions
+	return "", nil
+}
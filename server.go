@@ -1,9 +1,11 @@
 package play
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,6 +21,7 @@ const (
 	SERVER_TYPE_H2C   = 5
 	SERVER_TYPE_QUIC  = 6
 	SERVER_TYPE_HTTP3 = 7
+	SERVER_TYPE_MUX   = 8
 )
 
 type IServerHook interface {
@@ -55,7 +58,9 @@ type InstanceInfo struct {
 }
 
 type InstanceCtrl struct {
-	wg sync.WaitGroup
+	wg       sync.WaitGroup
+	sessions sync.Map
+	draining int32
 }
 
 func (c *InstanceCtrl) AddTask() {
@@ -68,12 +73,61 @@ func (c *InstanceCtrl) WaitTask() {
 	c.wg.Wait()
 }
 
+// AddSession 登记实例上的长连接, 关闭时通知其断开
+func (c *InstanceCtrl) AddSession(s *Session) {
+	c.sessions.Store(s, struct{}{})
+}
+
+func (c *InstanceCtrl) RemoveSession(s *Session) {
+	c.sessions.Delete(s)
+}
+
+func (c *InstanceCtrl) RangeSession(f func(s *Session) bool) {
+	c.sessions.Range(func(key, value interface{}) bool {
+		return f(key.(*Session))
+	})
+}
+
+// Drain 标记实例进入关闭流程
+func (c *InstanceCtrl) Drain() {
+	atomic.StoreInt32(&c.draining, 1)
+}
+
+func (c *InstanceCtrl) Draining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+// ServerDrainingCode 实例关闭中拒绝长连接上新请求时play.Err的错误码
+var ServerDrainingCode = 503
+
+func ServerDrainingErr() Err {
+	return Err{err: errors.New("server draining"), tip: "server draining", code: ServerDrainingCode, time: time.Now(), attach: map[string]interface{}{}}
+}
+
+var ready int32
+
+// SetReady 所有实例启动后置为true, 开始关闭时置为false
+func SetReady(b bool) {
+	var v int32
+	if b {
+		v = 1
+	}
+	atomic.StoreInt32(&ready, v)
+}
+
+// Ready 是否可接收流量, 供就绪检查使用
+func Ready() bool {
+	return atomic.LoadInt32(&ready) == 1
+}
+
 type Conn struct {
 	Type    int
 	IsClose bool
 	Http    struct {
 		Request        *http.Request
 		ResponseWriter http.ResponseWriter
+		Surplus        []byte // grpc streaming预读的下一个消息
+		Event          string // sse推送的事件名, 为空时不输出event行
 	}
 	Websocket struct {
 		Message       []byte
@@ -93,24 +147,36 @@ type Conn struct {
 }
 
 type Request struct {
-	Version     byte
-	RenderName  string
-	CallerId    int
-	TagId       int
-	TraceId     string
-	SpanId      []byte
-	NonRespond  bool
-	ActionName  string
-	Attach      []byte
-	Deadline    time.Time
-	InputBinder binders.Binder
+	Version        byte
+	RenderName     string
+	CallerId       int
+	TagId          int
+	TraceId        string
+	SpanId         []byte
+	NonRespond     bool
+	More           bool // 同一请求中还有后续消息(grpc client/bidi streaming), 需继续Receive
+	ActionName     string
+	RequestId      string // 请求方的关联id(如jsonrpc id), 由packer写回响应
+	IdempotencyKey string // pproto header中的幂等key
+	Attach         map[string][]byte
+	Metadata       map[string]string
+	Compress       byte // 对端可接受的响应压缩算法
+	MessageType    int  // websocket请求的帧类型, 响应使用相同类型
+	Deadline       time.Time
+	InputBinder    binders.Binder
 }
 
 type Response struct {
-	Version    byte
-	TraceId    string
-	Template   string
-	RenderName string
-	Error      error
-	Output     Output
+	Version     byte
+	TraceId     string
+	Action      string // 服务端推送时的action名称
+	RequestId   string
+	Template    string
+	RenderName  string
+	Compress    byte
+	MessageType int // websocket帧类型, 为0时沿用连接上次写入的类型
+	Metadata    map[string]string
+	Attach      map[string][]byte
+	Error       error
+	Output      Output
 }
@@ -0,0 +1,430 @@
+package servers
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/config"
+	"github.com/leochen2038/play/packers"
+)
+
+// ServerDef 配置中的实例定义, Options为原始配置, 供自定义factory读取其他参数
+type ServerDef struct {
+	Name           string
+	Type           string
+	Address        string
+	Packer         string
+	Hook           string
+	CertFile       string
+	KeyFile        string
+	ClientCA       string
+	ClientAuth     bool // 为true时要求客户端证书
+	Concurrency    int
+	IPAllow        []string
+	IPDeny         []string
+	ProxyProtocol  []string
+	TrustedProxies []string
+	LivePath       string
+	ReadyPath      string
+	// 组合的实例名: http可组合ws、sse、h2c、http3, mux可组合tcp、http、h2c、ws; 被组合的ws、sse、h2c及mux的子实例不单独启动
+	WS      string
+	SSE     string
+	H2C     string
+	HTTP3   string
+	TCP     string
+	HTTP    string
+	Options map[string]interface{}
+}
+
+// ServerFactory 按定义创建实例, hook、packer未配置时为nil
+type ServerFactory func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error)
+
+var (
+	// CertRefresh 配置中证书文件的检查间隔
+	CertRefresh     = time.Minute
+	factoryMutex    sync.RWMutex
+	serverFactories = map[string]ServerFactory{
+		"http": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewHttpInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"h2c": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewH2cInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"sse": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewSSEInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"ws": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewWsInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"tcp": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewTcpInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"quic": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewQuicInstance(def.Name, def.Address, hook, packer), nil
+		},
+		"http3": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewHttp3Instance(def.Name, def.Address, hook, packer), nil
+		},
+		"mux": func(def ServerDef, hook play.IServerHook, packer play.IPacker) (play.IServer, error) {
+			return NewMuxInstance(def.Name, def.Address, hook), nil
+		},
+	}
+	packerFactories = map[string]func() play.IPacker{
+		"http":    packers.NewHttpPackert,
+		"json":    packers.NewJsonPackert,
+		"jsonrpc": packers.NewJsonRpcPacker,
+		"play":    packers.NewPlayPacker,
+	}
+	hooks         = map[string]play.IServerHook{}
+	bootConfigKey string
+)
+
+// RegisterServerFactory 注册自定义实例类型, 同名时替换内置类型
+func RegisterServerFactory(typ string, factory ServerFactory) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	serverFactories[typ] = factory
+}
+
+// RegisterPacker 注册配置中可引用的packer, 如需要pb描述的grpc packer
+func RegisterPacker(name string, factory func() play.IPacker) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	packerFactories[name] = factory
+}
+
+// RegisterHook 注册配置中可引用的hook
+func RegisterHook(name string, hook play.IServerHook) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	hooks[name] = hook
+}
+
+// BootFromConfig 从config中key对应的对象读取实例定义并启动, 对象以实例名为key, 如:
+//
+//	"servers": {"api": {"type": "http", "address": ":8090", "packer": "jsonrpc", "hook": "main", "ws": "push"},
+//	            "push": {"type": "ws", "concurrency": 4}}
+//
+// SIGUSR2热重启时先校验配置, 新进程按新配置启动, 地址变化的实例不再继承原socket
+func BootFromConfig(key string) error {
+	defs, err := LoadServerDefs(key)
+	if err != nil {
+		return err
+	}
+	list, err := BuildServers(defs)
+	if err != nil {
+		return err
+	}
+	bootConfigKey = key
+	return Boot(list...)
+}
+
+// LoadServerDefs 读取实例定义, 按实例名排序
+func LoadServerDefs(key string) ([]ServerDef, error) {
+	m, err := config.MapInterface(key)
+	if err != nil {
+		return nil, err
+	}
+	var names = make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var defs = make([]ServerDef, 0, len(names))
+	for _, name := range names {
+		options, err := play.ParseMapInterface(m[name])
+		if err != nil {
+			return nil, errors.New("server " + name + " config error: " + err.Error())
+		}
+		def := ServerDef{Name: name, Options: options}
+		def.Type, def.Address = defString(options, "type"), defString(options, "address")
+		def.Packer, def.Hook = defString(options, "packer"), defString(options, "hook")
+		def.CertFile, def.KeyFile, def.ClientCA = defString(options, "cert_file"), defString(options, "key_file"), defString(options, "client_ca")
+		def.LivePath, def.ReadyPath = defString(options, "live_path"), defString(options, "ready_path")
+		def.WS, def.SSE, def.H2C = defString(options, "ws"), defString(options, "sse"), defString(options, "h2c")
+		def.HTTP3, def.TCP, def.HTTP = defString(options, "http3"), defString(options, "tcp"), defString(options, "http")
+		def.IPAllow, def.IPDeny = defStrings(options, "ip_allow"), defStrings(options, "ip_deny")
+		def.ProxyProtocol, def.TrustedProxies = defStrings(options, "proxy_protocol"), defStrings(options, "trusted_proxies")
+		if v, ok := options["client_auth"]; ok {
+			if def.ClientAuth, err = play.ParseBool(v); err != nil {
+				return nil, errors.New("server " + name + " client_auth error: " + err.Error())
+			}
+		}
+		if v, ok := options["concurrency"]; ok {
+			if def.Concurrency, err = play.ParseInt(v); err != nil {
+				return nil, errors.New("server " + name + " concurrency error: " + err.Error())
+			}
+		}
+		if err = checkServerDef(def); err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+func checkServerDef(def ServerDef) error {
+	factoryMutex.RLock()
+	defer factoryMutex.RUnlock()
+	if _, ok := serverFactories[def.Type]; !ok {
+		return errors.New("server " + def.Name + " unknown type " + def.Type)
+	}
+	if _, ok := packerFactories[def.Packer]; def.Packer != "" && !ok {
+		return errors.New("server " + def.Name + " unknown packer " + def.Packer)
+	}
+	if _, ok := hooks[def.Hook]; def.Hook != "" && !ok {
+		return errors.New("server " + def.Name + " unknown hook " + def.Hook)
+	}
+	if (def.CertFile == "") != (def.KeyFile == "") {
+		return errors.New("server " + def.Name + " requires both cert_file and key_file")
+	}
+	return nil
+}
+
+// BuildServers 创建实例并组合, 返回需要启动的实例
+func BuildServers(defs []ServerDef) ([]play.IServer, error) {
+	var built = make(map[string]play.IServer, len(defs))
+	for _, def := range defs {
+		factoryMutex.RLock()
+		factory, hook, packerFactory := serverFactories[def.Type], hooks[def.Hook], packerFactories[def.Packer]
+		factoryMutex.RUnlock()
+
+		var packer play.IPacker
+		if packerFactory != nil {
+			packer = packerFactory()
+		}
+		server, err := factory(def, hook, packer)
+		if err != nil {
+			return nil, errors.New("create server " + def.Name + " error: " + err.Error())
+		}
+		if err = applyServerDef(server, def); err != nil {
+			return nil, errors.New("server " + def.Name + " config error: " + err.Error())
+		}
+		built[def.Name] = server
+	}
+
+	var nested = make(map[string]bool)
+	var lookup = func(def ServerDef, name string) (play.IServer, error) {
+		if name == "" {
+			return nil, nil
+		}
+		if server, ok := built[name]; ok {
+			nested[name] = true
+			return server, nil
+		}
+		return nil, errors.New("server " + def.Name + " refers to unknown server " + name)
+	}
+	for _, def := range defs {
+		var subs = make(map[string]play.IServer)
+		for k, name := range map[string]string{"ws": def.WS, "sse": def.SSE, "h2c": def.H2C, "http3": def.HTTP3, "tcp": def.TCP, "http": def.HTTP} {
+			sub, err := lookup(def, name)
+			if err != nil {
+				return nil, err
+			}
+			if sub != nil {
+				subs[k] = sub
+			}
+		}
+		if err := composeServer(built[def.Name], subs); err != nil {
+			return nil, errors.New("server " + def.Name + " compose error: " + err.Error())
+		}
+		// http3与http使用不同的socket, 仍需单独启动
+		delete(nested, def.HTTP3)
+	}
+
+	var list []play.IServer
+	for _, def := range defs {
+		if !nested[def.Name] {
+			list = append(list, built[def.Name])
+		}
+	}
+	return list, nil
+}
+
+func composeServer(server play.IServer, subs map[string]play.IServer) error {
+	var ok = true
+	var check = func(b bool) {
+		ok = ok && b
+	}
+	switch i := server.(type) {
+	case *httpInstance:
+		for k, sub := range subs {
+			switch k {
+			case "ws":
+				ws, b := sub.(*wsInstance)
+				check(b)
+				i.SetWSInstance(ws)
+			case "sse":
+				sse, b := sub.(*sseInstance)
+				check(b)
+				i.SetSSEInstance(sse)
+			case "h2c":
+				h2c, b := sub.(*h2cInstance)
+				check(b)
+				i.SetH2cInstance(h2c)
+			case "http3":
+				h3, b := sub.(*http3Instance)
+				check(b)
+				i.SetHttp3Instance(h3)
+			default:
+				return errors.New("http can not compose " + k)
+			}
+		}
+	case *muxInstance:
+		for k, sub := range subs {
+			switch k {
+			case "tcp":
+				tcp, b := sub.(*TcpInstance)
+				check(b)
+				i.SetTcpInstance(tcp)
+			case "http":
+				http, b := sub.(*httpInstance)
+				check(b)
+				i.SetHttpInstance(http)
+			case "h2c":
+				h2c, b := sub.(*h2cInstance)
+				check(b)
+				i.SetH2cInstance(h2c)
+			case "ws":
+				ws, b := sub.(*wsInstance)
+				check(b)
+				i.SetWSInstance(ws)
+			default:
+				return errors.New("mux can not compose " + k)
+			}
+		}
+	default:
+		if len(subs) > 0 {
+			return errors.New(server.Info().Name + " can not compose other servers")
+		}
+	}
+	if !ok {
+		return errors.New("composed server type mismatch")
+	}
+	return nil
+}
+
+// applyServerDef 按类型设置证书、并发数、地址过滤等通用配置
+func applyServerDef(server play.IServer, def ServerDef) error {
+	var err error
+	var store *play.CertStore
+	if def.CertFile != "" {
+		store = play.NewCertStore()
+		if err = store.AddFile(def.CertFile, def.KeyFile, CertRefresh); err != nil {
+			return err
+		}
+	}
+	var pool *x509.CertPool
+	if def.ClientCA != "" {
+		data, err := ioutil.ReadFile(def.ClientCA)
+		if err != nil {
+			return err
+		}
+		if pool = x509.NewCertPool(); !pool.AppendCertsFromPEM(data) {
+			return errors.New("invalid client ca " + def.ClientCA)
+		}
+	}
+	var filter *play.IPFilter
+	if len(def.IPAllow) > 0 || len(def.IPDeny) > 0 {
+		if filter, err = play.NewIPFilter(def.IPAllow, def.IPDeny); err != nil {
+			return err
+		}
+	}
+	proxies, err := play.ParseCIDRs(def.ProxyProtocol...)
+	if err != nil {
+		return err
+	}
+	trusted, err := play.ParseCIDRs(def.TrustedProxies...)
+	if err != nil {
+		return err
+	}
+
+	switch i := server.(type) {
+	case *httpInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+		i.WithIPFilter(filter).WithProxyProtocol(proxies).WithTrustedProxies(trusted).WithHealthCheck(def.LivePath, def.ReadyPath)
+	case *TcpInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+		i.WithIPFilter(filter).WithProxyProtocol(proxies).WithConcurrency(def.Concurrency)
+	case *wsInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+		i.WithConcurrency(def.Concurrency)
+	case *h2cInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+	case *sseInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+	case *http3Instance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+	case *quicInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+		if pool != nil {
+			i.WithClientCA(pool, def.ClientAuth)
+		}
+	case *muxInstance:
+		if store != nil {
+			i.WithCertStore(store)
+		}
+	}
+	return nil
+}
+
+func defString(options map[string]interface{}, key string) string {
+	if v, ok := options[key]; ok && v != nil {
+		s, _ := play.ParseString(v)
+		return s
+	}
+	return ""
+}
+
+func defStrings(options map[string]interface{}, key string) []string {
+	switch v := options[key].(type) {
+	case []interface{}:
+		var list = make([]string, 0, len(v))
+		for _, item := range v {
+			s, _ := play.ParseString(item)
+			list = append(list, s)
+		}
+		return list
+	case string:
+		return []string{v}
+	}
+	return nil
+}
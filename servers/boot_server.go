@@ -4,12 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/exec"
 	"os/signal"
-	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -46,37 +46,21 @@ type filer interface {
 func Boot(is ...play.IServer) error {
 	var instanceWaitGroup sync.WaitGroup
 	var egr errgroup.Group
-	for _, i := range is {
+
+	if preforkNum > 1 && !isPreforkWorker() && os.Getenv(envGraceful) == "" {
+		return runPreforkMaster(is)
+	}
+	if isPreforkWorker() {
+		go watchMaster()
+	}
+
+	for idx, i := range is {
 		if i != nil {
-			var i = i
-			var err error
-			var listener net.Listener
-			var udplistener net.PacketConn
-			var gracefulSocket = getGracefulSocket(i.Info().Name)
+			var i, idx = i, idx
 			egr.Go(func() error {
-				switch i.Network() {
-				case "tcp":
-					if gracefulSocket > 0 {
-						if listener, err = net.FileListener(os.NewFile(gracefulSocket, "")); err != nil {
-							return err
-						}
-					} else {
-						if listener, err = net.Listen(i.Network(), i.Info().Address); err != nil {
-							return err
-						}
-					}
-				case "udp":
-					if gracefulSocket > 0 {
-						if udplistener, err = net.FilePacketConn(os.NewFile(gracefulSocket, "")); err != nil {
-							return err
-						}
-					} else {
-						if udplistener, err = net.ListenPacket(i.Network(), i.Info().Address); err != nil {
-							return err
-						}
-					}
-				default:
-					return errors.New("unsupported network")
+				listener, udplistener, err := listen(i, idx)
+				if err != nil {
+					return err
 				}
 
 				if _, ok := instances.Load(i.Info().Name); ok {
@@ -109,6 +93,7 @@ func Boot(is ...play.IServer) error {
 		}
 		return err
 	}
+	play.SetReady(true)
 	if os.Getenv(envGraceful) != "" {
 		if err := shouldKillParent(); err != nil {
 			os.Exit(1)
@@ -119,30 +104,24 @@ func Boot(is ...play.IServer) error {
 	return nil
 }
 
+// ShutdownAll 按drain流程关闭所有实例, SIGTERM及热重启交接时旧进程均由此退出
 func ShutdownAll() {
+	var list []runningInstance
 	for _, v := range runs {
-		Shutdown(v)
+		if i, ok := instances.Load(v); ok {
+			instances.Delete(v)
+			list = append(list, i.(runningInstance))
+		}
 	}
+	drain(list)
+	play.StopJobQueue()
+	play.StopQuota()
 }
 
 func Shutdown(name string) {
 	if v, ok := instances.Load(name); ok {
 		instances.Delete(name)
-		i := v.(runningInstance)
-
-		defer func() {
-			i.server.Close()
-			if i.listener != nil {
-				_ = i.listener.Close()
-			}
-			if i.udpListener != nil {
-				_ = i.udpListener.Close()
-			}
-			if panicInfo := recover(); panicInfo != nil {
-				fmt.Printf("panic: %v\n%v", panicInfo, string(debug.Stack()))
-			}
-		}()
-		i.server.Hook().OnShutdown(i.server)
+		drain([]runningInstance{v.(runningInstance)})
 	}
 }
 
@@ -156,6 +135,22 @@ func doRequest(gctx context.Context, s *play.Session, request *play.Request) (er
 	return play.CallAction(gctx, s, request)
 }
 
+// doHttpRequest 处理一个http请求, grpc client/bidi streaming时同一请求中包含多个消息
+func doHttpRequest(gctx context.Context, s *play.Session) (err error) {
+	var request *play.Request
+	for {
+		if request, err = s.Server.Packer().Receive(s.Conn); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if err = doRequest(gctx, s, request); err != nil || !request.More {
+			return
+		}
+	}
+}
+
 func reload() (int, error) {
 	var err error
 	var tags []string
@@ -173,6 +168,10 @@ func reload() (int, error) {
 		var socket *os.File
 		run := value.(runningInstance)
 		if run.listener != nil {
+			// unix socket文件交由新进程继续使用, 旧进程关闭时不删除
+			if l, ok := run.listener.(*net.UnixListener); ok {
+				l.SetUnlinkOnClose(false)
+			}
 			socket, _ = run.listener.(filer).File()
 		} else if run.udpListener != nil {
 			socket, _ = run.udpListener.(filer).File()
@@ -190,7 +189,7 @@ func reload() (int, error) {
 
 	var env []string
 	for _, v := range os.Environ() {
-		if !strings.HasPrefix(v, envGraceful) {
+		if !strings.HasPrefix(v, envGraceful) && !strings.HasPrefix(v, "LISTEN_") {
 			env = append(env, v)
 		}
 	}
@@ -216,12 +215,36 @@ func signalHandler() {
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
 
 	for {
-		switch <-ch {
+		sig := <-ch
+		if master != nil {
+			switch sig {
+			case syscall.SIGINT, syscall.SIGTERM:
+				signal.Stop(ch)
+				master.stop()
+			case syscall.SIGUSR2:
+				if err := master.reload(); err != nil {
+					fmt.Println("reload prefork workers error:", err)
+				}
+			}
+			continue
+		}
+
+		switch sig {
 		case syscall.SIGINT, syscall.SIGTERM:
 			signal.Stop(ch)
 			ShutdownAll()
 			os.Exit(0)
 		case syscall.SIGUSR2:
+			if isPreforkWorker() {
+				fmt.Println("prefork worker ignore SIGUSR2, send it to master process")
+				continue
+			}
+			if bootConfigKey != "" {
+				if _, err := LoadServerDefs(bootConfigKey); err != nil {
+					fmt.Println("reload skipped, server config error:", err.Error())
+					continue
+				}
+			}
 			if _, err := reload(); err != nil {
 				fmt.Println("reload error:", err.Error())
 			}
@@ -242,8 +265,13 @@ func shouldKillParent() (err error) {
 }
 
 func getGracefulSocket(name string) (id uintptr) {
-	if os.Getenv(envGraceful) != "" {
-		for _, v := range strings.Split(os.Getenv(envGraceful), "-") {
+	return getInheritSocket(os.Getenv(envGraceful), name)
+}
+
+// getInheritSocket 从 name:idx-name:idx 格式的环境变量中查找继承的socket文件描述符
+func getInheritSocket(env string, name string) (id uintptr) {
+	if env != "" {
+		for _, v := range strings.Split(env, "-") {
 			if socket := strings.Split(v, ":"); len(socket) == 2 {
 				if socket[0] == name {
 					socketId, _ := strconv.Atoi(socket[1])
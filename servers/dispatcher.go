@@ -0,0 +1,66 @@
+package servers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/leochen2038/play"
+)
+
+// dispatcher 在单个连接上并发执行请求, 并限制同时执行的请求数
+type dispatcher struct {
+	sem chan struct{}
+	wg  sync.WaitGroup
+}
+
+func newDispatcher(concurrency int) *dispatcher {
+	if concurrency <= 1 {
+		return nil
+	}
+	return &dispatcher{sem: make(chan struct{}, concurrency)}
+}
+
+// dispatch 有空闲worker时异步执行请求, 否则阻塞读取直到有worker释放;
+// session要求有序时等待已派发的请求完成后同步执行
+func (d *dispatcher) dispatch(s *play.Session, request *play.Request, abort func(error)) error {
+	if s.Server.Ctrl().Draining() {
+		return rejectDraining(s, request)
+	}
+	if d == nil {
+		return doRequest(context.Background(), s, request)
+	}
+	if s.Ordered {
+		d.wg.Wait()
+		return doRequest(context.Background(), s, request)
+	}
+
+	select {
+	case d.sem <- struct{}{}:
+	case <-s.Context().Done():
+		return s.Context().Err()
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			<-d.sem
+			d.wg.Done()
+		}()
+		if err := doRequest(context.Background(), s, request); err != nil {
+			abort(err)
+		}
+	}()
+	return nil
+}
+
+// rejectDraining 实例关闭中时长连接已收到goaway, 新请求以ServerDrainingErr响应而不执行
+func rejectDraining(s *play.Session, request *play.Request) error {
+	return play.RejectRequest(context.Background(), s, request, play.ServerDrainingErr())
+}
+
+// wait 等待连接上所有已派发的请求完成
+func (d *dispatcher) wait() {
+	if d != nil {
+		d.wg.Wait()
+	}
+}
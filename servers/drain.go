@@ -0,0 +1,178 @@
+package servers
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/leochen2038/play"
+)
+
+// GOAWAY_ACTION 关闭前向pproto v4及以上的tcp/quic长连接推送的action, 客户端收到后不应在该连接上发送新请求, 之后的请求以ServerDrainingCode拒绝
+const GOAWAY_ACTION = "goaway"
+
+var (
+	// DrainTimeout 关闭时等待执行中请求完成的最长时间, 超时后强制关闭连接
+	DrainTimeout = 30 * time.Second
+	// SSERetry 关闭时通知sse客户端的重连间隔
+	SSERetry = 3 * time.Second
+)
+
+// drainer 基于http.Server的实例, 关闭时停止复用keep-alive连接, 超时后强制关闭
+type drainer interface {
+	drain()
+	forceClose()
+}
+
+// drain 依次停止接受新连接、标记未就绪、通知长连接断开, 等待执行中的请求完成, 超过DrainTimeout后强制关闭
+func drain(list []runningInstance) {
+	for _, i := range list {
+		func() {
+			defer func() {
+				if panicInfo := recover(); panicInfo != nil {
+					fmt.Printf("panic: %v\n%v", panicInfo, string(debug.Stack()))
+				}
+			}()
+			i.server.Hook().OnShutdown(i.server)
+		}()
+		if i.listener != nil {
+			_ = i.listener.Close()
+		}
+		if i.udpListener != nil {
+			_ = i.udpListener.Close()
+		}
+	}
+	play.SetReady(false)
+
+	deadline := time.Now().Add(DrainTimeout)
+	var wg sync.WaitGroup
+	for _, i := range list {
+		wg.Add(1)
+		go func(server play.IServer) {
+			defer wg.Done()
+			rangeServer(server, func(s play.IServer) {
+				s.Ctrl().Drain()
+				if d, ok := s.(drainer); ok {
+					d.drain()
+				}
+				s.Ctrl().RangeSession(func(sess *play.Session) bool {
+					goAway(sess)
+					return true
+				})
+			})
+
+			done := make(chan struct{})
+			go func() {
+				server.Close()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Until(deadline)):
+				fmt.Printf("[play server] %s drain timeout, force close\n", server.Info().Name)
+				rangeServer(server, func(s play.IServer) {
+					if d, ok := s.(drainer); ok {
+						d.forceClose()
+					}
+					s.Ctrl().RangeSession(func(sess *play.Session) bool {
+						forceClose(sess)
+						return true
+					})
+				})
+			}
+		}(i.server)
+	}
+	wg.Wait()
+}
+
+// rangeServer 遍历实例及其组合的子实例
+func rangeServer(server play.IServer, f func(s play.IServer)) {
+	f(server)
+	switch i := server.(type) {
+	case *muxInstance:
+		for _, sub := range i.subInstances() {
+			rangeServer(sub, f)
+		}
+	case *httpInstance:
+		if i.ws != nil {
+			f(i.ws)
+		}
+		if i.sse != nil {
+			f(i.sse)
+		}
+		if i.h2c != nil {
+			f(i.h2c)
+		}
+	}
+}
+
+// goAway websocket发送going away关闭帧, sse发送重连间隔后结束, tcp/quic推送goaway
+func goAway(s *play.Session) {
+	switch s.Conn.Type {
+	case play.SERVER_TYPE_WS:
+		_ = s.WriteLocked(func() error {
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+			return s.Conn.Websocket.WebsocketConn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		})
+	case play.SERVER_TYPE_SSE:
+		_ = s.WriteLocked(func() error {
+			w := s.Conn.Http.ResponseWriter
+			_, err := w.Write([]byte("retry: " + strconv.Itoa(int(SSERetry/time.Millisecond)) + "\n\n"))
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return err
+		})
+		s.Close()
+	case play.SERVER_TYPE_TCP, play.SERVER_TYPE_QUIC:
+		_ = s.Push(GOAWAY_ACTION, map[string]interface{}{"reason": "server shutdown"})
+	}
+}
+
+func forceClose(s *play.Session) {
+	switch s.Conn.Type {
+	case play.SERVER_TYPE_WS:
+		_ = s.Conn.Websocket.WebsocketConn.Close()
+	case play.SERVER_TYPE_TCP:
+		_ = s.Conn.Tcp.Conn.Close()
+	case play.SERVER_TYPE_QUIC:
+		_ = s.Conn.Quic.Conn.CloseWithError(0, "server shutdown")
+	}
+	s.Close()
+}
+
+func (i *httpInstance) drain() {
+	i.httpServer.SetKeepAlivesEnabled(false)
+}
+
+func (i *httpInstance) forceClose() {
+	_ = i.httpServer.Close()
+}
+
+func (i *h2cInstance) drain() {
+	i.httpServer.SetKeepAlivesEnabled(false)
+}
+
+func (i *h2cInstance) forceClose() {
+	_ = i.httpServer.Close()
+}
+
+func (i *sseInstance) drain() {
+	i.httpServer.SetKeepAlivesEnabled(false)
+}
+
+func (i *sseInstance) forceClose() {
+	_ = i.httpServer.Close()
+}
+
+func (i *wsInstance) drain() {
+	i.httpServer.SetKeepAlivesEnabled(false)
+}
+
+func (i *wsInstance) forceClose() {
+	_ = i.httpServer.Close()
+}
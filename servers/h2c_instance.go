@@ -3,6 +3,7 @@ package servers
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
@@ -25,6 +26,7 @@ type h2cInstance struct {
 	tlsConfig   *tls.Config
 	httpServer  http.Server
 	http2server http2.Server
+	security    *HttpSecurity
 }
 
 func NewH2cInstance(name string, addr string, hook play.IServerHook, packer play.IPacker) *h2cInstance {
@@ -40,9 +42,12 @@ func NewH2cInstance(name string, addr string, hook play.IServerHook, packer play
 
 func (i *h2cInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
-	var request *play.Request
 	var sess = play.NewSession(r.Context(), i)
+	sess.Peer, sess.RemoteAddr = play.NewPeerIdentity(r.TLS), r.RemoteAddr
 	sess.Conn.Http.Request, sess.Conn.Http.ResponseWriter = r, w
+	if i.security != nil && !i.security.handle(w, r) {
+		return
+	}
 
 	defer func() {
 		if panicInfo := recover(); panicInfo != nil {
@@ -56,10 +61,12 @@ func (i *h2cInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		i.hook.OnClose(sess, err)
 	}()
 	i.hook.OnConnect(sess, nil)
-	if request, err = i.packer.Receive(sess.Conn); err != nil {
-		return
-	}
-	err = doRequest(r.Context(), sess, request)
+	err = doHttpRequest(r.Context(), sess)
+}
+
+func (i *h2cInstance) WithSecurity(security *HttpSecurity) *h2cInstance {
+	i.security = security
+	return i
 }
 
 func (i *h2cInstance) update(r *http.Request) error {
@@ -117,6 +124,16 @@ func (i *h2cInstance) WithCertificate(cert tls.Certificate) *h2cInstance {
 	return i
 }
 
+func (i *h2cInstance) WithCertStore(store *play.CertStore) *h2cInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	return i
+}
+
+func (i *h2cInstance) WithClientCA(pool *x509.CertPool, required bool) *h2cInstance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
 func (i *h2cInstance) Network() string {
 	return "tcp"
 }
@@ -0,0 +1,132 @@
+package servers
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/packers"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+type http3Instance struct {
+	info   play.InstanceInfo
+	hook   play.IServerHook
+	ctrl   *play.InstanceCtrl
+	packer play.IPacker
+
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	server     *http3.Server
+}
+
+func NewHttp3Instance(name string, addr string, hook play.IServerHook, packer play.IPacker) *http3Instance {
+	if packer == nil {
+		packer = packers.NewHttpPackert()
+	}
+	if hook == nil {
+		hook = defaultHook{}
+	}
+	i := &http3Instance{info: play.InstanceInfo{Name: name, Address: addr, Type: play.SERVER_TYPE_HTTP3}, packer: packer, hook: hook, ctrl: new(play.InstanceCtrl)}
+	i.server = &http3.Server{Addr: addr, Handler: i}
+	return i
+}
+
+func (i *http3Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	var sess = play.NewSession(r.Context(), i)
+	sess.Peer, sess.RemoteAddr = play.NewPeerIdentity(r.TLS), r.RemoteAddr
+	sess.Conn.Http.Request, sess.Conn.Http.ResponseWriter = r, w
+
+	defer func() {
+		if panicInfo := recover(); panicInfo != nil {
+			fmt.Printf("panic: %v\n%v", panicInfo, string(debug.Stack()))
+		}
+	}()
+	defer func() {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+		i.hook.OnClose(sess, err)
+	}()
+	i.hook.OnConnect(sess, nil)
+	err = doHttpRequest(r.Context(), sess)
+}
+
+// setAltSvc 在http/1、h2响应中声明可使用http/3访问
+func (i *http3Instance) setAltSvc(header http.Header) {
+	_ = i.server.SetQuicHeaders(header)
+}
+
+func (i *http3Instance) Info() play.InstanceInfo {
+	return i.info
+}
+
+func (i *http3Instance) Ctrl() *play.InstanceCtrl {
+	return i.ctrl
+}
+
+func (i *http3Instance) Hook() play.IServerHook {
+	return i.hook
+}
+
+func (i *http3Instance) Packer() play.IPacker {
+	return i.packer
+}
+
+func (i *http3Instance) Transport(conn *play.Conn, data []byte) error {
+	_, err := conn.Http.ResponseWriter.Write(data)
+	if flusher, ok := conn.Http.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return err
+}
+
+func (i *http3Instance) WithCertificate(cert tls.Certificate) *http3Instance {
+	if i.tlsConfig == nil {
+		i.tlsConfig = &tls.Config{}
+	}
+	i.tlsConfig.Certificates = []tls.Certificate{cert}
+	i.tlsConfig.Rand = rand.Reader
+	return i
+}
+
+func (i *http3Instance) WithCertStore(store *play.CertStore) *http3Instance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	return i
+}
+
+func (i *http3Instance) WithClientCA(pool *x509.CertPool, required bool) *http3Instance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
+func (i *http3Instance) SetQuicConfig(config *quic.Config) *http3Instance {
+	i.quicConfig = config
+	return i
+}
+
+// Run 使用Boot提供的udp socket, 热重启时由新进程继承该socket
+func (i *http3Instance) Run(listener net.Listener, udplistener net.PacketConn) error {
+	if i.tlsConfig == nil {
+		i.tlsConfig = generateTLSConfig(nil)
+	}
+	i.server.TLSConfig = http3.ConfigureTLSConfig(i.tlsConfig)
+	i.server.QuicConfig = i.quicConfig
+	return i.server.Serve(udplistener)
+}
+
+func (i *http3Instance) Close() {
+	_ = i.server.Close()
+	i.ctrl.WaitTask()
+}
+
+func (i *http3Instance) Network() string {
+	return "udp"
+}
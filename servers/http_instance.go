@@ -3,12 +3,14 @@ package servers
 import (
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/leochen2038/play"
+	"github.com/leochen2038/play/codec/protos/golang/json"
 	"github.com/leochen2038/play/packers"
 )
 
@@ -23,6 +25,13 @@ type httpInstance struct {
 	ws         *wsInstance
 	sse        *sseInstance
 	h2c        *h2cInstance
+	h3         *http3Instance
+	security   *HttpSecurity
+	proxies    play.CIDRList
+	trusted    play.CIDRList
+	ipFilter   *play.IPFilter
+	livePath   string
+	readyPath  string
 }
 
 func NewHttpInstance(name string, addr string, hook play.IServerHook, packer play.IPacker) *httpInstance {
@@ -37,6 +46,9 @@ func NewHttpInstance(name string, addr string, hook play.IServerHook, packer pla
 
 func (i *httpInstance) Run(listener net.Listener, udplistener net.PacketConn) error {
 	i.httpServer.Handler = i
+	if len(i.proxies) > 0 {
+		listener = &proxyListener{Listener: listener, trusted: i.proxies}
+	}
 	if i.tlsConfig != nil {
 		listener = tls.NewListener(listener, i.tlsConfig)
 	}
@@ -62,6 +74,9 @@ func (i *httpInstance) Packer() play.IPacker {
 
 func (i *httpInstance) Transport(conn *play.Conn, data []byte) error {
 	_, err := conn.Http.ResponseWriter.Write(data)
+	if flusher, ok := conn.Http.ResponseWriter.(http.Flusher); ok && conn.Http.Request.ProtoMajor == 2 {
+		flusher.Flush()
+	}
 	return err
 }
 
@@ -70,12 +85,27 @@ func (i *httpInstance) Ctrl() *play.InstanceCtrl {
 }
 
 func (i *httpInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "" && (r.URL.Path == i.livePath || r.URL.Path == i.readyPath) {
+		serveHealth(w, r, r.URL.Path == i.livePath)
+		return
+	}
+
 	var err error
-	var request *play.Request
 	var sess = play.NewSession(r.Context(), i)
+	sess.Peer = play.NewPeerIdentity(r.TLS)
 	sess.Conn.Http.Request, sess.Conn.Http.ResponseWriter = r, w
+	if sess.RemoteAddr = clientAddr(r, i.trusted); !i.ipFilter.Allow(sess.RemoteAddr) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if i.h3 != nil {
+		i.h3.setAltSvc(w.Header())
+	}
+	if i.security != nil && !i.security.handle(w, r) {
+		return
+	}
 	if i.ws != nil {
-		if conn, _ := i.ws.update(w, r); conn != nil {
+		if conn, _ := i.ws.update(w, r, i.security); conn != nil {
 			sess.Server = i.ws
 			sess.Conn.Type = play.SERVER_TYPE_WS
 			sess.Conn.Websocket.WebsocketConn = conn
@@ -87,7 +117,7 @@ func (i *httpInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if i.sse != nil {
 		if err = i.sse.update(r); err == nil {
 			sess.Server = i.sse
-			i.sse.accept(sess)
+			i.sse.accept(sess, i.security != nil)
 			return
 		}
 	}
@@ -112,10 +142,37 @@ func (i *httpInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		i.hook.OnClose(sess, err)
 	}()
 	i.hook.OnConnect(sess, nil)
-	if request, err = i.packer.Receive(sess.Conn); err != nil {
-		return
-	}
-	err = doRequest(r.Context(), sess, request)
+	err = doHttpRequest(r.Context(), sess)
+}
+
+// WithSecurity 设置CORS、安全响应头及CSRF校验, 挂载的ws、sse沿用该策略
+func (i *httpInstance) WithSecurity(security *HttpSecurity) *httpInstance {
+	i.security = security
+	return i
+}
+
+// WithProxyProtocol 来自trusted网段(负载均衡器)的连接解析PROXY protocol v1/v2头获取客户端地址
+func (i *httpInstance) WithProxyProtocol(trusted play.CIDRList) *httpInstance {
+	i.proxies = trusted
+	return i
+}
+
+// WithTrustedProxies 直连地址在trusted网段时, 从X-Forwarded-For中获取客户端地址
+func (i *httpInstance) WithTrustedProxies(trusted play.CIDRList) *httpInstance {
+	i.trusted = trusted
+	return i
+}
+
+// WithHealthCheck 在livePath、readyPath上提供存活及就绪检查, down时返回503; 为空时不提供
+func (i *httpInstance) WithHealthCheck(livePath string, readyPath string) *httpInstance {
+	i.livePath, i.readyPath = livePath, readyPath
+	return i
+}
+
+// WithIPFilter 按客户端地址拒绝请求, 挂载的ws、sse同样生效
+func (i *httpInstance) WithIPFilter(filter *play.IPFilter) *httpInstance {
+	i.ipFilter = filter
+	return i
 }
 
 func (i *httpInstance) SetWSInstance(ws *wsInstance) {
@@ -130,6 +187,11 @@ func (i *httpInstance) SetH2cInstance(h2c *h2cInstance) {
 	i.h2c = h2c
 }
 
+// SetHttp3Instance 响应头中携带Alt-Svc, 客户端可升级至http/3
+func (i *httpInstance) SetHttp3Instance(h3 *http3Instance) {
+	i.h3 = h3
+}
+
 func (i *httpInstance) WithCertificate(cert tls.Certificate) *httpInstance {
 	if i.tlsConfig == nil {
 		i.tlsConfig = &tls.Config{}
@@ -140,6 +202,33 @@ func (i *httpInstance) WithCertificate(cert tls.Certificate) *httpInstance {
 	return i
 }
 
+func (i *httpInstance) WithCertStore(store *play.CertStore) *httpInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	i.tlsConfig.NextProtos = []string{"h2"}
+	return i
+}
+
+func (i *httpInstance) WithClientCA(pool *x509.CertPool, required bool) *httpInstance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
 func (i *httpInstance) Network() string {
 	return "tcp"
 }
+
+func serveHealth(w http.ResponseWriter, r *http.Request, liveness bool) {
+	var report play.HealthReport
+	if liveness {
+		report = play.Liveness(r.Context())
+	} else {
+		report = play.Readiness(r.Context())
+	}
+	data, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if report.Status == play.HEALTH_DOWN {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,182 @@
+package servers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/leochen2038/play"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	envListenFds     = "LISTEN_FDS"
+	envListenPid     = "LISTEN_PID"
+	envListenFdNames = "LISTEN_FDNAMES"
+	listenFdsStart   = 3
+)
+
+// UnixSocketMode unix socket文件的权限
+var UnixSocketMode os.FileMode = 0660
+
+// parseAddress 地址以"unix:"开头时使用unix domain socket, 如 unix:/var/run/play.sock
+func parseAddress(network, address string) (string, string) {
+	if strings.HasPrefix(address, "unix:") {
+		return "unix", strings.TrimPrefix(address, "unix:")
+	}
+	return network, address
+}
+
+// listen 按 GRACEFUL热重启 > systemd socket激活 > prefork继承 > 新建监听 的顺序获取监听socket
+func listen(i play.IServer, index int) (listener net.Listener, udplistener net.PacketConn, err error) {
+	var owner = true
+	var network, address = parseAddress(i.Network(), i.Info().Address)
+	var socket = getGracefulSocket(i.Info().Name)
+	if socket > 0 && !socketMatch(socket, network, address) {
+		// 配置变更后地址不同的实例不再继承原socket
+		_ = syscall.Close(int(socket))
+		socket = 0
+	}
+	if socket == 0 {
+		if socket = getActivatedSocket(i.Info().Name, index); socket > 0 {
+			owner = false
+		} else if socket = getPreforkSocket(i.Info().Name); socket > 0 {
+			owner = false
+		}
+	}
+
+	switch network {
+	case "tcp", "unix":
+		if socket > 0 {
+			if listener, err = net.FileListener(os.NewFile(socket, "")); err != nil {
+				return
+			}
+			// 热重启继承的unix socket由当前进程负责清理, systemd及prefork master创建的不清理
+			if l, ok := listener.(*net.UnixListener); ok {
+				l.SetUnlinkOnClose(owner)
+			}
+		} else if network == "unix" {
+			listener, err = listenUnix(address)
+		} else if isPreforkWorker() {
+			listener, err = reuseportConfig.Listen(context.Background(), network, address)
+		} else {
+			listener, err = net.Listen(network, address)
+		}
+	case "udp":
+		if socket > 0 {
+			udplistener, err = net.FilePacketConn(os.NewFile(socket, ""))
+		} else if isPreforkWorker() {
+			udplistener, err = reuseportConfig.ListenPacket(context.Background(), network, address)
+		} else {
+			udplistener, err = net.ListenPacket(network, address)
+		}
+	default:
+		err = errors.New("unsupported network")
+	}
+	return
+}
+
+// socketMatch 继承的socket地址与配置地址是否一致, 无法比较时视为一致
+func socketMatch(socket uintptr, network, address string) bool {
+	sa, err := syscall.Getsockname(int(socket))
+	if err != nil {
+		return true
+	}
+	switch a := sa.(type) {
+	case *syscall.SockaddrUnix:
+		return network == "unix" && a.Name == address
+	case *syscall.SockaddrInet4:
+		return inetMatch(network, address, net.IP(a.Addr[:]), a.Port)
+	case *syscall.SockaddrInet6:
+		return inetMatch(network, address, net.IP(a.Addr[:]), a.Port)
+	}
+	return true
+}
+
+func inetMatch(network, address string, ip net.IP, port int) bool {
+	if network == "unix" {
+		return false
+	}
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return true
+	}
+	if p != strconv.Itoa(port) {
+		return false
+	}
+	if host == "" {
+		return ip.IsUnspecified()
+	}
+	if addrs, err := net.LookupIP(host); err == nil {
+		for _, v := range addrs {
+			if v.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// listenUnix 清理残留的socket文件后监听, 并设置文件权限
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(path + " exists and is not a unix socket")
+		}
+		if conn, err := net.DialTimeout("unix", path, 100*time.Millisecond); err == nil {
+			_ = conn.Close()
+			return nil, errors.New("unix socket " + path + " is in use")
+		}
+		_ = os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, UnixSocketMode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// getActivatedSocket systemd socket激活, 按LISTEN_FDNAMES匹配实例名, 未命名时按Boot参数顺序匹配
+func getActivatedSocket(name string, index int) uintptr {
+	if os.Getenv(envListenPid) != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	count, _ := strconv.Atoi(os.Getenv(envListenFds))
+	if count <= 0 {
+		return 0
+	}
+
+	if names := os.Getenv(envListenFdNames); names != "" {
+		for idx, v := range strings.Split(names, ":") {
+			if v == name && idx < count {
+				return uintptr(listenFdsStart + idx)
+			}
+		}
+		return 0
+	}
+	if index < count {
+		return uintptr(listenFdsStart + index)
+	}
+	return 0
+}
+
+var reuseportConfig = net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); e != nil {
+		return e
+	}
+	return err
+}}
@@ -0,0 +1,311 @@
+package servers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play"
+)
+
+// SniffTimeout 新连接读取协议特征字节的超时时间
+var SniffTimeout = 5 * time.Second
+
+var httpMethods = [][]byte{[]byte("GET "), []byte("POST"), []byte("PUT "), []byte("HEAD"), []byte("DELE"), []byte("OPTI"), []byte("PATC"), []byte("CONN"), []byte("TRAC")}
+
+// muxInstance 在同一端口上按连接首字节识别协议, 分发给pproto/http/h2c/websocket实例,
+// 各子实例无需单独监听, 随muxInstance一起由Boot启动和热重启
+type muxInstance struct {
+	info play.InstanceInfo
+	hook play.IServerHook
+	ctrl *play.InstanceCtrl
+
+	tlsConfig *tls.Config
+	tcp       *TcpInstance
+	http      *httpInstance
+	h2c       *h2cInstance
+	ws        *wsInstance
+	listeners []*muxListener
+}
+
+func NewMuxInstance(name string, addr string, hook play.IServerHook) *muxInstance {
+	if hook == nil {
+		hook = defaultHook{}
+	}
+	return &muxInstance{info: play.InstanceInfo{Name: name, Address: addr, Type: play.SERVER_TYPE_MUX}, hook: hook, ctrl: new(play.InstanceCtrl)}
+}
+
+func (i *muxInstance) SetTcpInstance(tcp *TcpInstance) *muxInstance {
+	i.tcp = tcp
+	return i
+}
+
+// SetHttpInstance http/1请求, 包括websocket、sse及h2c升级请求, 由httpInstance按其组合的实例处理
+func (i *muxInstance) SetHttpInstance(http *httpInstance) *muxInstance {
+	i.http = http
+	return i
+}
+
+// SetH2cInstance 处理以HTTP/2 preface开头的prior knowledge连接
+func (i *muxInstance) SetH2cInstance(h2c *h2cInstance) *muxInstance {
+	i.h2c = h2c
+	return i
+}
+
+// SetWSInstance 未设置httpInstance时, http/1请求直接交由wsInstance处理
+func (i *muxInstance) SetWSInstance(ws *wsInstance) *muxInstance {
+	i.ws = ws
+	return i
+}
+
+// WithCertificate 收到TLS ClientHello时由muxInstance完成握手, 再识别内层协议
+func (i *muxInstance) WithCertificate(cert tls.Certificate) *muxInstance {
+	if i.tlsConfig == nil {
+		i.tlsConfig = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	}
+	i.tlsConfig.Certificates = []tls.Certificate{cert}
+	i.tlsConfig.Rand = rand.Reader
+	return i
+}
+
+func (i *muxInstance) WithCertStore(store *play.CertStore) *muxInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	i.tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	return i
+}
+
+func (i *muxInstance) Run(listener net.Listener, udplistener net.PacketConn) error {
+	var tcp, http, h2c, ws *muxListener
+	if i.tcp != nil {
+		tcp = i.serve(i.tcp, listener.Addr())
+	}
+	if i.http != nil {
+		http = i.serve(i.http, listener.Addr())
+	}
+	if i.h2c != nil {
+		h2c = i.serve(i.h2c, listener.Addr())
+	}
+	if i.ws != nil {
+		ws = i.serve(i.ws, listener.Addr())
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go i.dispatch(conn, tcp, http, h2c, ws)
+	}
+}
+
+func (i *muxInstance) serve(server play.IServer, addr net.Addr) *muxListener {
+	l := &muxListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+	i.listeners = append(i.listeners, l)
+	go func() {
+		defer func() {
+			if panicInfo := recover(); panicInfo != nil {
+				fmt.Printf("panic: %v\n%v", panicInfo, string(debug.Stack()))
+			}
+		}()
+		_ = server.Run(l, nil)
+	}()
+	server.Hook().OnBoot(server)
+	return l
+}
+
+func (i *muxInstance) dispatch(conn net.Conn, tcp, http, h2c, ws *muxListener) {
+	var err error
+	var head []byte
+	var reader = bufio.NewReader(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(SniffTimeout))
+	if head, err = reader.Peek(4); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	if head[0] == 0x16 && head[1] == 0x03 && i.tlsConfig != nil {
+		tlsConn := tls.Server(&sniffConn{Conn: conn, reader: reader}, i.tlsConfig)
+		if err = tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return
+		}
+		_ = conn.SetReadDeadline(time.Time{})
+
+		// 协商了ALPN的连接直接交给http实例, 以便其识别*tls.Conn并启用h2
+		if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto == "h2" || proto == "http/1.1" {
+			if target := firstListener(http, ws); target != nil {
+				target.push(tlsConn)
+				return
+			}
+		}
+
+		reader = bufio.NewReader(tlsConn)
+		_ = tlsConn.SetReadDeadline(time.Now().Add(SniffTimeout))
+		if head, err = reader.Peek(4); err != nil {
+			_ = tlsConn.Close()
+			return
+		}
+		_ = tlsConn.SetReadDeadline(time.Time{})
+		if target := i.match(head, reader, tcp, http, h2c, ws); target != nil {
+			target.push(&sniffTlsConn{Conn: tlsConn, reader: reader})
+		} else {
+			_ = tlsConn.Close()
+		}
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+	if target := i.match(head, reader, tcp, http, h2c, ws); target != nil {
+		target.push(&sniffConn{Conn: conn, reader: reader})
+	} else {
+		_ = conn.Close()
+	}
+}
+
+func (i *muxInstance) match(head []byte, reader *bufio.Reader, tcp, http, h2c, ws *muxListener) *muxListener {
+	switch {
+	case bytes.Equal(head, []byte("==>>")):
+		return tcp
+	case bytes.Equal(head, []byte("PRI ")):
+		if preface, err := reader.Peek(len(http2Preface)); err == nil && bytes.Equal(preface, http2Preface) {
+			return h2c
+		}
+		return nil
+	}
+	for _, method := range httpMethods {
+		if bytes.Equal(head, method) {
+			return firstListener(http, ws)
+		}
+	}
+	return nil
+}
+
+var http2Preface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+
+func firstListener(listeners ...*muxListener) *muxListener {
+	for _, l := range listeners {
+		if l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+func (i *muxInstance) Close() {
+	for _, l := range i.listeners {
+		_ = l.Close()
+	}
+	for _, server := range i.subInstances() {
+		server.Hook().OnShutdown(server)
+		server.Close()
+	}
+	i.ctrl.WaitTask()
+}
+
+func (i *muxInstance) subInstances() (list []play.IServer) {
+	if i.tcp != nil {
+		list = append(list, i.tcp)
+	}
+	if i.http != nil {
+		list = append(list, i.http)
+	}
+	if i.h2c != nil {
+		list = append(list, i.h2c)
+	}
+	if i.ws != nil {
+		list = append(list, i.ws)
+	}
+	return
+}
+
+func (i *muxInstance) Info() play.InstanceInfo {
+	return i.info
+}
+
+func (i *muxInstance) Ctrl() *play.InstanceCtrl {
+	return i.ctrl
+}
+
+func (i *muxInstance) Hook() play.IServerHook {
+	return i.hook
+}
+
+func (i *muxInstance) Packer() play.IPacker {
+	return nil
+}
+
+func (i *muxInstance) Transport(conn *play.Conn, data []byte) error {
+	return errors.New("mux instance can not transport, sessions belong to sub instances")
+}
+
+func (i *muxInstance) Network() string {
+	return "tcp"
+}
+
+// muxListener 将muxInstance识别后的连接交给子实例的Run
+type muxListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *muxListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		_ = conn.Close()
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("use of closed network connection")
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
+
+// sniffConn 先读取已被Peek的字节
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// sniffTlsConn 保留*tls.Conn的ConnectionState, 供TcpInstance获取客户端证书
+type sniffTlsConn struct {
+	*tls.Conn
+	reader io.Reader
+}
+
+func (c *sniffTlsConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
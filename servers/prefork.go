@@ -0,0 +1,187 @@
+package servers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/leochen2038/play"
+)
+
+const (
+	envPreforkWorker = "PLAY_PREFORK_WORKER"
+	envPreforkSocket = "PLAY_PREFORK_SOCKET"
+)
+
+var (
+	preforkNum int
+	master     *preforkMaster
+)
+
+// SetPrefork 开启多进程模式, Boot时由master进程启动n个worker进程,
+// tcp/udp由各worker通过SO_REUSEPORT监听同一端口, unix socket由master创建后继承给worker
+func SetPrefork(n int) {
+	preforkNum = n
+}
+
+func isPreforkWorker() bool {
+	return os.Getenv(envPreforkWorker) != ""
+}
+
+func getPreforkSocket(name string) uintptr {
+	return getInheritSocket(os.Getenv(envPreforkSocket), name)
+}
+
+type preforkMaster struct {
+	mu        sync.Mutex
+	files     []*os.File
+	listeners []net.Listener
+	tags      []string
+	workers   map[int]*exec.Cmd
+	stopping  bool
+	wg        sync.WaitGroup
+}
+
+func runPreforkMaster(is []play.IServer) error {
+	m := &preforkMaster{workers: make(map[int]*exec.Cmd, preforkNum)}
+	for idx, i := range is {
+		if i == nil {
+			continue
+		}
+		if network, _ := parseAddress(i.Network(), i.Info().Address); network == "unix" {
+			listener, _, err := listen(i, idx)
+			if err != nil {
+				m.closeListeners()
+				return err
+			}
+			file, err := listener.(filer).File()
+			if err != nil {
+				_ = listener.Close()
+				m.closeListeners()
+				return err
+			}
+			m.listeners = append(m.listeners, listener)
+			m.tags = append(m.tags, i.Info().Name+":"+strconv.Itoa(len(m.files)))
+			m.files = append(m.files, file)
+		}
+	}
+
+	master = m
+	for id := 0; id < preforkNum; id++ {
+		if err := m.startWorker(id); err != nil {
+			m.stop()
+			return err
+		}
+	}
+	m.wg.Wait()
+	m.closeListeners()
+	return nil
+}
+
+func (m *preforkMaster) startWorker(id int) error {
+	argv0, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+
+	var env []string
+	for _, v := range os.Environ() {
+		if !strings.HasPrefix(v, envGraceful) && !strings.HasPrefix(v, "LISTEN_") && !strings.HasPrefix(v, "PLAY_PREFORK_") {
+			env = append(env, v)
+		}
+	}
+	env = append(env, fmt.Sprintf("%s=%d", envPreforkWorker, id+1))
+	env = append(env, fmt.Sprintf("%s=%s", envPreforkSocket, strings.Join(m.tags, "-")))
+
+	cmd := exec.Command(argv0, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = m.files
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.workers[id] = cmd
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go m.watch(id, cmd)
+	return nil
+}
+
+// watch worker异常退出时重新拉起
+func (m *preforkMaster) watch(id int, cmd *exec.Cmd) {
+	defer m.wg.Done()
+	err := cmd.Wait()
+
+	m.mu.Lock()
+	current, stopping := m.workers[id] == cmd, m.stopping
+	if current {
+		delete(m.workers, id)
+	}
+	m.mu.Unlock()
+
+	if current && !stopping {
+		fmt.Printf("[play server] prefork worker %d exit: %v, restarting\n", cmd.Process.Pid, err)
+		time.Sleep(time.Second)
+		if err = m.startWorker(id); err != nil {
+			fmt.Println("[play server] restart prefork worker error:", err)
+		}
+	}
+}
+
+// reload 启动新一批worker后通知旧worker退出, SO_REUSEPORT保证期间端口持续可用
+func (m *preforkMaster) reload() error {
+	m.mu.Lock()
+	var olds = make([]*exec.Cmd, 0, len(m.workers))
+	for _, cmd := range m.workers {
+		olds = append(olds, cmd)
+	}
+	m.mu.Unlock()
+
+	for id := 0; id < preforkNum; id++ {
+		if err := m.startWorker(id); err != nil {
+			return err
+		}
+	}
+	for _, cmd := range olds {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+	}
+	return nil
+}
+
+func (m *preforkMaster) stop() {
+	m.mu.Lock()
+	m.stopping = true
+	for _, cmd := range m.workers {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+	}
+	m.mu.Unlock()
+}
+
+func (m *preforkMaster) closeListeners() {
+	for _, f := range m.files {
+		_ = f.Close()
+	}
+	for _, l := range m.listeners {
+		_ = l.Close()
+	}
+}
+
+// watchMaster worker进程在master退出后随之退出
+func watchMaster() {
+	ppid := os.Getppid()
+	for range time.Tick(time.Second) {
+		if os.Getppid() != ppid {
+			ShutdownAll()
+			os.Exit(0)
+		}
+	}
+}
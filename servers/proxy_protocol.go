@@ -0,0 +1,169 @@
+package servers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leochen2038/play"
+)
+
+// ProxyHeaderTimeout 读取PROXY protocol头的超时时间
+var ProxyHeaderTimeout = 5 * time.Second
+
+var proxyV2Signature = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+
+// proxyListener 来自可信地址的连接可携带PROXY protocol v1/v2头, 连接的RemoteAddr为头中的源地址
+type proxyListener struct {
+	net.Listener
+	trusted play.CIDRList
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !l.trusted.Contains(play.AddrIP(conn.RemoteAddr().String())) {
+		return conn, err
+	}
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn 在首次Read或RemoteAddr时读取头, 避免阻塞Accept
+type proxyConn struct {
+	net.Conn
+	once   sync.Once
+	reader *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(ProxyHeaderTimeout))
+		c.reader = bufio.NewReader(c.Conn)
+		c.remote, c.err = readProxyHeader(c.reader)
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.init(); c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.init(); c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader 未携带头或为LOCAL、UNKNOWN时返回nil地址
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := r.Peek(6); err != nil || string(prefix) != "PROXY " {
+			return nil, nil
+		}
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		if prefix, err := r.Peek(len(proxyV2Signature)); err != nil || !bytes.Equal(prefix, proxyV2Signature) {
+			return nil, nil
+		}
+		return readProxyV2(r)
+	}
+	return nil, nil
+}
+
+// readProxyV1 PROXY TCP4 srcip dstip srcport dstport\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if line = append(line, b); b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid proxy protocol v1 header")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid proxy protocol v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("invalid proxy protocol v1 address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header = make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("unsupported proxy protocol version")
+	}
+	var body = make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	// LOCAL命令为负载均衡器的健康检查
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 1:
+		if len(body) < 12 {
+			return nil, errors.New("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2:
+		if len(body) < 36 {
+			return nil, errors.New("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
+
+// clientAddr 直连地址为可信代理时, 从X-Forwarded-For由右向左取第一个非可信代理的地址
+func clientAddr(r *http.Request, trusted play.CIDRList) string {
+	addr := r.RemoteAddr
+	if len(trusted) == 0 || !trusted.Contains(play.AddrIP(addr)) {
+		return addr
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[idx]))
+		if ip == nil {
+			break
+		}
+		if addr = ip.String(); !trusted.Contains(ip) {
+			break
+		}
+	}
+	return addr
+}
@@ -45,6 +45,23 @@ func (i *quicInstance) SetTlsConfig(tlsconfig *tls.Config) {
 	i.tlsconfig = tlsconfig
 }
 
+// WithCertStore 按SNI选择证书并支持热加载, 需在Run之前调用
+func (i *quicInstance) WithCertStore(store *play.CertStore) *quicInstance {
+	if i.tlsconfig == nil {
+		i.tlsconfig = &tls.Config{NextProtos: []string{i.info.Name}}
+	}
+	i.tlsconfig.GetCertificate = store.GetCertificate
+	return i
+}
+
+func (i *quicInstance) WithClientCA(pool *x509.CertPool, required bool) *quicInstance {
+	if i.tlsconfig == nil {
+		i.tlsconfig = &tls.Config{NextProtos: []string{i.info.Name}}
+	}
+	i.tlsconfig = withClientCA(i.tlsconfig, pool, required)
+	return i
+}
+
 func (i *quicInstance) SetQuicConfig(config *quic.Config) {
 	i.quicConfig = config
 }
@@ -66,13 +83,13 @@ func (i *quicInstance) Packer() play.IPacker {
 }
 
 func (i *quicInstance) Transport(conn *play.Conn, data []byte) (err error) {
-	var stream quic.Stream
-	if conn.Quic.Stream != nil {
-		stream = conn.Quic.Stream
-	} else {
-		if stream, err = conn.Quic.Conn.OpenStreamSync(context.Background()); err != nil {
+	var stream quic.SendStream = conn.Quic.Stream
+	if conn.Quic.Stream == nil {
+		// 服务端推送, 每条消息使用新的单向写入stream
+		if stream, err = conn.Quic.Conn.OpenUniStreamSync(context.Background()); err != nil {
 			return
 		}
+		defer stream.Close()
 	}
 
 	_, err = stream.Write(data)
@@ -84,8 +101,8 @@ func (i *quicInstance) Network() string {
 }
 
 func (i *quicInstance) Run(listener net.Listener, udplistener net.PacketConn) (err error) {
-	var tlsconfig *tls.Config
-	if i.tlsconfig == nil {
+	var tlsconfig = i.tlsconfig
+	if tlsconfig == nil {
 		tlsconfig = generateTLSConfig([]string{i.info.Name})
 	}
 	i.quicServer, err = quic.Listen(udplistener, tlsconfig, i.quicConfig)
@@ -108,15 +125,21 @@ func (i *quicInstance) Run(listener net.Listener, udplistener net.PacketConn) (e
 		go func(conn quic.Connection) {
 			s := play.NewSession(context.Background(), i)
 			s.Conn.Quic.Conn = conn
+			state := conn.ConnectionState().TLS.ConnectionState
+			s.Peer, s.RemoteAddr = play.NewPeerIdentity(&state), conn.RemoteAddr().String()
 			defer func() {
 				if panicInfo := recover(); panicInfo != nil {
 					fmt.Printf("panic: %v\n%v", panicInfo, string(debug.Stack()))
 				}
 			}()
 			defer func() {
+				play.RemoveSession(s)
+				i.ctrl.RemoveSession(s)
 				i.hook.OnClose(s, err)
 			}()
 			i.hook.OnConnect(s, err)
+			play.RegisterSession(s)
+			i.ctrl.AddSession(s)
 
 			for {
 				select {
@@ -134,6 +157,7 @@ func (i *quicInstance) Run(listener net.Listener, udplistener net.PacketConn) (e
 					}
 					go func(strean quic.Stream) {
 						ss := play.NewSession(s.Context(), i)
+						ss.User, ss.Peer, ss.RemoteAddr = s.User, s.Peer, s.RemoteAddr
 						ss.Conn.Quic.Conn = conn
 						ss.Conn.Quic.Stream = stream
 
@@ -145,9 +169,9 @@ func (i *quicInstance) Run(listener net.Listener, udplistener net.PacketConn) (e
 						}()
 						if err == nil {
 							if i.onceStream {
-								err = i.onReadyOnce(ss)
+								err = i.onReadyOnce(s, ss)
 							} else {
-								err = i.onReady(ss)
+								err = i.onReady(s, ss)
 							}
 						}
 					}(stream)
@@ -157,14 +181,16 @@ func (i *quicInstance) Run(listener net.Listener, udplistener net.PacketConn) (e
 	}
 }
 
-func (i *quicInstance) onReadyOnce(s *play.Session) (err error) {
+// onReadyOnce parent为连接级session, 记录客户端协议版本供推送使用
+func (i *quicInstance) onReadyOnce(parent *play.Session, s *play.Session) (err error) {
 	var request *play.Request
 	if request, err = i.packer.Receive(s.Conn); err != nil {
 		return
 	}
 
 	s.Conn.Quic.Stream.CancelRead(0)
-	s.Conn.Quic.Version = request.Version
+	s.RaiseVersion(request.Version)
+	parent.RaiseVersion(request.Version)
 	if err = doRequest(context.Background(), s, request); err != nil {
 		return err
 	}
@@ -172,7 +198,7 @@ func (i *quicInstance) onReadyOnce(s *play.Session) (err error) {
 	return
 }
 
-func (i *quicInstance) onReady(s *play.Session) (err error) {
+func (i *quicInstance) onReady(parent *play.Session, s *play.Session) (err error) {
 	var request *play.Request
 
 	for {
@@ -185,10 +211,14 @@ func (i *quicInstance) onReady(s *play.Session) (err error) {
 		if request == nil {
 			continue
 		}
-		if request.Version > s.Conn.Quic.Version {
-			s.Conn.Quic.Version = request.Version
+		s.RaiseVersion(request.Version)
+		parent.RaiseVersion(request.Version)
+		if i.ctrl.Draining() {
+			err = rejectDraining(s, request)
+		} else {
+			err = doRequest(context.Background(), s, request)
 		}
-		if err = doRequest(context.Background(), s, request); err != nil {
+		if err != nil {
 			return
 		}
 	}
@@ -0,0 +1,235 @@
+package servers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HttpSecurity http、h2c、sse、ws共用的安全策略: CORS、websocket origin校验、安全响应头及double-submit-cookie CSRF;
+// 挂载在httpInstance上的ws、sse沿用httpInstance的策略
+type HttpSecurity struct {
+	origins       []string
+	methods       []string
+	headers       []string
+	exposeHeaders []string
+	credentials   bool
+	maxAge        time.Duration
+	respHeaders   map[string]string
+	hsts          string
+	csrf          bool
+	csrfCookie    string
+	csrfHeader    string
+	csrfField     string
+}
+
+func NewHttpSecurity() *HttpSecurity {
+	return &HttpSecurity{
+		methods: []string{"GET", "POST", "OPTIONS"},
+		headers: []string{"Content-Type", "Authorization", "X-Requested-With"},
+		maxAge:  10 * time.Minute,
+		respHeaders: map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "DENY",
+			"Referrer-Policy":        "strict-origin-when-cross-origin",
+		},
+		hsts: "max-age=31536000",
+	}
+}
+
+// WithOrigins 允许跨域的origin, 支持 * 及 https://*.example.com; 未设置时不允许跨域
+func (s *HttpSecurity) WithOrigins(origins ...string) *HttpSecurity {
+	s.origins = origins
+	return s
+}
+
+func (s *HttpSecurity) WithMethods(methods ...string) *HttpSecurity {
+	s.methods = methods
+	return s
+}
+
+func (s *HttpSecurity) WithHeaders(headers ...string) *HttpSecurity {
+	s.headers = headers
+	return s
+}
+
+func (s *HttpSecurity) WithExposeHeaders(headers ...string) *HttpSecurity {
+	s.exposeHeaders = headers
+	return s
+}
+
+func (s *HttpSecurity) WithCredentials(allow bool) *HttpSecurity {
+	s.credentials = allow
+	return s
+}
+
+// WithMaxAge 预检结果的缓存时间, 默认10分钟
+func (s *HttpSecurity) WithMaxAge(d time.Duration) *HttpSecurity {
+	s.maxAge = d
+	return s
+}
+
+// WithResponseHeader 设置安全响应头, value为空时不输出该响应头
+func (s *HttpSecurity) WithResponseHeader(name string, value string) *HttpSecurity {
+	s.respHeaders[name] = value
+	return s
+}
+
+// WithHSTS tls请求输出Strict-Transport-Security, 为空时不输出
+func (s *HttpSecurity) WithHSTS(value string) *HttpSecurity {
+	s.hsts = value
+	return s
+}
+
+// WithCSRF 开启CSRF校验: 响应中下发cookie, 表单提交时须在header或表单字段中携带相同的值
+func (s *HttpSecurity) WithCSRF(cookie string, header string, field string) *HttpSecurity {
+	s.csrf, s.csrfCookie, s.csrfHeader, s.csrfField = true, cookie, header, field
+	if s.csrfCookie == "" {
+		s.csrfCookie = "csrf_token"
+	}
+	if s.csrfHeader == "" {
+		s.csrfHeader = "X-CSRF-Token"
+	}
+	if s.csrfField == "" {
+		s.csrfField = "csrf_token"
+	}
+	if !containsFold(s.headers, s.csrfHeader) {
+		s.headers = append(s.headers, s.csrfHeader)
+	}
+	return s
+}
+
+// handle 输出安全响应头及CORS响应头, 返回false时请求已被处理(预检或拒绝)
+func (s *HttpSecurity) handle(w http.ResponseWriter, r *http.Request) bool {
+	header := w.Header()
+	for k, v := range s.respHeaders {
+		if v != "" {
+			header.Set(k, v)
+		}
+	}
+	if r.TLS != nil && s.hsts != "" {
+		header.Set("Strict-Transport-Security", s.hsts)
+	}
+
+	origin := r.Header.Get("Origin")
+	allowed := origin != "" && s.allowOrigin(origin)
+	if origin != "" {
+		header.Add("Vary", "Origin")
+	}
+	if allowed {
+		if containsFold(s.origins, "*") && !s.credentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if s.credentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(s.exposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(s.exposeHeaders, ", "))
+		}
+	}
+
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		if !allowed || !containsFold(s.methods, r.Header.Get("Access-Control-Request-Method")) {
+			w.WriteHeader(http.StatusForbidden)
+			return false
+		}
+		for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if h = strings.TrimSpace(h); h != "" && !containsFold(s.headers, h) {
+				w.WriteHeader(http.StatusForbidden)
+				return false
+			}
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(s.methods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(s.headers, ", "))
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(s.maxAge/time.Second)))
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	if s.csrf && !s.checkCSRF(w, r) {
+		http.Error(w, "csrf token mismatch", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// checkOrigin websocket升级时校验origin, 未携带Origin的非浏览器客户端不校验
+func (s *HttpSecurity) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u := "://" + r.Host; strings.HasSuffix(origin, u) {
+		return true
+	}
+	return s.allowOrigin(origin)
+}
+
+func (s *HttpSecurity) allowOrigin(origin string) bool {
+	for _, v := range s.origins {
+		if v == "*" || strings.EqualFold(v, origin) {
+			return true
+		}
+		if idx := strings.Index(v, "://*."); idx > 0 {
+			scheme, suffix := v[:idx+3], v[idx+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkCSRF 浏览器可跨站提交的表单请求须携带与cookie一致的token; 未持有cookie时下发
+func (s *HttpSecurity) checkCSRF(w http.ResponseWriter, r *http.Request) bool {
+	var token string
+	if c, err := r.Cookie(s.csrfCookie); err == nil {
+		token = c.Value
+	}
+	if token == "" {
+		var b = make([]byte, 16)
+		_, _ = rand.Read(b)
+		http.SetCookie(w, &http.Cookie{Name: s.csrfCookie, Value: hex.EncodeToString(b), Path: "/", Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/x-www-form-urlencoded", "multipart/form-data", "text/plain", "":
+	default:
+		return true
+	}
+	if token == "" {
+		return false
+	}
+
+	submitted := r.Header.Get(s.csrfHeader)
+	if submitted == "" && contentType == "application/x-www-form-urlencoded" {
+		_ = r.ParseForm()
+		submitted = r.PostForm.Get(s.csrfField)
+	} else if submitted == "" && contentType == "multipart/form-data" {
+		// 与http packer使用相同的内存上限, 之后packer不会重复解析
+		_ = r.ParseMultipartForm(4096)
+		submitted = r.PostForm.Get(s.csrfField)
+	}
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) == 1
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package servers
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
@@ -22,6 +24,7 @@ type sseInstance struct {
 
 	tlsConfig  *tls.Config
 	httpServer http.Server
+	security   *HttpSecurity
 }
 
 func NewSSEInstance(name string, addr string, hook play.IServerHook, packer play.IPacker) *sseInstance {
@@ -43,9 +46,20 @@ func (i *sseInstance) WithCertificate(cert tls.Certificate) *sseInstance {
 	return i
 }
 
+func (i *sseInstance) WithCertStore(store *play.CertStore) *sseInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	return i
+}
+
+func (i *sseInstance) WithClientCA(pool *x509.CertPool, required bool) *sseInstance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
 func (i *sseInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var sess = play.NewSession(r.Context(), i)
+	sess.Peer, sess.RemoteAddr = play.NewPeerIdentity(r.TLS), r.RemoteAddr
 
 	defer func() {
 		recover()
@@ -56,8 +70,17 @@ func (i *sseInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if i.security != nil && !i.security.handle(w, r) {
+		return
+	}
 	sess.Conn.Http.Request, sess.Conn.Http.ResponseWriter = r, w
-	i.accept(sess)
+	i.accept(sess, i.security != nil)
+}
+
+// WithSecurity 设置CORS及安全响应头, 未设置时允许任意origin
+func (i *sseInstance) WithSecurity(security *HttpSecurity) *sseInstance {
+	i.security = security
+	return i
 }
 
 func (i *sseInstance) update(r *http.Request) error {
@@ -68,7 +91,8 @@ func (i *sseInstance) update(r *http.Request) error {
 	return nil
 }
 
-func (i *sseInstance) accept(s *play.Session) {
+// accept secured为true时CORS响应头已由安全策略输出
+func (i *sseInstance) accept(s *play.Session, secured bool) {
 	var err error
 	var w = s.Conn.Http.ResponseWriter
 
@@ -79,9 +103,13 @@ func (i *sseInstance) accept(s *play.Session) {
 	}()
 
 	defer func() {
+		play.RemoveSession(s)
+		i.ctrl.RemoveSession(s)
 		i.hook.OnClose(s, err)
 	}()
 	i.hook.OnConnect(s, nil)
+	play.RegisterSession(s)
+	i.ctrl.AddSession(s)
 
 	if _, ok := w.(http.Flusher); !ok {
 		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
@@ -89,7 +117,9 @@ func (i *sseInstance) accept(s *play.Session) {
 		return
 	}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !secured {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -133,8 +163,23 @@ func (i *sseInstance) Packer() play.IPacker {
 	return i.packer
 }
 
+// Transport 按event-stream格式写入data行, 多行数据逐行加前缀; 推送时以event行标明action
 func (i *sseInstance) Transport(conn *play.Conn, data []byte) error {
-	conn.Http.ResponseWriter.(http.Flusher).Flush()
+	w := conn.Http.ResponseWriter
+	if conn.Http.Event != "" {
+		if _, err := w.Write([]byte("event: " + conn.Http.Event + "\n")); err != nil {
+			return err
+		}
+	}
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if _, err := w.Write(append(append([]byte("data: "), line...), '\n')); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write([]byte{'\n'}); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
 	return nil
 }
 
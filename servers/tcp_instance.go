@@ -2,20 +2,37 @@ package servers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/leochen2038/play"
 	"github.com/leochen2038/play/packers"
 )
 
+// tlsConn *tls.Conn 或 muxInstance 中包装后的tls连接
+type tlsConn interface {
+	net.Conn
+	Handshake() error
+	ConnectionState() tls.ConnectionState
+}
+
 type TcpInstance struct {
 	info   play.InstanceInfo
 	hook   play.IServerHook
 	ctrl   *play.InstanceCtrl
 	packer play.IPacker
+
+	concurrency int
+	tlsConfig   *tls.Config
+	proxies     play.CIDRList
+	ipFilter    *play.IPFilter
 }
 
 func NewTcpInstance(name string, addr string, hook play.IServerHook, packer play.IPacker) *TcpInstance {
@@ -28,11 +45,35 @@ func NewTcpInstance(name string, addr string, hook play.IServerHook, packer play
 	return &TcpInstance{info: play.InstanceInfo{Name: name, Address: addr, Type: play.SERVER_TYPE_TCP}, packer: packer, hook: hook, ctrl: new(play.InstanceCtrl)}
 }
 
+// WithConcurrency 开启单连接并发处理, 每个连接最多同时执行n个请求, 响应按traceId匹配
+func (i *TcpInstance) WithConcurrency(n int) *TcpInstance {
+	i.concurrency = n
+	return i
+}
+
+// WithProxyProtocol 来自trusted网段(负载均衡器)的连接解析PROXY protocol v1/v2头获取客户端地址
+func (i *TcpInstance) WithProxyProtocol(trusted play.CIDRList) *TcpInstance {
+	i.proxies = trusted
+	return i
+}
+
+// WithIPFilter 按客户端地址拒绝连接
+func (i *TcpInstance) WithIPFilter(filter *play.IPFilter) *TcpInstance {
+	i.ipFilter = filter
+	return i
+}
+
 func (i *TcpInstance) onReady(s *play.Session) (err error) {
 	var n int
 	var buffer = make([]byte, 4096)
 	var request *play.Request
 	var conn = s.Conn.Tcp.Conn
+	var dispatch = newDispatcher(i.concurrency)
+	var abort = func(error) {
+		s.Close()
+		_ = conn.Close()
+	}
+	defer dispatch.wait()
 
 	for {
 		sessContext := s.Context()
@@ -44,16 +85,15 @@ func (i *TcpInstance) onReady(s *play.Session) (err error) {
 				return
 			}
 			s.Conn.Tcp.Surplus = append(s.Conn.Tcp.Surplus, buffer[:n]...)
-			if request, err = i.packer.Receive(s.Conn); err != nil {
-				return
-			}
-			if request == nil {
-				continue
-			} else {
-				if request.Version > s.Conn.Tcp.Version {
-					s.Conn.Tcp.Version = request.Version
+			for {
+				if request, err = i.packer.Receive(s.Conn); err != nil {
+					return
+				}
+				if request == nil {
+					break
 				}
-				if err = doRequest(context.Background(), s, request); err != nil {
+				s.RaiseVersion(request.Version)
+				if err = dispatch.dispatch(s, request, abort); err != nil {
 					return err
 				}
 			}
@@ -82,7 +122,32 @@ func (i *TcpInstance) Ctrl() *play.InstanceCtrl {
 	return i.ctrl
 }
 
+func (i *TcpInstance) WithCertificate(cert tls.Certificate) *TcpInstance {
+	if i.tlsConfig == nil {
+		i.tlsConfig = &tls.Config{}
+	}
+	i.tlsConfig.Certificates = []tls.Certificate{cert}
+	i.tlsConfig.Rand = rand.Reader
+	return i
+}
+
+func (i *TcpInstance) WithCertStore(store *play.CertStore) *TcpInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	return i
+}
+
+func (i *TcpInstance) WithClientCA(pool *x509.CertPool, required bool) *TcpInstance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
 func (i *TcpInstance) Run(listener net.Listener, udplistener net.PacketConn) error {
+	if len(i.proxies) > 0 {
+		listener = &proxyListener{Listener: listener, trusted: i.proxies}
+	}
+	if i.tlsConfig != nil {
+		listener = tls.NewListener(listener, i.tlsConfig)
+	}
 	for {
 		var err error
 		var conn net.Conn
@@ -95,6 +160,19 @@ func (i *TcpInstance) Run(listener net.Listener, udplistener net.PacketConn) err
 		go func(err error, conn net.Conn) {
 			s := play.NewSession(context.Background(), i)
 			s.Conn.Tcp.Conn = conn
+			if err == nil {
+				if s.RemoteAddr = conn.RemoteAddr().String(); !i.ipFilter.Allow(s.RemoteAddr) {
+					err = errors.New("ip " + s.RemoteAddr + " not allowed")
+				}
+			}
+			if tc, ok := conn.(tlsConn); ok && err == nil {
+				_ = tc.SetDeadline(time.Now().Add(10 * time.Second))
+				if err = tc.Handshake(); err == nil {
+					_ = tc.SetDeadline(time.Time{})
+					state := tc.ConnectionState()
+					s.Peer = play.NewPeerIdentity(&state)
+				}
+			}
 
 			defer func() {
 				if panicInfo := recover(); panicInfo != nil {
@@ -106,9 +184,13 @@ func (i *TcpInstance) Run(listener net.Listener, udplistener net.PacketConn) err
 			}()
 
 			defer func() {
+				play.RemoveSession(s)
+				i.ctrl.RemoveSession(s)
 				i.hook.OnClose(s, err)
 			}()
 			i.hook.OnConnect(s, err)
+			play.RegisterSession(s)
+			i.ctrl.AddSession(s)
 
 			if err == nil {
 				err = i.onReady(s)
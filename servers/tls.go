@@ -0,0 +1,33 @@
+package servers
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/leochen2038/play"
+)
+
+// withCertStore 使用证书库按SNI选择证书, 证书文件变化时无需重启
+func withCertStore(config *tls.Config, store *play.CertStore) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.GetCertificate = store.GetCertificate
+	config.Rand = rand.Reader
+	return config
+}
+
+// withClientCA 开启mTLS, required为false时客户端可不提供证书
+func withClientCA(config *tls.Config, pool *x509.CertPool, required bool) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.ClientCAs = pool
+	if required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config
+}
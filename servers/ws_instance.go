@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
@@ -25,8 +26,10 @@ type wsInstance struct {
 	ctrl   *play.InstanceCtrl
 	packer play.IPacker
 
-	tlsConfig  *tls.Config
-	httpServer http.Server
+	tlsConfig   *tls.Config
+	httpServer  http.Server
+	concurrency int
+	security    *HttpSecurity
 }
 
 func NewWsInstance(name string, addr string, hook play.IServerHook, packer play.IPacker) *wsInstance {
@@ -43,12 +46,13 @@ func (i *wsInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var conn *websocket.Conn
 	var sess = play.NewSession(r.Context(), i)
+	sess.Peer, sess.RemoteAddr = play.NewPeerIdentity(r.TLS), r.RemoteAddr
 
 	defer func() {
 		recover()
 	}()
 
-	if conn, err = i.update(w, r); err != nil {
+	if conn, err = i.update(w, r, i.security); err != nil {
 		i.hook.OnConnect(sess, err)
 		return
 	}
@@ -70,9 +74,13 @@ func (i *wsInstance) accept(s *play.Session) {
 	}()
 
 	defer func() {
+		play.RemoveSession(s)
+		i.ctrl.RemoveSession(s)
 		i.hook.OnClose(s, err)
 	}()
 	i.hook.OnConnect(s, nil)
+	play.RegisterSession(s)
+	i.ctrl.AddSession(s)
 
 	if request, err = i.packer.Receive(s.Conn); request != nil {
 		if err = doRequest(context.Background(), s, request); err != nil {
@@ -83,7 +91,26 @@ func (i *wsInstance) accept(s *play.Session) {
 	err = i.onReady(s)
 }
 
+// WithSecurity 升级websocket时按策略校验origin
+func (i *wsInstance) WithSecurity(security *HttpSecurity) *wsInstance {
+	i.security = security
+	return i
+}
+
+// WithConcurrency 开启单连接并发处理, 每个连接最多同时执行n个请求
+func (i *wsInstance) WithConcurrency(n int) *wsInstance {
+	i.concurrency = n
+	return i
+}
+
 func (i *wsInstance) onReady(sess *play.Session) error {
+	var dispatch = newDispatcher(i.concurrency)
+	var abort = func(error) {
+		sess.Close()
+		_ = sess.Conn.Websocket.WebsocketConn.Close()
+	}
+	defer dispatch.wait()
+
 	for {
 		sessContext := sess.Context()
 		select {
@@ -96,20 +123,24 @@ func (i *wsInstance) onReady(sess *play.Session) error {
 			}
 
 			sess.Conn.Websocket.Message = message
-			sess.Conn.Websocket.MessageType = messageType
 
-			if request, err := i.packer.Receive(sess.Conn); err != nil {
-				return err
-			} else {
-				if err := doRequest(context.Background(), sess, request); err != nil {
-					return err
+			// 一条消息可包含多个请求(如jsonrpc batch), Request.More时继续Receive
+			request, err := i.packer.Receive(sess.Conn)
+			for ; err == nil && request != nil; request, err = i.packer.Receive(sess.Conn) {
+				request.MessageType = messageType
+				if err = dispatch.dispatch(sess, request, abort); err != nil || !request.More {
+					break
 				}
 			}
+			if err != nil {
+				return err
+			}
 		}
 	}
 }
 
-func (i *wsInstance) update(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+// update security不为空时按策略校验origin
+func (i *wsInstance) update(w http.ResponseWriter, r *http.Request, security *HttpSecurity) (*websocket.Conn, error) {
 	if len(r.Header["Upgrade"]) == 0 {
 		return nil, errors.New("err websocket connect")
 	}
@@ -117,7 +148,11 @@ func (i *wsInstance) update(w http.ResponseWriter, r *http.Request) (*websocket.
 	if r.Header["Upgrade"][0] != "websocket" {
 		return nil, errors.New("err websocket connect")
 	}
-	if conn, err := upgrader.Upgrade(w, r, nil); err != nil {
+	var u = upgrader
+	if security != nil {
+		u.CheckOrigin = security.checkOrigin
+	}
+	if conn, err := u.Upgrade(w, r, nil); err != nil {
 		return nil, errors.New("[websocket server] upgrade websocket failure:" + err.Error())
 	} else {
 		return conn, nil
@@ -157,6 +192,16 @@ func (i *wsInstance) WithCertificate(cert tls.Certificate) *wsInstance {
 	return i
 }
 
+func (i *wsInstance) WithCertStore(store *play.CertStore) *wsInstance {
+	i.tlsConfig = withCertStore(i.tlsConfig, store)
+	return i
+}
+
+func (i *wsInstance) WithClientCA(pool *x509.CertPool, required bool) *wsInstance {
+	i.tlsConfig = withClientCA(i.tlsConfig, pool, required)
+	return i
+}
+
 func (i *wsInstance) Run(listener net.Listener, udplistener net.PacketConn) error {
 	i.httpServer.Handler = i
 	if i.tlsConfig != nil {
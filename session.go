@@ -2,17 +2,28 @@ package play
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
+// sessions 可推送的长连接session, 以SessId为key
+var sessions sync.Map
+
 type Session struct {
-	SessId    string
-	User      interface{}
-	Conn      *Conn
-	Server    IServer
-	ctx       context.Context
-	ctxCancel context.CancelFunc
+	SessId     string
+	User       interface{}
+	Peer       *PeerIdentity // mTLS客户端证书身份, 未提供客户端证书时为nil
+	RemoteAddr string        // 客户端真实地址ip:port, 经PROXY protocol解析; 取自可信代理的X-Forwarded-For时不含端口
+	Conn       *Conn
+	Server     IServer
+	Ordered    bool // 并发模式下要求该连接上的请求按顺序执行
+	writeMu    sync.Mutex
+	ctx        context.Context
+	ctxCancel  context.CancelFunc
 }
 
 func NewSession(cxt context.Context, server IServer) *Session {
@@ -28,6 +39,14 @@ func NewSession(cxt context.Context, server IServer) *Session {
 func (s *Session) Write(res *Response) (err error) {
 	if res != nil {
 		var data []byte
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		if res.MessageType != 0 {
+			s.Conn.Websocket.MessageType = res.MessageType
+		}
+		if s.Conn.Type == SERVER_TYPE_SSE {
+			s.Conn.Http.Event = res.Action
+		}
 		if data, err = s.Server.Packer().Pack(s.Conn, res); err == nil && len(data) > 0 {
 			err = s.Server.Transport(s.Conn, data)
 		}
@@ -39,6 +58,77 @@ func (s *Session) Write(res *Response) (err error) {
 	return err
 }
 
+// Push 向tcp/quic客户端主动推送消息, 需要pproto v4及以上版本;
+// http请求中则作为同一响应中的一个消息发送, 如grpc stream消息或json转码的一行; websocket中作为一条消息发送, sse中作为一个事件发送
+func (s *Session) Push(action string, payload map[string]interface{}) error {
+	var version byte
+	switch s.Conn.Type {
+	case SERVER_TYPE_TCP, SERVER_TYPE_QUIC:
+		version = s.Version()
+	case SERVER_TYPE_HTTP, SERVER_TYPE_H2C, SERVER_TYPE_HTTP3, SERVER_TYPE_WS, SERVER_TYPE_SSE:
+		if s.Conn.Http.Request == nil {
+			return errors.New("push unsupported on closed http request")
+		}
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+		res := &Response{TraceId: NewTraceId(), RenderName: "json", Action: action}
+		for k, v := range payload {
+			res.Output.Set(k, v)
+		}
+		return s.Write(res)
+	default:
+		return errors.New("push unsupported on server type " + strconv.Itoa(s.Conn.Type))
+	}
+	if version == 0 {
+		version = 4
+	} else if version < 4 {
+		return errors.New("push requires pproto v4 or later, but client is v" + strconv.Itoa(int(version)))
+	}
+
+	res := &Response{Version: version, TraceId: NewTraceId(), RenderName: "json", Action: action}
+	for k, v := range payload {
+		res.Output.Set(k, v)
+	}
+	return s.Write(res)
+}
+
+// RaiseVersion 记录客户端使用过的最高pproto版本, 推送时使用; 在写锁内更新, 避免与并发执行的响应竞争
+func (s *Session) RaiseVersion(version byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	switch s.Conn.Type {
+	case SERVER_TYPE_TCP:
+		if version > s.Conn.Tcp.Version {
+			s.Conn.Tcp.Version = version
+		}
+	case SERVER_TYPE_QUIC:
+		if version > s.Conn.Quic.Version {
+			s.Conn.Quic.Version = version
+		}
+	}
+}
+
+// Version 客户端使用过的最高pproto版本
+func (s *Session) Version() byte {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	switch s.Conn.Type {
+	case SERVER_TYPE_TCP:
+		return s.Conn.Tcp.Version
+	case SERVER_TYPE_QUIC:
+		return s.Conn.Quic.Version
+	}
+	return 0
+}
+
+// WriteLocked 在写锁内执行f, 用于写入关闭通知等控制帧, 避免与响应交错
+func (s *Session) WriteLocked(f func() error) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return f()
+}
+
 func (s *Session) Close() {
 	s.ctxCancel()
 }
@@ -46,3 +136,41 @@ func (s *Session) Close() {
 func (s *Session) Context() context.Context {
 	return s.ctx
 }
+
+// RegisterSession 登记长连接session以便查找和推送, 连接关闭时需调用RemoveSession
+func RegisterSession(s *Session) {
+	sessions.Store(s.SessId, s)
+}
+
+func RemoveSession(s *Session) {
+	sessions.Delete(s.SessId)
+}
+
+func GetSession(sessId string) *Session {
+	if v, ok := sessions.Load(sessId); ok {
+		return v.(*Session)
+	}
+	return nil
+}
+
+// GetSessionsByUser 查找Session.User等于user的所有session, user须为可比较类型
+func GetSessionsByUser(user interface{}) (list []*Session) {
+	if user == nil || !reflect.TypeOf(user).Comparable() {
+		return
+	}
+	userType := reflect.TypeOf(user)
+	sessions.Range(func(key, value interface{}) bool {
+		s := value.(*Session)
+		if s.User != nil && reflect.TypeOf(s.User) == userType && s.User == user {
+			list = append(list, s)
+		}
+		return true
+	})
+	return
+}
+
+func RangeSession(f func(s *Session) bool) {
+	sessions.Range(func(key, value interface{}) bool {
+		return f(value.(*Session))
+	})
+}
@@ -1,6 +1,7 @@
 package play
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
@@ -15,6 +16,7 @@ type GroupSocket struct {
 	maxConn     int           // 连接池中每台服务器最多连接数 0：表示不限制
 	maxWaitTime time.Duration //获取连接最大等待时间 0：表示不限制
 	hosts       map[string]map[string]int
+	tlsConfig   *tls.Config
 }
 
 type socketWeightPool struct {
@@ -26,6 +28,7 @@ type weighted struct {
 	host                  string
 	weight, currentWeight int
 	connChans             chan *SocketConn
+	tlsConfig             *tls.Config
 }
 
 type SocketConn struct {
@@ -34,13 +37,14 @@ type SocketConn struct {
 	dead bool
 }
 
-func newWeightPool(hosts map[string]int, maxIdle int) *socketWeightPool {
+func newWeightPool(hosts map[string]int, maxIdle int, tlsConfig *tls.Config) *socketWeightPool {
 	weightPool := &socketWeightPool{hostsWeighted: make(map[string]*weighted, len(hosts))}
 	for k, v := range hosts {
 		weightPool.hostsWeighted[k] = &weighted{
 			host:      k,
 			weight:    v,
 			connChans: make(chan *SocketConn, maxIdle),
+			tlsConfig: tlsConfig,
 		}
 	}
 
@@ -51,6 +55,12 @@ func NewGroupSocket(maxIdle int) *GroupSocket {
 	return &GroupSocket{groups: make(map[string]*socketWeightPool, 1), maxIdle: maxIdle, hosts: make(map[string]map[string]int, 1)}
 }
 
+// WithTlsConfig 使用tls连接各组服务器, 需在SetGroup/SetHost之前调用
+func (gs *GroupSocket) WithTlsConfig(config *tls.Config) *GroupSocket {
+	gs.tlsConfig = config
+	return gs
+}
+
 func (gs *GroupSocket) SetGroup(groupName string, hosts map[string]int) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -61,7 +71,7 @@ func (gs *GroupSocket) SetGroup(groupName string, hosts map[string]int) {
 		}
 	}
 
-	gs.groups[groupName] = newWeightPool(hosts, gs.maxIdle)
+	gs.groups[groupName] = newWeightPool(hosts, gs.maxIdle, gs.tlsConfig)
 	gs.hosts[groupName] = hosts
 }
 
@@ -71,10 +81,10 @@ func (gs *GroupSocket) SetHost(groupName, host string, weight int) {
 
 	if ghost, ok := gs.hosts[groupName]; !ok {
 		gs.hosts[groupName] = map[string]int{host: weight}
-		gs.groups[groupName] = newWeightPool(map[string]int{host: weight}, gs.maxIdle)
+		gs.groups[groupName] = newWeightPool(map[string]int{host: weight}, gs.maxIdle, gs.tlsConfig)
 	} else {
 		if _, ok := ghost[host]; !ok {
-			gs.groups[groupName].hostsWeighted[host] = &weighted{host: host, weight: weight, connChans: make(chan *SocketConn, gs.maxIdle)}
+			gs.groups[groupName].hostsWeighted[host] = &weighted{host: host, weight: weight, connChans: make(chan *SocketConn, gs.maxIdle), tlsConfig: gs.tlsConfig}
 		} else {
 			gs.groups[groupName].hostsWeighted[host].weight = weight
 		}
@@ -121,7 +131,7 @@ func (gs *GroupSocket) GetSocketConnByGroupName(groupName string) (*SocketConn,
 	if pool, ok = gs.groups[groupName]; !ok {
 		gs.mu.Lock()
 		if pool, ok = gs.groups[groupName]; !ok {
-			gs.groups[groupName] = newWeightPool(gs.hosts[groupName], gs.maxIdle)
+			gs.groups[groupName] = newWeightPool(gs.hosts[groupName], gs.maxIdle, gs.tlsConfig)
 			pool = gs.groups[groupName]
 		}
 		gs.mu.Unlock()
@@ -165,7 +175,13 @@ func (w *weighted) getConn() (*SocketConn, error) {
 	case conn := <-w.connChans:
 		return conn, nil
 	default:
-		nconn, err := net.DialTimeout("tcp", w.host, 50*time.Millisecond)
+		var err error
+		var nconn net.Conn
+		if w.tlsConfig != nil {
+			nconn, err = tls.DialWithDialer(&net.Dialer{Timeout: 50 * time.Millisecond}, "tcp", w.host, w.tlsConfig)
+		} else {
+			nconn, err = net.DialTimeout("tcp", w.host, 50*time.Millisecond)
+		}
 		if err != nil {
 			return nil, err
 		}